@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Versões da API REST. /v1 é o contrato atual; /v2 usa recursos no plural e envelope nas respostas.
+// As rotas sem prefixo são o legado: servidas como v1, com os headers Deprecation e Sunset.
+const (
+	apiV1 = "v1"
+	apiV2 = "v2"
+)
+
+// unversionedRoutes ficam só na raiz, fora do versionamento
+var unversionedRoutes = map[string]bool{
+	"/health":       true,
+	"/openapi.json": true,
+	"/docs":         true,
+	"/docs/{asset}": true,
+}
+
+// v2PluralSegments converte os segmentos no singular das rotas v1 para o plural da v2
+var v2PluralSegments = map[string]string{
+	"product":     "products",
+	"category":    "categories",
+	"location":    "locations",
+	"reservation": "reservations",
+	"webhook":     "webhooks",
+	"delivery":    "deliveries",
+}
+
+// v2Path converte um path (ou template do mux) da v1 para a v2: /product/{id} => /products/{id}
+func v2Path(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if plural, ok := v2PluralSegments[segment]; ok {
+			segments[i] = plural
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// v2OperationKeys liga cada operação da v2 ("GET /products/{id}") à operação equivalente da v1
+var v2OperationKeys = func() map[string]string {
+	keys := map[string]string{}
+	for key := range openAPIOperations {
+		method, path, _ := strings.Cut(key, " ")
+		if !unversionedRoutes[path] {
+			keys[method+" "+v2Path(path)] = key
+		}
+	}
+	return keys
+}()
+
+// routeContract identifica a operação do contrato (chave de openAPIOperations) e a versão de uma rota.
+// Rotas do legado devolvem a versão v1 com legacy=true; as rotas fora do versionamento, versão vazia.
+func routeContract(method, template string) (key, version string, legacy bool) {
+	path := openAPIPath(template)
+	switch {
+	case strings.HasPrefix(path, "/"+apiV1+"/"):
+		return method + " " + strings.TrimPrefix(path, "/"+apiV1), apiV1, false
+	case strings.HasPrefix(path, "/"+apiV2+"/"):
+		return v2OperationKeys[method+" "+strings.TrimPrefix(path, "/"+apiV2)], apiV2, false
+	case unversionedRoutes[path]:
+		return method + " " + path, "", false
+	default:
+		return method + " " + path, apiV1, true
+	}
+}
+
+// registerV2Routes registra na v2 as mesmas rotas (e handlers) da v1, com os paths no plural
+func registerV2Routes(v1, v2 *mux.Router) error {
+	return v1.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		v2.Handle(v2Path(strings.TrimPrefix(template, "/"+apiV1)), route.GetHandler()).Methods(methods...)
+		return nil
+	})
+}
+
+// --- Deprecation do legado ---
+
+// legacyRouteDate lê uma data (AAAA-MM-DD) do ambiente, com o padrão def
+func legacyRouteDate(env, def string) time.Time {
+	value := os.Getenv(env)
+	if value == "" {
+		value = def
+	}
+	date, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		logrus.WithError(err).Warnf("%s inválido, usando %s", env, def)
+		date, _ = time.Parse(time.DateOnly, def)
+	}
+	return date
+}
+
+type apiVersionKey struct{}
+
+// apiVersionFromContext devolve a versão da API da requisição (vazia fora do versionamento)
+func apiVersionFromContext(ctx context.Context) string {
+	version, _ := ctx.Value(apiVersionKey{}).(string)
+	return version
+}
+
+// apiVersionMiddleware coloca a versão da rota no contexto, conta as requisições por versão e
+// marca as rotas do legado com Deprecation (RFC 9745), Sunset (RFC 8594) e o Link para a /v1
+func apiVersionMiddleware(next http.Handler) http.Handler {
+	deprecatedAt := legacyRouteDate("LEGACY_ROUTES_DEPRECATED_AT", "2026-11-01")
+	sunset := legacyRouteDate("LEGACY_ROUTES_SUNSET", "2027-05-01")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		_, version, legacy := routeContract(r.Method, template)
+		if version == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		apiVersionRequestsTotal.With(prometheus.Labels{"version": version, "deprecated": strconv.FormatBool(legacy)}).Inc()
+		trace.SpanFromContext(r.Context()).SetAttributes(
+			attribute.String("api.version", version),
+			attribute.Bool("api.deprecated", legacy),
+		)
+		if legacy {
+			w.Header().Set("Deprecation", "@"+strconv.FormatInt(deprecatedAt.Unix(), 10))
+			w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			w.Header().Add("Link", `</`+apiV1+r.URL.Path+`>; rel="successor-version"`)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version)))
+	})
+}
+
+// --- Envelope da v2 ---
+
+// v2Envelope é o corpo das respostas de sucesso da v2. Os erros continuam em problem+json.
+type v2Envelope struct {
+	Data interface{} `json:"data"`
+	Meta *v2PageMeta `json:"meta,omitempty"`
+}
+
+// MarshalXML escreve data com as listas em <item>, como nos campos de lista da v1
+func (e v2Envelope) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	return enc.EncodeElement(struct {
+		Data interface{} `xml:"data"`
+		Meta *v2PageMeta `xml:"meta"`
+	}{Data: xmlValue(e.Data), Meta: e.Meta}, start)
+}
+
+// v2PageMeta é a paginação das listas, que na v1 fica junto dos itens
+type v2PageMeta struct {
+	Total      *int   `json:"total,omitempty" xml:"total,omitempty"`
+	Limit      int    `json:"limit" xml:"limit"`
+	Offset     int    `json:"offset" xml:"offset"`
+	NextCursor string `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
+}
+
+// v2PageItems são os campos com os itens das páginas da v1, por schema do OpenAPI
+var v2PageItems = map[string]string{
+	"ProductPage":  "products",
+	"LowStockPage": "products",
+	"RevisionPage": "revisions",
+	"MovementPage": "movements",
+	"AuditPage":    "events",
+	"DeliveryPage": "deliveries",
+}
+
+// v1Page é uma página da v1, com os itens e a paginação no mesmo objeto
+type v1Page interface {
+	pageItems() interface{}
+	pageMeta() v2PageMeta
+}
+
+// pageLimits é a paginação por limit/offset das páginas da v1
+type pageLimits struct {
+	Limit  int `json:"limit" xml:"limit"`
+	Offset int `json:"offset" xml:"offset"`
+}
+
+func (p pageLimits) pageMeta() v2PageMeta {
+	return v2PageMeta{Limit: p.Limit, Offset: p.Offset}
+}
+
+// envelopeV2 coloca a resposta da v1 no envelope da v2. Nas páginas, data recebe os itens
+// e a paginação vai para meta.
+func envelopeV2(data interface{}) v2Envelope {
+	if page, ok := data.(v1Page); ok {
+		meta := page.pageMeta()
+		return v2Envelope{Data: page.pageItems(), Meta: &meta}
+	}
+	return v2Envelope{Data: data}
+}
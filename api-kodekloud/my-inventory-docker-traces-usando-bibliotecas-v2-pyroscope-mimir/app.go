@@ -3,20 +3,29 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 	"github.com/sirupsen/logrus"
 
+	"github.com/getkin/kin-openapi/openapi3"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
 
 	// Import para o trace
 	"github.com/XSAM/otelsql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"go.opentelemetry.io/otel/trace"
 	// Trace para o mux
@@ -25,15 +34,7 @@ import (
 
 // --- Funções sendError e sendResponse  ---
 func sendError(w http.ResponseWriter, r *http.Request, status int, err error) {
-	logrus.WithContext(r.Context()).WithFields(logrus.Fields{
-		"component": "http_handler",
-		"status":    status,
-		"error":     err.Error(),
-	}).Error("Erro na requisição")
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	sendProblem(w, r, newProblem(r, status, err), err)
 }
 
 func sendResponse(ctx context.Context, w http.ResponseWriter, status int, data interface{}) {
@@ -42,22 +43,71 @@ func sendResponse(ctx context.Context, w http.ResponseWriter, status int, data i
 		"status":    status,
 	})
 
-	w.Header().Set("Content-Type", "application/json")
+	if data != nil && apiVersionFromContext(ctx) == apiV2 {
+		data = envelopeV2(data)
+	}
+
+	format := responseFormatFromContext(ctx)
+	w.Header().Set("Content-Type", responseContentTypes[format])
 	w.WriteHeader(status)
 	if data != nil {
-		err := json.NewEncoder(w).Encode(data)
+		err := writeResponseBody(w, format, data)
 		if err != nil {
-			logger.WithError(err).Error("Erro ao codificar a resposta JSON")
+			logger.WithError(err).WithField("format", format).Error("Erro ao codificar a resposta")
 			return
 		}
 		logger.Debug("Resposta enviada com sucesso")
 	}
 }
 
+// resultResponse é o corpo das operações que não devolvem um recurso
+type resultResponse struct {
+	Result  string `json:"result" xml:"result"`
+	Message string `json:"message,omitempty" xml:"message,omitempty"`
+}
+
+// healthStatus é a resposta do GET /health
+type healthStatus struct {
+	Status   string `json:"status" xml:"status"`
+	Database string `json:"database" xml:"database"`
+}
+
 // --- Estrutura App  ---
 type App struct {
 	Router *mux.Router
 	DB     *sql.DB
+	Stream *productStream // eventos do GET /products/stream
+	// OpenAPI é o documento de /openapi.json, gerado a partir das rotas na inicialização
+	OpenAPI []byte
+	// openAPIDoc é o mesmo documento carregado no kin-openapi, para validar os corpos das requisições
+	openAPIDoc *openapi3.T
+	// Images guarda os arquivos das imagens de produto (sistema de arquivos local ou S3)
+	Images blobStore
+}
+
+// httpSpanName nomeia o span da requisição como "MÉTODO template" (GET /v1/product/{id:[0-9]+}),
+// já que rotas como PUT e PATCH compartilham o mesmo template. Sem rota, mantém o nome do otelmux.
+func httpSpanName(routeName string, r *http.Request) string {
+	if mux.CurrentRoute(r) == nil {
+		return routeName
+	}
+	return r.Method + " " + routeName
+}
+
+// useMiddlewares registra a cadeia no Router. O mux chama os handlers de 404 e 405 fora dela,
+// então eles recebem a mesma cadeia: têm span, trace_id no problem+json e métricas.
+func (app *App) useMiddlewares(middlewares ...mux.MiddlewareFunc) {
+	app.Router.Use(middlewares...)
+	app.Router.NotFoundHandler = withMiddlewares(http.HandlerFunc(notFoundHandler), middlewares)
+	app.Router.MethodNotAllowedHandler = withMiddlewares(http.HandlerFunc(methodNotAllowedHandler), middlewares)
+}
+
+// withMiddlewares aplica a cadeia a h na mesma ordem do Router.Use: o primeiro middleware é o mais externo
+func withMiddlewares(h http.Handler, middlewares []mux.MiddlewareFunc) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
 }
 
 // --- Método Initialise ---
@@ -125,25 +175,105 @@ func (app *App) Initialise(sqlTracerProvider trace.TracerProvider) error {
 
 	logrus.Infof("Conexão com o banco de dados MySQL (%s@%s) instrumentada com OTEL (serviço: my-inventory-mysql) estabelecida com sucesso", dbName, dbHost)
 
+	if app.Images, err = newBlobStore(ctx); err != nil {
+		app.DB.Close()
+		return fmt.Errorf("falha ao configurar o armazenamento das imagens: %w", err)
+	}
+
 	app.Router = mux.NewRouter().StrictSlash(true)
+	app.Stream = newProductStream()
 	// ORDEM CORRETA DOS MIDDLEWARES: Tracing PRIMEIRO, depois Prometheus
-	app.Router.Use(otelmux.Middleware("inventory-app")) // Tracing primeiro!
-	app.Router.Use(prometheusMiddleware)                // Métricas depois
-	app.HandleRequests()
+	app.useMiddlewares(
+		otelmux.Middleware("inventory-app", otelmux.WithSpanNameFormatter(httpSpanName)), // Tracing primeiro!
+		prometheusMiddleware,            // Métricas depois
+		actorMiddleware,                 // Autor das escritas, para a auditoria
+		productEventsMiddleware,         // Eventos de produto publicados no stream após o commit
+		apiVersionMiddleware,            // Versão da API (v1, v2 ou legado com Deprecation)
+		contentNegotiationMiddleware,    // Accept (JSON, XML, CSV, MessagePack) e corpos XML/MessagePack em JSON
+		app.openAPIValidationMiddleware, // Corpos JSON conferidos com o OpenAPI antes dos handlers
+	)
+	if err = app.HandleRequests(); err != nil {
+		app.DB.Close()
+		return fmt.Errorf("falha ao registrar as rotas: %w", err)
+	}
+	if app.OpenAPI, err = buildOpenAPISpec(app.Router); err != nil {
+		app.DB.Close()
+		return fmt.Errorf("falha ao gerar o documento OpenAPI: %w", err)
+	}
+	if app.openAPIDoc, err = loadOpenAPIDoc(app.OpenAPI); err != nil {
+		app.DB.Close()
+		return fmt.Errorf("documento OpenAPI inválido: %w", err)
+	}
 	go app.startBackgroundProductCountUpdate()
+	go app.startBackgroundTrashPurge()
+	go app.startBackgroundReservationSweeper()
+	go app.startBackgroundWebhookDispatcher()
 
 	logrus.Info("Aplicação inicializada com sucesso")
 	return nil
 }
 
 // --- Método HandleRequests  ---
-func (app *App) HandleRequests() {
-	app.Router.HandleFunc("/products", app.getProducts).Methods("GET")
-	app.Router.HandleFunc("/product/{id:[0-9]+}", app.getProduct).Methods("GET")
-	app.Router.HandleFunc("/product", app.createProduct).Methods("POST")
-	app.Router.HandleFunc("/product/{id:[0-9]+}", app.updateProduct).Methods("PUT")
-	app.Router.HandleFunc("/product/{id:[0-9]+}", app.deleteProduct).Methods("DELETE")
+// As rotas de handleV1Routes ficam em /v1, em /v2 (no plural, com envelope) e na raiz, como legado
+func (app *App) HandleRequests() error {
+	v1 := app.Router.PathPrefix("/" + apiV1).Subrouter()
+	app.handleV1Routes(v1)
+	if err := registerV2Routes(v1, app.Router.PathPrefix("/"+apiV2).Subrouter()); err != nil {
+		return err
+	}
+	app.handleV1Routes(app.Router)
 	app.Router.HandleFunc("/health", app.healthCheck).Methods("GET")
+	app.Router.HandleFunc("/openapi.json", app.serveOpenAPI).Methods("GET")
+	app.Router.HandleFunc("/docs", app.serveDocs).Methods("GET")
+	app.Router.HandleFunc("/docs/{asset:[a-z0-9-]+\\.(?:css|js|png)}", app.serveDocsAsset).Methods("GET")
+	return nil
+}
+
+// handleV1Routes registra o contrato da v1 em r
+func (app *App) handleV1Routes(r *mux.Router) {
+	r.HandleFunc("/products", app.getProducts).Methods("GET")
+	r.HandleFunc("/product/{id:[0-9]+}", app.getProduct).Methods("GET")
+	r.HandleFunc("/product/sku/{sku:[A-Za-z0-9._-]+}", app.getProductBySKU).Methods("GET")
+	r.HandleFunc("/product", app.createProduct).Methods("POST")
+	r.HandleFunc("/products/bulk", app.bulkProducts).Methods("POST")
+	r.HandleFunc("/products/trash", app.getTrash).Methods("GET")
+	r.HandleFunc("/products/low-stock", app.getLowStock).Methods("GET")
+	r.HandleFunc(productStreamPath, app.streamProducts).Methods("GET")
+	r.HandleFunc("/products/export.csv", app.exportProducts).Methods("GET")
+	r.HandleFunc("/products/import", app.importProducts).Methods("POST")
+	r.HandleFunc("/product/{id:[0-9]+}/restore", app.restoreProduct).Methods("POST")
+	r.HandleFunc("/product/{id:[0-9]+}/stock", app.createStockMovement).Methods("POST")
+	r.HandleFunc("/product/{id:[0-9]+}/movements", app.getStockMovements).Methods("GET")
+	r.HandleFunc("/product/{id:[0-9]+}/history", app.getProductHistory).Methods("GET")
+	r.HandleFunc("/product/{id:[0-9]+}/stock", app.getProductStock).Methods("GET")
+	r.HandleFunc("/product/{id:[0-9]+}/transfers", app.createTransfer).Methods("POST")
+	r.HandleFunc("/product/{id:[0-9]+}/reservations", app.createReservation).Methods("POST")
+	r.HandleFunc("/product/{id:[0-9]+}/images", app.uploadProductImage).Methods("POST")
+	r.HandleFunc("/product/{id:[0-9]+}/images", app.getProductImages).Methods("GET")
+	r.HandleFunc("/product/{id:[0-9]+}/images/{image_id:[0-9]+}", app.getProductImage).Methods("GET")
+	r.HandleFunc("/product/{id:[0-9]+}/images/{image_id:[0-9]+}/thumbnail", app.getProductImageThumbnail).Methods("GET")
+	r.HandleFunc("/product/{id:[0-9]+}/images/{image_id:[0-9]+}", app.deleteProductImage).Methods("DELETE")
+	r.HandleFunc("/reservation/{id:[0-9]+}", app.getReservation).Methods("GET")
+	r.HandleFunc("/reservation/{id:[0-9]+}/confirm", app.confirmReservation).Methods("POST")
+	r.HandleFunc("/reservation/{id:[0-9]+}/cancel", app.cancelReservation).Methods("POST")
+	r.HandleFunc("/product/{id:[0-9]+}", app.updateProduct).Methods("PUT")
+	r.HandleFunc("/product/{id:[0-9]+}", app.patchProduct).Methods("PATCH")
+	r.HandleFunc("/product/{id:[0-9]+}", app.deleteProduct).Methods("DELETE")
+	r.HandleFunc("/categories", app.getCategories).Methods("GET")
+	r.HandleFunc("/category/{id:[0-9]+}", app.getCategory).Methods("GET")
+	r.HandleFunc("/category", app.createCategory).Methods("POST")
+	r.HandleFunc("/category/{id:[0-9]+}", app.updateCategory).Methods("PUT")
+	r.HandleFunc("/category/{id:[0-9]+}", app.deleteCategory).Methods("DELETE")
+	r.HandleFunc("/locations", app.getLocations).Methods("GET")
+	r.HandleFunc("/location", app.createLocation).Methods("POST")
+	r.HandleFunc("/audit", app.getAuditEvents).Methods("GET")
+	r.HandleFunc("/webhooks", app.getWebhooks).Methods("GET")
+	r.HandleFunc("/webhooks/dead-letters", app.getDeadLetters).Methods("GET")
+	r.HandleFunc("/webhook/{id:[0-9]+}", app.getWebhook).Methods("GET")
+	r.HandleFunc("/webhook", app.createWebhook).Methods("POST")
+	r.HandleFunc("/webhook/{id:[0-9]+}", app.updateWebhook).Methods("PUT")
+	r.HandleFunc("/webhook/{id:[0-9]+}", app.deleteWebhook).Methods("DELETE")
+	r.HandleFunc("/webhook/delivery/{id:[0-9]+}/retry", app.retryDeadLetter).Methods("POST")
 }
 
 // --- Método Run  ---
@@ -151,6 +281,20 @@ func (app *App) Run(addr string) {
 	logrus.Infof("Lógica de execução movida para main.go para integração com otelhttp.")
 }
 
+// withTx roda fn em uma transação: commit se fn não devolver erro, rollback caso contrário
+func (app *App) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := app.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("erro ao iniciar transação: %w", err)
+	}
+	defer tx.Rollback() // Sem efeito se o Commit já tiver acontecido
+	if err := fn(tx); err != nil {
+		app.Stream.publishCommitted(ctx, false)
+		return err
+	}
+	return app.commitTx(ctx, tx)
+}
+
 // --- Handlers da API  ---
 func (app *App) getProducts(w http.ResponseWriter, r *http.Request) {
 	// Extrai span do contexto para logs com trace_id e span_id
@@ -167,7 +311,18 @@ func (app *App) getProducts(w http.ResponseWriter, r *http.Request) {
 	}
 	entry.Info("Iniciando busca de produtos")
 
-	products, err := getProductsFromDB(r.Context(), app.DB)
+	q, err := parseProductQuery(r.URL.Query())
+	if err != nil {
+		entry.WithError(err).Warn("Parâmetros de consulta inválidos para listar produtos")
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	products, hasMore, err := getProductsFromDB(r.Context(), app.DB, q)
+	total := 0
+	if err == nil {
+		total, err = countFilteredProducts(r.Context(), app.DB, q)
+	}
 	if err != nil {
 		logEntry := logrus.WithContext(r.Context()).WithError(err).WithFields(logrus.Fields{
 			"component": "http_handler",
@@ -196,13 +351,24 @@ func (app *App) getProducts(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 	successEntry.Info("Listando produtos")
-	sendResponse(r.Context(), w, http.StatusOK, products)
+
+	page := productPage{Products: products, Total: total, Limit: q.Limit, Offset: q.Offset}
+	if hasMore && len(products) > 0 {
+		page.NextCursor = q.nextCursor(products[len(products)-1])
+	}
+	sendResponse(r.Context(), w, http.StatusOK, page)
 }
 
 func (app *App) getProduct(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key, _ := strconv.Atoi(vars["id"])
 
+	// ?as_of=<RFC3339> devolve o estado do produto naquele instante, a partir do histórico
+	if asOf := r.URL.Query().Get("as_of"); asOf != "" {
+		app.getProductAsOf(w, r, key, asOf)
+		return
+	}
+
 	p := product{ID: key}
 	// Passa o contexto da requisição para a função do banco de dados
 	err := p.getProduct(r.Context(), app.DB) // Passando r.Context()
@@ -219,6 +385,87 @@ func (app *App) getProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	logrus.WithContext(r.Context()).WithField("product_id", key).Info("Exibindo produto")
+	w.Header().Set("ETag", productETag(p.Version))
+	sendResponse(r.Context(), w, http.StatusOK, p)
+}
+
+// getProductAsOf responde o GET /product/{id}?as_of=. Sem ETag: a revisão é histórica
+// e não serve de pré-condição para escritas.
+func (app *App) getProductAsOf(w http.ResponseWriter, r *http.Request, key int, raw string) {
+	asOf, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		sendError(w, r, http.StatusBadRequest, errors.New("as_of must be an RFC3339 timestamp"))
+		return
+	}
+
+	rev, err := getProductAsOf(r.Context(), app.DB, key, asOf)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logrus.WithContext(r.Context()).WithFields(logrus.Fields{"product_id": key, "as_of": raw}).Info("Produto não existia no instante pedido")
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("product with ID %d did not exist at %s", key, asOf.Format(time.RFC3339)))
+		} else {
+			logrus.WithContext(r.Context()).WithError(err).WithField("product_id", key).Error("Erro ao buscar revisão do produto")
+			sqlErrorsTotal.Inc()
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve product"))
+		}
+		return
+	}
+	logrus.WithContext(r.Context()).WithFields(logrus.Fields{"product_id": key, "as_of": raw, "version": rev.Version}).Info("Exibindo revisão do produto")
+	sendResponse(r.Context(), w, http.StatusOK, rev)
+}
+
+// getProductHistory lista as revisões do produto (limit/offset), inclusive de produtos na lixeira
+func (app *App) getProductHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, _ := strconv.Atoi(vars["id"])
+	logger := logrus.WithContext(r.Context()).WithFields(logrus.Fields{
+		"component":  "http_handler",
+		"operation":  "get_product_history",
+		"product_id": key,
+	})
+
+	limit, offset, err := parseLimitOffset(r.URL.Query())
+	if err != nil {
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	revisions, err := getProductHistory(r.Context(), app.DB, key, limit, offset)
+	if err != nil {
+		logger.WithError(err).Error("Erro ao obter histórico do produto")
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve product history"))
+		return
+	}
+	// O histórico sobrevive ao purge da lixeira, então a ausência de revisões é o que indica 404
+	if len(revisions) == 0 && offset == 0 {
+		logger.Info("Produto sem histórico")
+		sendError(w, r, http.StatusNotFound, fmt.Errorf("product with ID %d not found", key))
+		return
+	}
+
+	logger.WithField("num_revisions", len(revisions)).Info("Listando histórico do produto")
+	sendResponse(r.Context(), w, http.StatusOK, revisionPage{Revisions: revisions, pageLimits: pageLimits{Limit: limit, Offset: offset}})
+}
+
+func (app *App) getProductBySKU(w http.ResponseWriter, r *http.Request) {
+	sku := mux.Vars(r)["sku"]
+
+	p := product{SKU: sku}
+	err := p.getProductBySKU(r.Context(), app.DB)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logrus.WithContext(r.Context()).WithField("sku", sku).Info("Produto não encontrado pelo SKU")
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("product with SKU %q not found", sku))
+		} else {
+			logrus.WithContext(r.Context()).WithError(err).WithField("sku", sku).Error("Erro ao buscar produto pelo SKU no banco de dados")
+			sqlErrorsTotal.Inc()
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve product"))
+		}
+		return
+	}
+	logrus.WithContext(r.Context()).WithFields(logrus.Fields{"product_id": p.ID, "sku": sku}).Info("Exibindo produto pelo SKU")
+	w.Header().Set("ETag", productETag(p.Version))
 	sendResponse(r.Context(), w, http.StatusOK, p)
 }
 
@@ -235,14 +482,60 @@ func (app *App) createProduct(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	if p.Name == "" || p.Price < 0 || p.Quantity < 0 {
+	if err := p.validate(); err != nil {
 		logrus.WithContext(r.Context()).Warn("Tentativa de criar produto com dados inválidos")
-		sendError(w, r, http.StatusBadRequest, errors.New("invalid product data: name is required, price and quantity cannot be negative"))
+		sendError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
+	// Idempotency-Key: a chave é reservada na mesma transação do INSERT do produto
+	idempotencyKey := strings.TrimSpace(r.Header.Get(idempotencyKeyHeader))
+	var requestHash string
+	if idempotencyKey != "" {
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			sendError(w, r, http.StatusBadRequest, fmt.Errorf("%s must have at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+		var err error
+		if requestHash, err = idempotencyRequestHash(r.Method, r.URL.Path, p); err != nil {
+			logrus.WithContext(r.Context()).WithError(err).Error("Erro ao calcular hash da requisição idempotente")
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to create product"))
+			return
+		}
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.Bool("idempotency.key_present", true))
+	}
+
 	// Passa o contexto da requisição para a função do banco de dados
-	err := p.createProduct(r.Context(), app.DB) // <<< MODIFICADO: Passando r.Context()
+	err := app.withTx(r.Context(), func(tx *sql.Tx) error {
+		if idempotencyKey != "" {
+			if err := claimIdempotencyKey(r.Context(), tx, idempotencyKey, requestHash, idempotencyKeyTTL()); err != nil {
+				return err
+			}
+		}
+		if err := p.createProduct(r.Context(), tx); err != nil {
+			return err
+		}
+		if idempotencyKey != "" {
+			return saveIdempotentResponse(r.Context(), tx, idempotencyKey, http.StatusCreated, p)
+		}
+		return nil
+	})
+	if errors.Is(err, errIdempotencyKeyExists) {
+		app.replayIdempotentResponse(w, r, idempotencyKey, requestHash)
+		return
+	}
+	if idempotencyKey != "" {
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.Bool("idempotency.replayed", false))
+	}
+	if isMySQLError(err, mysqlErrNoReferencedRow) {
+		logrus.WithContext(r.Context()).WithError(err).Warn("Tentativa de criar produto com categoria inexistente")
+		sendError(w, r, http.StatusBadRequest, categoryNotFoundError(p))
+		return
+	}
+	if isMySQLError(err, mysqlErrDuplicateEntry) {
+		sendDuplicateSKU(w, r, p)
+		return
+	}
 	if err != nil {
 		logrus.WithContext(r.Context()).WithError(err).Error("Erro ao criar produto no banco de dados")
 		sqlErrorsTotal.Inc()
@@ -251,13 +544,58 @@ func (app *App) createProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	logrus.WithContext(r.Context()).WithField("product_id", p.ID).Info("Produto criado")
+	w.Header().Set("ETag", productETag(p.Version))
 	sendResponse(r.Context(), w, http.StatusCreated, p)
 }
 
+// replayIdempotentResponse devolve de novo o status e o corpo gravados para a Idempotency-Key.
+// A mesma chave com outro payload é um erro do cliente (422), não uma repetição.
+func (app *App) replayIdempotentResponse(w http.ResponseWriter, r *http.Request, key, requestHash string) {
+	logger := logrus.WithContext(r.Context()).WithField("component", "http_handler")
+	res, err := getIdempotentResponse(r.Context(), app.DB, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		// A chave venceu entre o INSERT e a leitura: o cliente pode simplesmente repetir
+		sendError(w, r, http.StatusConflict, fmt.Errorf("%s expired while the request was processed, retry the request", idempotencyKeyHeader))
+		return
+	}
+	if err != nil {
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to create product"))
+		return
+	}
+	if res.RequestHash != requestHash {
+		logger.Warn("Idempotency-Key reutilizada com outro payload")
+		sendError(w, r, http.StatusUnprocessableEntity, withProblemType(problemIdempotencyReuse, "Idempotency-Key reused",
+			fmt.Errorf("%s was already used with a different request payload", idempotencyKeyHeader)))
+		return
+	}
+
+	idempotentReplaysTotal.Inc()
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.Bool("idempotency.replayed", true))
+	// A resposta guardada é o produto criado; relido como product, ele volta no formato negociado
+	var stored product
+	if err := json.Unmarshal(res.Body, &stored); err != nil {
+		logger.WithError(err).Error("Erro ao ler a resposta idempotente guardada")
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to create product"))
+		return
+	}
+	if stored.Version > 0 {
+		w.Header().Set("ETag", productETag(stored.Version))
+	}
+	logger.WithField("product_id", stored.ID).Info("Resposta idempotente repetida")
+	w.Header().Set("Idempotent-Replayed", "true")
+	sendResponse(r.Context(), w, res.StatusCode, stored)
+}
+
 func (app *App) updateProduct(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key, _ := strconv.Atoi(vars["id"])
 
+	version, ok := requireIfMatch(w, r, key)
+	if !ok {
+		return
+	}
+
 	var p product
 	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
 	decoder := json.NewDecoder(r.Body)
@@ -270,20 +608,32 @@ func (app *App) updateProduct(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	if p.Name == "" || p.Price < 0 || p.Quantity < 0 {
+	if err := p.validate(); err != nil {
 		logrus.WithContext(r.Context()).WithField("product_id", key).Warn("Tentativa de atualizar produto com dados inválidos")
-		sendError(w, r, http.StatusBadRequest, errors.New("invalid product data: name is required, price and quantity cannot be negative"))
+		sendError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
 	p.ID = key
+	p.Version = version
 	// Passa o contexto da requisição para a função do banco de dados
-	err := p.updateProduct(r.Context(), app.DB) // Passando r.Context()
+	err := app.withTx(r.Context(), func(tx *sql.Tx) error {
+		return p.updateProduct(r.Context(), tx)
+	})
 	if err != nil {
 		// Verifica o erro sql.ErrNoRows retornado pela função updateProduct
 		if errors.Is(err, sql.ErrNoRows) {
 			logrus.WithContext(r.Context()).WithField("product_id", key).Info("Produto não encontrado para atualização")
 			sendError(w, r, http.StatusNotFound, fmt.Errorf("product with ID %d not found for update", key))
+		} else if errors.Is(err, errVersionConflict) {
+			sendVersionConflict(w, r, key)
+		} else if errors.Is(err, errInsufficientStock) {
+			sendError(w, r, http.StatusConflict, locatedStockError(p))
+		} else if isMySQLError(err, mysqlErrNoReferencedRow) {
+			logrus.WithContext(r.Context()).WithField("product_id", key).Warn("Tentativa de atualizar produto com categoria inexistente")
+			sendError(w, r, http.StatusBadRequest, categoryNotFoundError(p))
+		} else if isMySQLError(err, mysqlErrDuplicateEntry) {
+			sendDuplicateSKU(w, r, p)
 		} else {
 			logrus.WithContext(r.Context()).WithError(err).WithField("product_id", key).Error("Erro ao atualizar produto")
 			sqlErrorsTotal.Inc()
@@ -291,84 +641,1752 @@ func (app *App) updateProduct(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	// O corpo do PUT não traz os campos calculados pelo servidor (reserved, available);
+	// relê o produto para responder com o estado gravado
+	if err := p.getProduct(r.Context(), app.DB); err != nil {
+		logrus.WithContext(r.Context()).WithError(err).WithField("product_id", key).Warn("Erro ao reler produto após atualização")
+	}
 	logrus.WithContext(r.Context()).WithField("product_id", key).Info("Produto atualizado")
+	w.Header().Set("ETag", productETag(p.Version))
 	sendResponse(r.Context(), w, http.StatusOK, p)
 }
 
-func (app *App) deleteProduct(w http.ResponseWriter, r *http.Request) {
+// patchProduct aplica um JSON Merge Patch (RFC 7396): só os campos enviados são alterados
+func (app *App) patchProduct(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key, _ := strconv.Atoi(vars["id"])
 
+	logger := logrus.WithContext(r.Context()).WithFields(logrus.Fields{
+		"component":  "http_handler",
+		"operation":  "patch_product",
+		"product_id": key,
+	})
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != mergePatchContentType {
+		logger.Warn("Content-Type inválido para PATCH")
+		sendError(w, r, http.StatusUnsupportedMediaType, fmt.Errorf("Content-Type must be %s", mergePatchContentType))
+		return
+	}
+
+	version, ok := requireIfMatch(w, r, key)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	defer r.Body.Close()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.WithError(err).Warn("Erro ao ler o corpo do PATCH")
+		sendError(w, r, http.StatusBadRequest, errors.New("invalid request payload"))
+		return
+	}
+
 	p := product{ID: key}
-	// Passa o contexto da requisição para a função do banco de dados
-	err := p.deleteProduct(r.Context(), app.DB) // Passando r.Context()
+	if err := p.getProduct(r.Context(), app.DB); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Info("Produto não encontrado para PATCH")
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("product with ID %d not found for update", key))
+		} else {
+			logger.WithError(err).Error("Erro ao buscar produto para PATCH")
+			sqlErrorsTotal.Inc()
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to update product"))
+		}
+		return
+	}
+
+	// If-Match: * aceita qualquer versão, mas a escrita continua condicionada à versão lida acima
+	if version != 0 && version != p.Version {
+		sendVersionConflict(w, r, key)
+		return
+	}
+
+	if err := applyMergePatch(&p, body); err != nil {
+		logger.WithError(err).Warn("Merge patch inválido")
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := p.validate(); err != nil {
+		logger.Warn("Tentativa de aplicar PATCH com dados inválidos")
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = app.withTx(r.Context(), func(tx *sql.Tx) error {
+		return p.updateProduct(r.Context(), tx)
+	})
 	if err != nil {
-		// Verifica o erro sql.ErrNoRows retornado pela função deleteProduct
 		if errors.Is(err, sql.ErrNoRows) {
-			logrus.WithContext(r.Context()).WithField("product_id", key).Info("Produto não encontrado para deleção")
-			sendError(w, r, http.StatusNotFound, fmt.Errorf("product with ID %d not found for deletion", key))
+			logger.Info("Produto não encontrado para PATCH")
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("product with ID %d not found for update", key))
+		} else if errors.Is(err, errVersionConflict) {
+			sendVersionConflict(w, r, key)
+		} else if errors.Is(err, errInsufficientStock) {
+			sendError(w, r, http.StatusConflict, locatedStockError(p))
+		} else if isMySQLError(err, mysqlErrNoReferencedRow) {
+			logger.Warn("Tentativa de aplicar PATCH com categoria inexistente")
+			sendError(w, r, http.StatusBadRequest, categoryNotFoundError(p))
+		} else if isMySQLError(err, mysqlErrDuplicateEntry) {
+			sendDuplicateSKU(w, r, p)
 		} else {
-			logrus.WithContext(r.Context()).WithError(err).WithField("product_id", key).Error("Erro ao deletar produto")
+			logger.WithError(err).Error("Erro ao aplicar PATCH no produto")
 			sqlErrorsTotal.Inc()
-			sendError(w, r, http.StatusInternalServerError, errors.New("failed to delete product"))
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to update product"))
 		}
 		return
 	}
-	logrus.WithContext(r.Context()).WithField("product_id", key).Info("Produto deletado")
-	sendResponse(r.Context(), w, http.StatusOK, map[string]string{"result": "success", "message": fmt.Sprintf("Product with ID %d deleted", key)})
+
+	p.Available = p.Quantity - p.Reserved
+	logger.Info("Produto atualizado parcialmente")
+	w.Header().Set("ETag", productETag(p.Version))
+	sendResponse(r.Context(), w, http.StatusOK, p)
 }
 
-// --- Health Check (sem alterações, já usava PingContext) ---
-func (app *App) healthCheck(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
-	if err := app.DB.PingContext(ctx); err != nil {
-		logrus.WithError(err).Warn("Health check falhou (DB ping)")
-		sendError(w, r, http.StatusServiceUnavailable, fmt.Errorf("database connection failed: %v", err))
+// bulkProducts executa um lote de create/update/delete em uma única transação.
+// O lote inteiro é um span (bulk_products) e cada item um evento desse span.
+func (app *App) bulkProducts(w http.ResponseWriter, r *http.Request) {
+	logger := logrus.WithContext(r.Context()).WithFields(logrus.Fields{
+		"component": "http_handler",
+		"operation": "bulk_products",
+	})
+
+	var req bulkRequest
+	r.Body = http.MaxBytesReader(w, r.Body, 10_485_760)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		logger.WithError(err).Warn("Payload de requisição inválido para o lote de produtos")
+		sendError(w, r, http.StatusBadRequest, errors.New("invalid request payload"))
 		return
 	}
-	sendResponse(r.Context(), w, http.StatusOK, map[string]string{"status": "ok", "database": "connected"})
-}
+	defer r.Body.Close()
 
-// --- Atualização da Métrica de Contagem de Produtos ---
+	if err := validateBulkRequest(&req); err != nil {
+		logger.WithError(err).Warn("Lote de produtos inválido")
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
 
-// Função interna para buscar a contagem atual (agora passa contexto)
-func (app *App) getCurrentProductCount() (int, error) {
-	// Cria um contexto com timeout para esta chamada interna
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	// Passa o contexto criado para a função countProducts
-	count, err := countProducts(ctx, app.DB) // Passando ctx
+	ctx, span := otel.Tracer("inventory-app").Start(r.Context(), "bulk_products", trace.WithAttributes(
+		attribute.String("bulk.mode", req.Mode),
+		attribute.Int("bulk.size", len(req.Operations)),
+	))
+	defer span.End()
+	logger = logger.WithContext(ctx).WithFields(logrus.Fields{"mode": req.Mode, "size": len(req.Operations)})
+
+	tx, err := app.DB.BeginTx(ctx, nil)
 	if err != nil {
-		logrus.WithError(err).Error("Erro ao contar produtos no banco de dados para métrica")
+		logger.WithError(err).Error("Erro ao iniciar transação do lote")
 		sqlErrorsTotal.Inc()
-		return 0, err
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "begin transaction failed")
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to process bulk request"))
+		return
 	}
-	return count, nil
-}
+	defer tx.Rollback() // Sem efeito se o Commit já tiver acontecido
 
-// Goroutine para atualizar periodicamente a métrica (sem alterações na lógica do ticker)
-func (app *App) startBackgroundProductCountUpdate() {
-	count, err := app.getCurrentProductCount()
-	if err == nil {
-		productsInDB.Set(float64(count))
-		logrus.Infof("Métrica inicial 'products_in_db' definida para: %d", count)
+	resp, err := runBulk(ctx, tx, req)
+	if err == nil && (resp.Failed == 0 || req.Mode == bulkModeBestEffort) {
+		err = app.commitTx(ctx, tx)
+		resp.Committed = err == nil
 	} else {
-		logrus.Warn("Não foi possível definir a métrica inicial 'products_in_db'")
+		app.Stream.publishCommitted(ctx, false)
+	}
+	span.SetAttributes(
+		attribute.Bool("bulk.committed", resp.Committed),
+		attribute.Int("bulk.succeeded", resp.Succeeded),
+		attribute.Int("bulk.failed", resp.Failed),
+	)
+	if err != nil {
+		logger.WithError(err).Error("Erro ao executar o lote de produtos")
+		sqlErrorsTotal.Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "bulk request failed")
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to process bulk request"))
+		return
 	}
 
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
+	if !resp.Committed {
+		// Modo atomic com item inválido: nada foi gravado
+		logger.WithField("failed", resp.Failed).Warn("Lote de produtos desfeito")
+		span.SetStatus(codes.Error, "bulk request rolled back")
+		sendResponse(ctx, w, http.StatusUnprocessableEntity, resp)
+		return
+	}
 
-	logrus.Info("Iniciando atualização periódica da métrica 'products_in_db' a cada 5 minutos")
+	logger.WithFields(logrus.Fields{"succeeded": resp.Succeeded, "failed": resp.Failed}).Info("Lote de produtos processado")
+	sendResponse(ctx, w, http.StatusOK, resp)
+}
 
-	for range ticker.C {
-		count, err := app.getCurrentProductCount()
-		if err == nil {
-			productsInDB.Set(float64(count))
-			logrus.Debugf("Métrica 'products_in_db' atualizada para: %d", count)
-		} else {
-			logrus.Warn("Falha ao atualizar periodicamente a métrica 'products_in_db'")
+// exportProducts devolve o catálogo em CSV, com os mesmos filtros do GET /products.
+// As linhas são escritas à medida que são lidas do banco.
+func (app *App) exportProducts(w http.ResponseWriter, r *http.Request) {
+	logger := logrus.WithContext(r.Context()).WithFields(logrus.Fields{
+		"component": "http_handler",
+		"operation": "export_products",
+	})
+
+	q, err := parseProductQuery(r.URL.Query())
+	if err != nil {
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="products.csv"`)
+	writer := csv.NewWriter(w)
+	if err := writer.Write(exportColumns); err != nil {
+		logger.WithError(err).Error("Erro ao escrever o cabeçalho do CSV de exportação")
+		trace.SpanFromContext(r.Context()).RecordError(err)
+		return
+	}
+
+	// writeErr separa as falhas de escrita na resposta (ex: cliente desconectou) das falhas do banco
+	var writeErr error
+	exported := 0
+	err = exportProducts(r.Context(), app.DB, q, func(p product) error {
+		exported++
+		if err := writer.Write(csvRecord(p)); err != nil {
+			writeErr = err
+			return err
+		}
+		if exported%importBatchSize == 0 {
+			writer.Flush()
+			writeErr = writer.Error()
+			return writeErr
+		}
+		return nil
+	})
+	writer.Flush()
+	if writeErr == nil {
+		writeErr = writer.Error()
+	}
+	// O status 200 já foi enviado: o cliente recebe um CSV truncado, e o erro fica no log/trace
+	if writeErr != nil {
+		logger.WithError(writeErr).WithField("exported", exported).Warn("Erro ao escrever o CSV de exportação na resposta")
+		trace.SpanFromContext(r.Context()).RecordError(writeErr)
+		return
+	}
+	if err != nil {
+		logger.WithError(err).WithField("exported", exported).Error("Erro ao exportar produtos")
+		sqlErrorsTotal.Inc()
+		trace.SpanFromContext(r.Context()).RecordError(err)
+		return
+	}
+	logger.WithField("exported", exported).Info("Catálogo exportado em CSV")
+}
+
+// importProducts faz o upsert de produtos a partir de um CSV, em uma única transação.
+// ?dry_run=true valida e aplica tudo, mas desfaz a transação no final.
+// ?map=Coluna:campo,... mapeia colunas da planilha para os campos do produto.
+func (app *App) importProducts(w http.ResponseWriter, r *http.Request) {
+	logger := logrus.WithContext(r.Context()).WithFields(logrus.Fields{
+		"component": "http_handler",
+		"operation": "import_products",
+	})
+
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			sendError(w, r, http.StatusBadRequest, errors.New("dry_run must be true or false"))
+			return
 		}
 	}
+	mapping, err := parseColumnMapping(r.URL.Query().Get("map"))
+	if err != nil {
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 10_485_760)
+	defer r.Body.Close()
+	reader, err := newImportReader(r, r.Body)
+	if err != nil {
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	var report importReport
+	err = app.withTx(r.Context(), func(tx *sql.Tx) error {
+		var err error
+		report, err = runImport(r.Context(), tx, reader, mapping)
+		if err == nil && (dryRun || report.Failed > 0) {
+			return errImportNotCommitted
+		}
+		return err
+	})
+	report.DryRun = dryRun
+	report.Committed = err == nil
+	logger = logger.WithFields(logrus.Fields{
+		"dry_run": dryRun,
+		"rows":    report.Rows,
+		"created": report.Created,
+		"updated": report.Updated,
+		"failed":  report.Failed,
+	})
+
+	var inputErr *importInputError
+	switch {
+	case errors.As(err, &inputErr):
+		logger.WithError(err).Warn("CSV de import inválido")
+		sendError(w, r, http.StatusBadRequest, err)
+	case err != nil && !errors.Is(err, errImportNotCommitted):
+		logger.WithError(err).Error("Erro ao importar produtos")
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to import products"))
+	case report.Failed > 0:
+		// Alguma linha foi recusada: nada foi gravado, o relatório mostra o que corrigir
+		logger.Warn("Import de produtos desfeito por linhas inválidas")
+		sendResponse(r.Context(), w, http.StatusUnprocessableEntity, report)
+	default:
+		logger.Info("Import de produtos processado")
+		sendResponse(r.Context(), w, http.StatusOK, report)
+	}
+}
+
+func (app *App) deleteProduct(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, _ := strconv.Atoi(vars["id"])
+
+	version, ok := requireIfMatch(w, r, key)
+	if !ok {
+		return
+	}
+
+	p := product{ID: key, Version: version}
+	// Passa o contexto da requisição para a função do banco de dados
+	err := app.withTx(r.Context(), func(tx *sql.Tx) error {
+		return p.deleteProduct(r.Context(), tx)
+	})
+	if err != nil {
+		// Verifica o erro sql.ErrNoRows retornado pela função deleteProduct
+		if errors.Is(err, sql.ErrNoRows) {
+			logrus.WithContext(r.Context()).WithField("product_id", key).Info("Produto não encontrado para deleção")
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("product with ID %d not found for deletion", key))
+		} else if errors.Is(err, errVersionConflict) {
+			sendVersionConflict(w, r, key)
+		} else {
+			logrus.WithContext(r.Context()).WithError(err).WithField("product_id", key).Error("Erro ao deletar produto")
+			sqlErrorsTotal.Inc()
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to delete product"))
+		}
+		return
+	}
+	logrus.WithContext(r.Context()).WithField("product_id", key).Info("Produto deletado")
+	sendResponse(r.Context(), w, http.StatusOK, resultResponse{Result: "success", Message: fmt.Sprintf("Product with ID %d moved to trash", key)})
+}
+
+// getTrash lista os produtos na lixeira, com os mesmos filtros e paginação do GET /products
+func (app *App) getTrash(w http.ResponseWriter, r *http.Request) {
+	logger := logrus.WithContext(r.Context()).WithFields(logrus.Fields{
+		"component": "http_handler",
+		"operation": "get_trash",
+	})
+
+	q, err := parseProductQuery(r.URL.Query())
+	if err != nil {
+		logger.WithError(err).Warn("Parâmetros de consulta inválidos para listar a lixeira")
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	q.Deleted = true
+
+	products, hasMore, err := getProductsFromDB(r.Context(), app.DB, q)
+	total := 0
+	if err == nil {
+		total, err = countFilteredProducts(r.Context(), app.DB, q)
+	}
+	if err != nil {
+		logger.WithError(err).Error("Erro ao obter produtos da lixeira")
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve deleted products"))
+		return
+	}
+
+	page := productPage{Products: products, Total: total, Limit: q.Limit, Offset: q.Offset}
+	if hasMore && len(products) > 0 {
+		page.NextCursor = q.nextCursor(products[len(products)-1])
+	}
+	logger.WithField("num_products", len(products)).Info("Listando produtos da lixeira")
+	sendResponse(r.Context(), w, http.StatusOK, page)
+}
+
+// getLowStock lista os produtos com quantity abaixo do reorder_threshold (limit/offset)
+func (app *App) getLowStock(w http.ResponseWriter, r *http.Request) {
+	logger := logWithTrace(r.Context()).WithFields(logrus.Fields{
+		"component": "http_handler",
+		"operation": "get_low_stock",
+	})
+
+	limit, offset, err := parseLimitOffset(r.URL.Query())
+	if err != nil {
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	products, err := getLowStockProducts(r.Context(), app.DB, limit, offset)
+	if err != nil {
+		logger.WithError(err).Error("Erro ao obter produtos com estoque baixo")
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve low-stock products"))
+		return
+	}
+
+	logger.WithField("num_products", len(products)).Info("Listando produtos com estoque baixo")
+	sendResponse(r.Context(), w, http.StatusOK, lowStockPage{Products: products, pageLimits: pageLimits{Limit: limit, Offset: offset}})
+}
+
+// restoreProduct tira um produto da lixeira
+func (app *App) restoreProduct(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, _ := strconv.Atoi(vars["id"])
+
+	p := product{ID: key}
+	err := app.withTx(r.Context(), func(tx *sql.Tx) error {
+		return p.restoreProduct(r.Context(), tx)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logrus.WithContext(r.Context()).WithField("product_id", key).Info("Produto não encontrado na lixeira")
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("product with ID %d not found in trash", key))
+		} else {
+			logrus.WithContext(r.Context()).WithError(err).WithField("product_id", key).Error("Erro ao restaurar produto")
+			sqlErrorsTotal.Inc()
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to restore product"))
+		}
+		return
+	}
+	logrus.WithContext(r.Context()).WithField("product_id", key).Info("Produto restaurado")
+	w.Header().Set("ETag", productETag(p.Version))
+	sendResponse(r.Context(), w, http.StatusOK, p)
+}
+
+// --- Handlers de estoque ---
+
+// createStockMovement aplica uma movimentação de estoque em uma transação e a registra no livro-razão
+func (app *App) createStockMovement(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, _ := strconv.Atoi(vars["id"])
+	logger := logrus.WithContext(r.Context()).WithFields(logrus.Fields{
+		"component":  "http_handler",
+		"operation":  "stock_movement",
+		"product_id": key,
+	})
+
+	var m stockMovement
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&m); err != nil {
+		logger.WithError(err).Warn("Payload de requisição inválido para movimentação de estoque")
+		sendError(w, r, http.StatusBadRequest, errors.New("invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+
+	m.ProductID = key
+	if err := m.validate(); err != nil {
+		logger.WithError(err).Warn("Movimentação de estoque inválida")
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	tx, err := app.DB.BeginTx(r.Context(), nil)
+	if err == nil {
+		defer tx.Rollback() // Sem efeito se o Commit já tiver acontecido
+		err = m.apply(r.Context(), tx)
+		if err == nil {
+			err = recordStockEvent(r.Context(), tx, m.ProductID)
+		}
+		if err == nil {
+			err = app.commitTx(r.Context(), tx)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Info("Produto não encontrado para movimentação de estoque")
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("product with ID %d not found", key))
+		} else if errors.Is(err, errInsufficientStock) {
+			sendError(w, r, http.StatusConflict, fmt.Errorf("insufficient stock for %s of %d units on product %d", m.Type, m.Quantity, key))
+		} else if errors.Is(err, errHeldByReservations) {
+			sendError(w, r, http.StatusConflict, fmt.Errorf("cannot release %d units on product %d: units held by active reservations are released only by confirming, cancelling or expiring the reservation", m.Quantity, key))
+		} else if errors.Is(err, errLocationNotFound) {
+			sendError(w, r, http.StatusBadRequest, fmt.Errorf("location %q not found", m.Location))
+		} else {
+			logger.WithError(err).Error("Erro ao aplicar movimentação de estoque")
+			sqlErrorsTotal.Inc()
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to apply stock movement"))
+		}
+		return
+	}
+
+	stockMovementsTotal.With(prometheus.Labels{"type": m.Type}).Inc()
+	logger.WithFields(logrus.Fields{
+		"type":           m.Type,
+		"quantity":       m.Quantity,
+		"reason":         m.Reason,
+		"quantity_after": m.QuantityAfter,
+		"reserved_after": m.ReservedAfter,
+	}).Info("Movimentação de estoque aplicada")
+	sendResponse(r.Context(), w, http.StatusCreated, m)
+}
+
+// getStockMovements lista o histórico de movimentações do produto (limit/offset)
+func (app *App) getStockMovements(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, _ := strconv.Atoi(vars["id"])
+	logger := logrus.WithContext(r.Context()).WithFields(logrus.Fields{
+		"component":  "http_handler",
+		"operation":  "get_stock_movements",
+		"product_id": key,
+	})
+
+	limit, offset, err := parseLimitOffset(r.URL.Query())
+	if err != nil {
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	exists, err := productExists(r.Context(), app.DB, key)
+	var movements []stockMovement
+	if err == nil && exists {
+		movements, err = getStockMovements(r.Context(), app.DB, key, limit, offset)
+	}
+	if err != nil {
+		logger.WithError(err).Error("Erro ao obter movimentações de estoque")
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve stock movements"))
+		return
+	}
+	if !exists {
+		logger.Info("Produto não encontrado para listar movimentações")
+		sendError(w, r, http.StatusNotFound, fmt.Errorf("product with ID %d not found", key))
+		return
+	}
+
+	logger.WithField("num_movements", len(movements)).Info("Listando movimentações de estoque")
+	sendResponse(r.Context(), w, http.StatusOK, movementPage{Movements: movements, pageLimits: pageLimits{Limit: limit, Offset: offset}})
+}
+
+// --- Handlers de reservas ---
+
+// createReservation segura estoque do produto por ttl_seconds (padrão 15 minutos)
+func (app *App) createReservation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, _ := strconv.Atoi(vars["id"])
+	logger := logrus.WithContext(r.Context()).WithFields(logrus.Fields{
+		"component":  "http_handler",
+		"operation":  "create_reservation",
+		"product_id": key,
+	})
+
+	var res reservation
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&res); err != nil {
+		logger.WithError(err).Warn("Payload de requisição inválido para criar reserva")
+		sendError(w, r, http.StatusBadRequest, errors.New("invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+
+	res.ProductID = key
+	if err := res.validate(); err != nil {
+		logger.WithError(err).Warn("Reserva inválida")
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	tx, err := app.DB.BeginTx(r.Context(), nil)
+	if err == nil {
+		defer tx.Rollback() // Sem efeito se o Commit já tiver acontecido
+		err = res.createReservation(r.Context(), tx)
+		if err == nil {
+			err = app.commitTx(r.Context(), tx)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Info("Produto não encontrado para reserva")
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("product with ID %d not found", key))
+		} else if errors.Is(err, errInsufficientStock) {
+			sendError(w, r, http.StatusConflict, fmt.Errorf("insufficient available stock to reserve %d units of product %d", res.Quantity, key))
+		} else {
+			logger.WithError(err).Error("Erro ao criar reserva")
+			sqlErrorsTotal.Inc()
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to create reservation"))
+		}
+		return
+	}
+
+	logger.WithFields(logrus.Fields{"reservation_id": res.ID, "quantity": res.Quantity, "expires_at": res.ExpiresAt}).Info("Reserva criada")
+	res.TTLSeconds = 0
+	sendResponse(r.Context(), w, http.StatusCreated, res)
+}
+
+func (app *App) getReservation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, _ := strconv.Atoi(vars["id"])
+
+	res := reservation{ID: key}
+	if err := res.getReservation(r.Context(), app.DB, false); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logrus.WithContext(r.Context()).WithField("reservation_id", key).Info("Reserva não encontrada")
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("reservation with ID %d not found", key))
+		} else {
+			logrus.WithContext(r.Context()).WithError(err).WithField("reservation_id", key).Error("Erro ao buscar reserva")
+			sqlErrorsTotal.Inc()
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve reservation"))
+		}
+		return
+	}
+	sendResponse(r.Context(), w, http.StatusOK, res)
+}
+
+func (app *App) confirmReservation(w http.ResponseWriter, r *http.Request) {
+	app.finishReservation(w, r, reservationConfirmed)
+}
+
+func (app *App) cancelReservation(w http.ResponseWriter, r *http.Request) {
+	app.finishReservation(w, r, reservationCancelled)
+}
+
+// finishReservation confirma ou cancela uma reserva active
+func (app *App) finishReservation(w http.ResponseWriter, r *http.Request, status string) {
+	vars := mux.Vars(r)
+	key, _ := strconv.Atoi(vars["id"])
+	logger := logrus.WithContext(r.Context()).WithFields(logrus.Fields{
+		"component":      "http_handler",
+		"operation":      "finish_reservation",
+		"reservation_id": key,
+		"status":         status,
+	})
+
+	res := reservation{ID: key}
+	tx, err := app.DB.BeginTx(r.Context(), nil)
+	if err == nil {
+		defer tx.Rollback() // Sem efeito se o Commit já tiver acontecido
+		err = res.finishReservation(r.Context(), tx, status)
+		// Uma reserva vencida é expirada mesmo quando a confirmação/cancelamento é recusado
+		if err == nil || errors.Is(err, errReservationExpired) {
+			if commitErr := app.commitTx(r.Context(), tx); commitErr != nil {
+				err = commitErr
+			}
+		}
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Info("Reserva ou produto não encontrado")
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("reservation with ID %d or its product not found", key))
+		} else if errors.Is(err, errReservationExpired) {
+			logger.WithField("expires_at", res.ExpiresAt).Warn("Reserva expirada")
+			sendError(w, r, http.StatusConflict, withProblemType(problemReservationExpired, "Reservation expired",
+				fmt.Errorf("reservation with ID %d expired at %s and its units were returned to available stock", key, res.ExpiresAt.Format(time.RFC3339))))
+		} else if errors.Is(err, errReservationNotActive) {
+			logger.WithField("current_status", res.Status).Warn("Reserva não está ativa")
+			sendError(w, r, http.StatusConflict, fmt.Errorf("reservation with ID %d is %s", key, res.Status))
+		} else if errors.Is(err, errInsufficientStock) {
+			sendError(w, r, http.StatusConflict, fmt.Errorf("insufficient stock to confirm reservation %d", key))
+		} else {
+			logger.WithError(err).Error("Erro ao finalizar reserva")
+			sqlErrorsTotal.Inc()
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to update reservation"))
+		}
+		return
+	}
+
+	logger.Info("Reserva finalizada")
+	sendResponse(r.Context(), w, http.StatusOK, res)
+}
+
+// sendDuplicateSKU responde 409 quando o SKU já pertence a outro produto (erro 1062 do MySQL)
+func sendDuplicateSKU(w http.ResponseWriter, r *http.Request, p product) {
+	sqlDuplicateKeyConflictsTotal.With(prometheus.Labels{"table": "products"}).Inc()
+	logrus.WithContext(r.Context()).WithFields(logrus.Fields{
+		"component":  "http_handler",
+		"product_id": p.ID,
+		"sku":        p.SKU,
+	}).Warn("SKU duplicado")
+	sendError(w, r, http.StatusConflict, withProblemType(problemDuplicateSKU, "Duplicate SKU",
+		fmt.Errorf("a product with SKU %q already exists (including products in trash)", p.SKU)))
+}
+
+// locatedStockError explica o 409 de um PUT/PATCH que reduz quantity abaixo do estoque
+// reservado ou do guardado fora do depósito padrão (é preciso liberar as reservas, ou
+// transferir ou baixar nesses depósitos, antes)
+func locatedStockError(p product) error {
+	if p.Quantity < p.Reserved {
+		return fmt.Errorf("quantity %d is lower than the %d units reserved for product %d", p.Quantity, p.Reserved, p.ID)
+	}
+	return fmt.Errorf("quantity %d is lower than the stock held outside location %s for product %d", p.Quantity, defaultLocationCode, p.ID)
+}
+
+// categoryNotFoundError é a mensagem para a violação da FOREIGN KEY products.category_id
+func categoryNotFoundError(p product) error {
+	if p.CategoryID == nil {
+		return errors.New("category not found")
+	}
+	return fmt.Errorf("category with ID %d not found", *p.CategoryID)
+}
+
+// --- Handlers de categorias ---
+
+func (app *App) getCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := getCategoriesFromDB(r.Context(), app.DB)
+	if err != nil {
+		logrus.WithContext(r.Context()).WithError(err).Error("Erro ao obter categorias do banco de dados")
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve categories"))
+		return
+	}
+	logrus.WithContext(r.Context()).WithField("num_categories", len(categories)).Info("Listando categorias")
+	sendResponse(r.Context(), w, http.StatusOK, categories)
+}
+
+func (app *App) getCategory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, _ := strconv.Atoi(vars["id"])
+
+	c := category{ID: key}
+	if err := c.getCategory(r.Context(), app.DB); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logrus.WithContext(r.Context()).WithField("category_id", key).Info("Categoria não encontrada")
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("category with ID %d not found", key))
+		} else {
+			logrus.WithContext(r.Context()).WithError(err).WithField("category_id", key).Error("Erro ao buscar categoria no banco de dados")
+			sqlErrorsTotal.Inc()
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve category"))
+		}
+		return
+	}
+	logrus.WithContext(r.Context()).WithField("category_id", key).Info("Exibindo categoria")
+	sendResponse(r.Context(), w, http.StatusOK, c)
+}
+
+func (app *App) createCategory(w http.ResponseWriter, r *http.Request) {
+	var c category
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&c); err != nil {
+		logrus.WithContext(r.Context()).WithError(err).Warn("Payload de requisição inválido para criar categoria")
+		sendError(w, r, http.StatusBadRequest, errors.New("invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+
+	if err := c.validate(); err != nil {
+		logrus.WithContext(r.Context()).Warn("Tentativa de criar categoria com dados inválidos")
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err := c.createCategory(r.Context(), app.DB)
+	if isMySQLError(err, mysqlErrDuplicateEntry) {
+		sqlDuplicateKeyConflictsTotal.With(prometheus.Labels{"table": "categories"}).Inc()
+		logrus.WithContext(r.Context()).WithField("category_name", c.Name).Warn("Categoria duplicada")
+		sendError(w, r, http.StatusConflict, fmt.Errorf("category %q already exists", c.Name))
+		return
+	}
+	if err != nil {
+		logrus.WithContext(r.Context()).WithError(err).Error("Erro ao criar categoria no banco de dados")
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to create category"))
+		return
+	}
+
+	logrus.WithContext(r.Context()).WithField("category_id", c.ID).Info("Categoria criada")
+	sendResponse(r.Context(), w, http.StatusCreated, c)
+}
+
+func (app *App) updateCategory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, _ := strconv.Atoi(vars["id"])
+
+	var c category
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&c); err != nil {
+		logrus.WithContext(r.Context()).WithError(err).Warn("Payload de requisição inválido para atualizar categoria")
+		sendError(w, r, http.StatusBadRequest, errors.New("invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+
+	if err := c.validate(); err != nil {
+		logrus.WithContext(r.Context()).WithField("category_id", key).Warn("Tentativa de atualizar categoria com dados inválidos")
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	c.ID = key
+	err := c.updateCategory(r.Context(), app.DB)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logrus.WithContext(r.Context()).WithField("category_id", key).Info("Categoria não encontrada para atualização")
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("category with ID %d not found for update", key))
+		} else if isMySQLError(err, mysqlErrDuplicateEntry) {
+			sqlDuplicateKeyConflictsTotal.With(prometheus.Labels{"table": "categories"}).Inc()
+			logrus.WithContext(r.Context()).WithField("category_id", key).Warn("Categoria duplicada")
+			sendError(w, r, http.StatusConflict, fmt.Errorf("category %q already exists", c.Name))
+		} else {
+			logrus.WithContext(r.Context()).WithError(err).WithField("category_id", key).Error("Erro ao atualizar categoria")
+			sqlErrorsTotal.Inc()
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to update category"))
+		}
+		return
+	}
+	logrus.WithContext(r.Context()).WithField("category_id", key).Info("Categoria atualizada")
+	sendResponse(r.Context(), w, http.StatusOK, c)
+}
+
+func (app *App) deleteCategory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, _ := strconv.Atoi(vars["id"])
+
+	c := category{ID: key}
+	err := c.deleteCategory(r.Context(), app.DB)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logrus.WithContext(r.Context()).WithField("category_id", key).Info("Categoria não encontrada para deleção")
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("category with ID %d not found for deletion", key))
+		} else if isMySQLError(err, mysqlErrRowIsReferenced) {
+			logrus.WithContext(r.Context()).WithField("category_id", key).Warn("Tentativa de excluir categoria com produtos")
+			sendError(w, r, http.StatusConflict, fmt.Errorf("category with ID %d still has products (including products in trash)", key))
+		} else {
+			logrus.WithContext(r.Context()).WithError(err).WithField("category_id", key).Error("Erro ao deletar categoria")
+			sqlErrorsTotal.Inc()
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to delete category"))
+		}
+		return
+	}
+	logrus.WithContext(r.Context()).WithField("category_id", key).Info("Categoria deletada")
+	sendResponse(r.Context(), w, http.StatusOK, resultResponse{Result: "success", Message: fmt.Sprintf("Category with ID %d deleted", key)})
+}
+
+// --- Handlers de depósitos ---
+
+func (app *App) getLocations(w http.ResponseWriter, r *http.Request) {
+	locations, err := getLocationsFromDB(r.Context(), app.DB)
+	if err != nil {
+		logrus.WithContext(r.Context()).WithError(err).Error("Erro ao obter depósitos do banco de dados")
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve locations"))
+		return
+	}
+	logrus.WithContext(r.Context()).WithField("num_locations", len(locations)).Info("Listando depósitos")
+	sendResponse(r.Context(), w, http.StatusOK, locations)
+}
+
+func (app *App) createLocation(w http.ResponseWriter, r *http.Request) {
+	var l location
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&l); err != nil {
+		logrus.WithContext(r.Context()).WithError(err).Warn("Payload de requisição inválido para criar depósito")
+		sendError(w, r, http.StatusBadRequest, errors.New("invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+
+	if err := l.validate(); err != nil {
+		logrus.WithContext(r.Context()).Warn("Tentativa de criar depósito com dados inválidos")
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err := l.createLocation(r.Context(), app.DB)
+	if isMySQLError(err, mysqlErrDuplicateEntry) {
+		sqlDuplicateKeyConflictsTotal.With(prometheus.Labels{"table": "locations"}).Inc()
+		sendError(w, r, http.StatusConflict, fmt.Errorf("location %q already exists", l.Code))
+		return
+	}
+	if err != nil {
+		logrus.WithContext(r.Context()).WithError(err).Error("Erro ao criar depósito no banco de dados")
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to create location"))
+		return
+	}
+	logrus.WithContext(r.Context()).WithFields(logrus.Fields{"location_id": l.ID, "location": l.Code}).Info("Depósito criado")
+	sendResponse(r.Context(), w, http.StatusCreated, l)
+}
+
+// getProductStock mostra o saldo do produto por depósito; ?location=CODE filtra um depósito
+func (app *App) getProductStock(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, _ := strconv.Atoi(vars["id"])
+	code := r.URL.Query().Get("location")
+	logger := logrus.WithContext(r.Context()).WithFields(logrus.Fields{
+		"component":  "http_handler",
+		"operation":  "get_product_stock",
+		"product_id": key,
+		"location":   code,
+	})
+
+	p := product{ID: key}
+	err := p.getProduct(r.Context(), app.DB)
+	if err == nil && code != "" {
+		_, err = locationIDByCode(r.Context(), app.DB, code)
+	}
+	var stock []locationStock
+	if err == nil {
+		stock, err = getProductStock(r.Context(), app.DB, key, code)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Info("Produto não encontrado para consultar estoque")
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("product with ID %d not found", key))
+		} else if errors.Is(err, errLocationNotFound) {
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("location %q not found", code))
+		} else {
+			logger.WithError(err).Error("Erro ao obter estoque por depósito")
+			sqlErrorsTotal.Inc()
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve product stock"))
+		}
+		return
+	}
+
+	// Depósito sem linha em product_stock tem saldo zero
+	if code != "" && len(stock) == 0 {
+		stock = append(stock, locationStock{Location: code})
+	}
+	sendResponse(r.Context(), w, http.StatusOK, productStock{ProductID: key, Quantity: p.Quantity, Locations: stock})
+}
+
+// createTransfer move estoque do produto entre dois depósitos em uma única transação
+func (app *App) createTransfer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, _ := strconv.Atoi(vars["id"])
+	logger := logrus.WithContext(r.Context()).WithFields(logrus.Fields{
+		"component":  "http_handler",
+		"operation":  "create_transfer",
+		"product_id": key,
+	})
+
+	var t locationTransfer
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&t); err != nil {
+		logger.WithError(err).Warn("Payload de requisição inválido para transferência")
+		sendError(w, r, http.StatusBadRequest, errors.New("invalid request payload"))
+		return
+	}
+	defer r.Body.Close()
+
+	t.ProductID = key
+	if err := t.validate(); err != nil {
+		logger.WithError(err).Warn("Transferência inválida")
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err := app.withTx(r.Context(), func(tx *sql.Tx) error {
+		return t.apply(r.Context(), tx)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Info("Produto não encontrado para transferência")
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("product with ID %d not found", key))
+		} else if errors.Is(err, errLocationNotFound) {
+			sendError(w, r, http.StatusBadRequest, fmt.Errorf("location %q or %q not found", t.From, t.To))
+		} else if errors.Is(err, errInsufficientStock) {
+			sendError(w, r, http.StatusConflict, fmt.Errorf("insufficient stock in location %s to transfer %d units of product %d", t.From, t.Quantity, key))
+		} else {
+			logger.WithError(err).Error("Erro ao aplicar transferência")
+			sqlErrorsTotal.Inc()
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to apply transfer"))
+		}
+		return
+	}
+
+	stockMovementsTotal.With(prometheus.Labels{"type": stockTransfer}).Inc()
+	logger.WithFields(logrus.Fields{"from": t.From, "to": t.To, "quantity": t.Quantity}).Info("Transferência entre depósitos aplicada")
+	sendResponse(r.Context(), w, http.StatusCreated, t)
+}
+
+// --- Handler de auditoria ---
+
+// getAuditEvents lista quem alterou quais produtos: ?product_id=&actor=&since=<RFC3339>&limit=&offset=
+func (app *App) getAuditEvents(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	var q auditQuery
+	var err error
+	q.Limit, q.Offset, err = parseLimitOffset(values)
+	if err != nil {
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if v := values.Get("product_id"); v != "" {
+		if q.ProductID, err = strconv.Atoi(v); err != nil || q.ProductID <= 0 {
+			sendError(w, r, http.StatusBadRequest, errors.New("product_id must be a positive integer"))
+			return
+		}
+	}
+	q.Actor = strings.TrimSpace(values.Get("actor"))
+	if v := values.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			sendError(w, r, http.StatusBadRequest, errors.New("since must be an RFC3339 timestamp"))
+			return
+		}
+		q.Since = &since
+	}
+
+	events, err := getAuditEvents(r.Context(), app.DB, q)
+	if err != nil {
+		logrus.WithContext(r.Context()).WithError(err).Error("Erro ao obter eventos de auditoria")
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve audit events"))
+		return
+	}
+	logrus.WithContext(r.Context()).WithField("num_events", len(events)).Info("Listando eventos de auditoria")
+	sendResponse(r.Context(), w, http.StatusOK, auditPage{Events: events, pageLimits: pageLimits{Limit: q.Limit, Offset: q.Offset}})
+}
+
+// streamProducts envia os eventos de produto (product.created, product.updated, product.deleted,
+// product.restored) como Server-Sent Events. Last-Event-ID retoma a partir do backlog em memória.
+func (app *App) streamProducts(w http.ResponseWriter, r *http.Request) {
+	logger := logrus.WithContext(r.Context()).WithFields(logrus.Fields{
+		"component": "http_handler",
+		"operation": "stream_products",
+	})
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendError(w, r, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	// A conexão é longa: o deadline de escrita do servidor não se aplica
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		// EventSource não permite headers na primeira conexão; o query param cobre esse caso
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	events, replay, reset, current := app.Stream.subscribe(lastEventID)
+	defer app.Stream.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Desliga o buffer de proxies como o nginx
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if reset {
+		writeStreamEvent(w, app.Stream.eventID(current), streamResetEvent, []byte(`{"reason":"last_event_id_not_available"}`))
+	}
+	for _, ev := range replay {
+		writeStreamEvent(w, app.Stream.eventID(ev.ID), ev.Event, ev.Data)
+	}
+	flusher.Flush()
+	logger.WithFields(logrus.Fields{"replayed": len(replay), "reset": reset}).Info("Cliente conectado ao stream de produtos")
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			logger.Info("Cliente desconectado do stream de produtos")
+			return
+		case ev, ok := <-events:
+			if !ok {
+				// Cliente lento: o stream fechou a inscrição; ele reconecta com o Last-Event-ID
+				logger.Warn("Cliente do stream de produtos desconectado por não acompanhar os eventos")
+				return
+			}
+			if err := writeStreamEvent(w, app.Stream.eventID(ev.ID), ev.Event, ev.Data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (app *App) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := getWebhookSubscriptions(r.Context(), app.DB)
+	if err != nil {
+		logrus.WithContext(r.Context()).WithError(err).Error("Erro ao obter webhooks")
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve webhooks"))
+		return
+	}
+	sendResponse(r.Context(), w, http.StatusOK, subscriptions)
+}
+
+func (app *App) getWebhook(w http.ResponseWriter, r *http.Request) {
+	key, _ := strconv.Atoi(mux.Vars(r)["id"])
+	s := webhookSubscription{ID: key}
+	err := s.getWebhookSubscription(r.Context(), app.DB)
+	if errors.Is(err, errWebhookNotFound) {
+		sendError(w, r, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		logrus.WithContext(r.Context()).WithError(err).Error("Erro ao obter webhook")
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve webhook"))
+		return
+	}
+	sendResponse(r.Context(), w, http.StatusOK, s)
+}
+
+// decodeWebhook lê o corpo do POST /webhook e do PUT /webhook/{id}. active é true se omitido.
+func decodeWebhook(w http.ResponseWriter, r *http.Request) (webhookSubscription, bool) {
+	s := webhookSubscription{Active: true}
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+	defer r.Body.Close()
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&s); err != nil {
+		logrus.WithContext(r.Context()).WithError(err).Warn("Payload de requisição inválido para webhook")
+		sendError(w, r, http.StatusBadRequest, errors.New("invalid request payload"))
+		return s, false
+	}
+	if err := s.validate(r.Context()); err != nil {
+		logrus.WithContext(r.Context()).Warn("Tentativa de gravar webhook com dados inválidos")
+		sendError(w, r, http.StatusBadRequest, err)
+		return s, false
+	}
+	return s, true
+}
+
+// createWebhook registra uma inscrição. O secret (informado ou gerado) só aparece nesta resposta.
+func (app *App) createWebhook(w http.ResponseWriter, r *http.Request) {
+	s, ok := decodeWebhook(w, r)
+	if !ok {
+		return
+	}
+	if err := s.createWebhookSubscription(r.Context(), app.DB); err != nil {
+		logrus.WithContext(r.Context()).WithError(err).Error("Erro ao criar webhook no banco de dados")
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to create webhook"))
+		return
+	}
+	logrus.WithContext(r.Context()).WithFields(logrus.Fields{"webhook_id": s.ID, "events": s.Events}).Info("Webhook criado")
+	sendResponse(r.Context(), w, http.StatusCreated, s)
+}
+
+// updateWebhook substitui url, events e active; um secret no corpo faz a rotação do secret
+func (app *App) updateWebhook(w http.ResponseWriter, r *http.Request) {
+	key, _ := strconv.Atoi(mux.Vars(r)["id"])
+	s, ok := decodeWebhook(w, r)
+	if !ok {
+		return
+	}
+	s.ID = key
+	err := s.updateWebhookSubscription(r.Context(), app.DB)
+	if errors.Is(err, errWebhookNotFound) {
+		sendError(w, r, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		logrus.WithContext(r.Context()).WithError(err).Error("Erro ao atualizar webhook no banco de dados")
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to update webhook"))
+		return
+	}
+	s.Secret = ""
+	logrus.WithContext(r.Context()).WithField("webhook_id", s.ID).Info("Webhook atualizado")
+	sendResponse(r.Context(), w, http.StatusOK, s)
+}
+
+func (app *App) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	key, _ := strconv.Atoi(mux.Vars(r)["id"])
+	s := webhookSubscription{ID: key}
+	err := s.deleteWebhookSubscription(r.Context(), app.DB)
+	if errors.Is(err, errWebhookNotFound) {
+		sendError(w, r, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		logrus.WithContext(r.Context()).WithError(err).Error("Erro ao excluir webhook no banco de dados")
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to delete webhook"))
+		return
+	}
+	logrus.WithContext(r.Context()).WithField("webhook_id", key).Info("Webhook excluído")
+	sendResponse(r.Context(), w, http.StatusOK, resultResponse{Result: "success"})
+}
+
+// getDeadLetters lista as entregas que esgotaram as tentativas
+func (app *App) getDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parseLimitOffset(r.URL.Query())
+	if err != nil {
+		sendError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	deliveries, err := getDeadWebhookDeliveries(r.Context(), app.DB, limit, offset)
+	if err != nil {
+		logrus.WithContext(r.Context()).WithError(err).Error("Erro ao obter dead-letters de webhook")
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve dead letters"))
+		return
+	}
+	sendResponse(r.Context(), w, http.StatusOK, deliveryPage{Deliveries: deliveries, pageLimits: pageLimits{Limit: limit, Offset: offset}})
+}
+
+// retryDeadLetter devolve uma entrega da dead-letter list para a fila
+func (app *App) retryDeadLetter(w http.ResponseWriter, r *http.Request) {
+	key, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	err := retryDeadWebhookDelivery(r.Context(), app.DB, key)
+	if errors.Is(err, errDeliveryNotDead) {
+		sendError(w, r, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		sqlErrorsTotal.Inc()
+		sendError(w, r, http.StatusInternalServerError, errors.New("failed to retry delivery"))
+		return
+	}
+	logrus.WithContext(r.Context()).WithField("delivery_id", key).Info("Entrega de webhook reenfileirada")
+	sendResponse(r.Context(), w, http.StatusAccepted, resultResponse{Result: "queued"})
+}
+
+// --- Handlers de imagens de produto ---
+
+// uploadProductImage recebe a imagem em multipart/form-data (campo image), gera a miniatura e grava os
+// dois arquivos no armazenamento (app.Images) antes da linha no banco. O upload tem span e histograma próprios.
+func (app *App) uploadProductImage(w http.ResponseWriter, r *http.Request) {
+	key, _ := strconv.Atoi(mux.Vars(r)["id"])
+	logger := logrus.WithContext(r.Context()).WithFields(logrus.Fields{
+		"component":  "http_handler",
+		"operation":  "upload_product_image",
+		"product_id": key,
+		"backend":    app.Images.Backend(),
+	})
+
+	ctx, span := otel.Tracer("inventory-app").Start(r.Context(), "product_image.upload", trace.WithAttributes(
+		attribute.Int("product.id", key),
+		attribute.String("blob.backend", app.Images.Backend()),
+	))
+	defer span.End()
+	start := time.Now()
+	result := "error"
+	defer func() {
+		imageUploadDuration.WithLabelValues(app.Images.Backend(), result).Observe(time.Since(start).Seconds())
+	}()
+	fail := func(status int, err error) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		sendError(w, r, status, err)
+	}
+
+	// O produto é conferido antes de ler o arquivo; a contagem de imagens é refeita na transação
+	p := product{ID: key}
+	if err := p.getProduct(ctx, app.DB); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			result = "rejected"
+			fail(http.StatusNotFound, fmt.Errorf("product with ID %d not found", key))
+		} else {
+			logger.WithError(err).Error("Erro ao buscar produto para upload de imagem")
+			sqlErrorsTotal.Inc()
+			fail(http.StatusInternalServerError, errors.New("failed to upload image"))
+		}
+		return
+	}
+
+	data, err := readImageUpload(w, r)
+	var inputErr *imageInputError
+	if err == nil {
+		span.SetAttributes(attribute.Int("image.size_bytes", len(data)))
+		var processed *processedImage
+		if processed, err = processImage(ctx, data); err == nil {
+			img := productImage{
+				ProductID:            key,
+				ContentType:          processed.contentType,
+				SizeBytes:            len(data),
+				Width:                processed.width,
+				Height:               processed.height,
+				ThumbnailContentType: processed.thumbnailContentType,
+			}
+			app.storeProductImage(ctx, w, r, &img, data, processed.thumbnail, fail)
+			if img.ID != 0 {
+				result = "created"
+			}
+			return
+		}
+	}
+	if errors.As(err, &inputErr) {
+		result = "rejected"
+		logger.WithError(err).Warn("Imagem recusada no upload")
+		fail(inputErr.status, err)
+		return
+	}
+	logger.WithError(err).Error("Erro ao processar imagem")
+	fail(http.StatusInternalServerError, errors.New("failed to process image"))
+}
+
+// storeProductImage grava o original e a miniatura e depois a linha da imagem. Se a linha não
+// puder ser gravada, os arquivos são removidos.
+func (app *App) storeProductImage(ctx context.Context, w http.ResponseWriter, r *http.Request, img *productImage, original, thumb []byte, fail func(int, error)) {
+	logger := logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"component":  "http_handler",
+		"operation":  "upload_product_image",
+		"product_id": img.ProductID,
+		"backend":    app.Images.Backend(),
+	})
+
+	var err error
+	if img.originalKey, img.thumbnailKey, err = newImageKeys(img.ProductID, img.ContentType, img.ThumbnailContentType); err == nil {
+		if err = app.Images.Put(ctx, img.originalKey, original, img.ContentType); err == nil {
+			err = app.Images.Put(ctx, img.thumbnailKey, thumb, img.ThumbnailContentType)
+		}
+	}
+	if err != nil {
+		logger.WithError(err).Error("Erro ao gravar arquivos da imagem")
+		deleteImageBlobs(ctx, app.Images, *img)
+		fail(http.StatusInternalServerError, errors.New("failed to store image"))
+		return
+	}
+
+	err = app.withTx(ctx, func(tx *sql.Tx) error {
+		return img.createImage(ctx, tx)
+	})
+	if err != nil {
+		deleteImageBlobs(ctx, app.Images, *img)
+		img.ID = 0
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			fail(http.StatusNotFound, fmt.Errorf("product with ID %d not found", img.ProductID))
+		case errors.Is(err, errImageLimit):
+			fail(http.StatusConflict, err)
+		default:
+			logger.WithError(err).Error("Erro ao gravar imagem no banco de dados")
+			sqlErrorsTotal.Inc()
+			fail(http.StatusInternalServerError, errors.New("failed to store image"))
+		}
+		return
+	}
+
+	img.setURLs(apiVersionFromContext(r.Context()))
+	logger.WithFields(logrus.Fields{"image_id": img.ID, "content_type": img.ContentType, "size_bytes": img.SizeBytes}).Info("Imagem do produto gravada")
+	w.Header().Set("Location", img.URL)
+	sendResponse(r.Context(), w, http.StatusCreated, img)
+}
+
+func (app *App) getProductImages(w http.ResponseWriter, r *http.Request) {
+	key, _ := strconv.Atoi(mux.Vars(r)["id"])
+	p := product{ID: key}
+	err := p.getProduct(r.Context(), app.DB)
+	var images []productImage
+	if err == nil {
+		images, err = getProductImages(r.Context(), app.DB, key)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("product with ID %d not found", key))
+		} else {
+			logrus.WithContext(r.Context()).WithError(err).WithField("product_id", key).Error("Erro ao listar imagens do produto")
+			sqlErrorsTotal.Inc()
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve images"))
+		}
+		return
+	}
+	version := apiVersionFromContext(r.Context())
+	for i := range images {
+		images[i].setURLs(version)
+	}
+	sendResponse(r.Context(), w, http.StatusOK, images)
+}
+
+func (app *App) getProductImage(w http.ResponseWriter, r *http.Request) {
+	app.serveProductImage(w, r, false)
+}
+
+func (app *App) getProductImageThumbnail(w http.ResponseWriter, r *http.Request) {
+	app.serveProductImage(w, r, true)
+}
+
+// serveProductImage devolve o arquivo original ou a miniatura, lidos do armazenamento.
+// As chaves nunca são reutilizadas, então o arquivo pode ficar em cache indefinidamente.
+func (app *App) serveProductImage(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	vars := mux.Vars(r)
+	key, _ := strconv.Atoi(vars["id"])
+	imageID, _ := strconv.Atoi(vars["image_id"])
+	logger := logrus.WithContext(r.Context()).WithFields(logrus.Fields{
+		"product_id": key,
+		"image_id":   imageID,
+		"thumbnail":  thumbnail,
+	})
+
+	img := productImage{ID: imageID, ProductID: key}
+	if err := img.getImage(r.Context(), app.DB); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("image %d of product %d not found", imageID, key))
+		} else {
+			sqlErrorsTotal.Inc()
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve image"))
+		}
+		return
+	}
+
+	blobKey, contentType := img.originalKey, img.ContentType
+	if thumbnail {
+		blobKey, contentType = img.thumbnailKey, img.ThumbnailContentType
+	}
+	body, err := app.Images.Get(r.Context(), blobKey)
+	if err != nil {
+		if errors.Is(err, errBlobNotFound) {
+			logger.WithField("key", blobKey).Error("Arquivo da imagem ausente no armazenamento")
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("file of image %d not found", imageID))
+		} else {
+			logger.WithError(err).Error("Erro ao ler arquivo da imagem")
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to retrieve image"))
+		}
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	if !thumbnail {
+		w.Header().Set("Content-Length", strconv.Itoa(img.SizeBytes))
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, body); err != nil {
+		logger.WithError(err).Warn("Falha ao enviar arquivo da imagem")
+	}
+}
+
+func (app *App) deleteProductImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, _ := strconv.Atoi(vars["id"])
+	imageID, _ := strconv.Atoi(vars["image_id"])
+
+	img := productImage{ID: imageID, ProductID: key}
+	err := app.withTx(r.Context(), func(tx *sql.Tx) error {
+		return img.deleteImage(r.Context(), tx)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			sendError(w, r, http.StatusNotFound, fmt.Errorf("image %d of product %d not found", imageID, key))
+		} else {
+			logrus.WithContext(r.Context()).WithError(err).WithField("image_id", imageID).Error("Erro ao excluir imagem no banco de dados")
+			sqlErrorsTotal.Inc()
+			sendError(w, r, http.StatusInternalServerError, errors.New("failed to delete image"))
+		}
+		return
+	}
+	deleteImageBlobs(r.Context(), app.Images, img)
+	logrus.WithContext(r.Context()).WithFields(logrus.Fields{"product_id": key, "image_id": imageID}).Info("Imagem do produto excluída")
+	sendResponse(r.Context(), w, http.StatusOK, resultResponse{Result: "success"})
+}
+
+// --- Health Check (sem alterações, já usava PingContext) ---
+func (app *App) healthCheck(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := app.DB.PingContext(ctx); err != nil {
+		logrus.WithError(err).Warn("Health check falhou (DB ping)")
+		sendError(w, r, http.StatusServiceUnavailable, fmt.Errorf("database connection failed: %v", err))
+		return
+	}
+	sendResponse(r.Context(), w, http.StatusOK, healthStatus{Status: "ok", Database: "connected"})
+}
+
+// --- Atualização da Métrica de Contagem de Produtos ---
+
+// Função interna para buscar a contagem atual por categoria (agora passa contexto)
+func (app *App) getCurrentProductCount() (map[string]int, error) {
+	// Cria um contexto com timeout para esta chamada interna
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	// Passa o contexto criado para a função countProductsByCategory
+	counts, err := countProductsByCategory(ctx, app.DB) // Passando ctx
+	if err != nil {
+		logrus.WithError(err).Error("Erro ao contar produtos no banco de dados para métrica")
+		sqlErrorsTotal.Inc()
+		return nil, err
+	}
+	return counts, nil
+}
+
+// updateLowStockMetrics atualiza products_below_threshold e, se habilitado, product_stock_level
+func (app *App) updateLowStockMetrics() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	count, err := countLowStockProducts(ctx, app.DB)
+	if err != nil {
+		sqlErrorsTotal.Inc()
+		logrus.WithError(err).Warn("Falha ao atualizar a métrica 'products_below_threshold'")
+		return
+	}
+	productsBelowThreshold.Set(float64(count))
+
+	if !productStockLevelMetricsEnabled() {
+		return
+	}
+	products, err := getStockLevelsByProduct(ctx, app.DB)
+	if err != nil {
+		sqlErrorsTotal.Inc()
+		logrus.WithError(err).Warn("Falha ao atualizar a métrica 'product_stock_level'")
+		return
+	}
+	// Reset descarta as séries de produtos excluídos
+	productStockLevel.Reset()
+	for _, p := range products {
+		productStockLevel.With(prometheus.Labels{"product_id": strconv.Itoa(p.ID), "sku": p.SKU}).Set(float64(p.Quantity))
+	}
+}
+
+// updateStockByLocation atualiza o gauge products_stock_by_location
+func (app *App) updateStockByLocation() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	totals, err := countStockByLocation(ctx, app.DB)
+	if err != nil {
+		sqlErrorsTotal.Inc()
+		logrus.WithError(err).Warn("Falha ao atualizar a métrica 'products_stock_by_location'")
+		return
+	}
+	productsStockByLocation.Reset()
+	for code, quantity := range totals {
+		productsStockByLocation.With(prometheus.Labels{"location": code}).Set(float64(quantity))
+	}
+}
+
+// setProductsInDB substitui as séries do gauge, descartando categorias que não existem mais.
+// Devolve o total de produtos.
+func setProductsInDB(counts map[string]int) int {
+	total := 0
+	productsInDB.Reset()
+	for category, count := range counts {
+		productsInDB.With(prometheus.Labels{"category": category}).Set(float64(count))
+		total += count
+	}
+	return total
+}
+
+// Goroutine para atualizar periodicamente a métrica (sem alterações na lógica do ticker)
+func (app *App) startBackgroundProductCountUpdate() {
+	counts, err := app.getCurrentProductCount()
+	if err == nil {
+		total := setProductsInDB(counts)
+		logrus.Infof("Métrica inicial 'products_in_db' definida para: %d", total)
+	} else {
+		logrus.Warn("Não foi possível definir a métrica inicial 'products_in_db'")
+	}
+	app.updateStockByLocation()
+	app.updateLowStockMetrics()
+
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	logrus.Info("Iniciando atualização periódica da métrica 'products_in_db' a cada 5 minutos")
+
+	for range ticker.C {
+		counts, err := app.getCurrentProductCount()
+		if err == nil {
+			total := setProductsInDB(counts)
+			logrus.Debugf("Métrica 'products_in_db' atualizada para: %d", total)
+		} else {
+			logrus.Warn("Falha ao atualizar periodicamente a métrica 'products_in_db'")
+		}
+		app.updateStockByLocation()
+		app.updateLowStockMetrics()
+	}
+}
+
+// --- Purge da lixeira ---
+
+// trashRetention lê TRASH_RETENTION_DAYS (padrão 30 dias)
+func trashRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days < 1 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Goroutine que remove definitivamente os produtos que passaram do período de retenção na lixeira
+func (app *App) startBackgroundTrashPurge() {
+	retention := trashRetention()
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	logrus.Infof("Iniciando purge periódico da lixeira a cada 1 hora (retenção: %s)", retention)
+
+	for range ticker.C {
+		// As imagens saem antes dos produtos: depois do DELETE não há como achar os arquivos
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		images, err := purgeDeletedProductImages(ctx, app.DB, app.Images, retention)
+		cancel()
+		if err != nil {
+			sqlErrorsTotal.Inc()
+			logrus.WithError(err).Warn("Falha ao remover imagens dos produtos da lixeira")
+			continue
+		}
+		if images > 0 {
+			logrus.Infof("Purge da lixeira removeu %d imagens de produto", images)
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		purged, err := purgeDeletedProducts(ctx, app.DB, retention)
+		cancel()
+		if err != nil {
+			sqlErrorsTotal.Inc()
+			logrus.WithError(err).Warn("Falha ao executar o purge da lixeira")
+			continue
+		}
+		productsPurgedTotal.Add(float64(purged))
+		if purged > 0 {
+			logrus.Infof("Purge da lixeira removeu %d produtos", purged)
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		delivered, err := purgeDeliveredWebhooks(ctx, app.DB, webhookDeliveredRetention)
+		cancel()
+		if err != nil {
+			sqlErrorsTotal.Inc()
+			logrus.WithError(err).Warn("Falha ao remover entregas de webhook concluídas")
+		} else if delivered > 0 {
+			logrus.Infof("Purge removeu %d entregas de webhook concluídas", delivered)
+		}
+
+		// Mesmo ciclo remove as Idempotency-Keys vencidas (a tabela não cresce sem limite)
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		expired, err := purgeExpiredIdempotencyKeys(ctx, app.DB)
+		cancel()
+		if err != nil {
+			sqlErrorsTotal.Inc()
+			logrus.WithError(err).Warn("Falha ao remover chaves de idempotência vencidas")
+		} else if expired > 0 {
+			logrus.Infof("Purge removeu %d chaves de idempotência vencidas", expired)
+		}
+	}
+}
+
+// --- Expiração de reservas ---
+
+// Goroutine que expira as reservas vencidas e atualiza o gauge reservations_active,
+// no mesmo estilo do startBackgroundProductCountUpdate. O gauge só é alterado aqui, a partir
+// de um COUNT no banco, então vale para todas as instâncias da API.
+func (app *App) startBackgroundReservationSweeper() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	logrus.Info("Iniciando expiração periódica de reservas a cada 30 segundos")
+
+	for {
+		app.sweepExpiredReservations()
+		<-ticker.C
+	}
+}
+
+// --- Entrega de webhooks ---
+
+// webhookDeliveredRetention é quanto tempo as entregas concluídas ficam em webhook_deliveries
+const webhookDeliveredRetention = 7 * 24 * time.Hour
+
+// Goroutine que envia as entregas de webhook pendentes, no mesmo estilo do startBackgroundReservationSweeper
+func (app *App) startBackgroundWebhookDispatcher() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	logrus.Infof("Iniciando entrega periódica de webhooks a cada 5 segundos (máximo de %d tentativas)", webhookMaxAttempts())
+
+	for {
+		app.dispatchWebhooks()
+		<-ticker.C
+	}
+}
+
+// dispatchWebhooks envia as entregas vencidas, uma a uma. Cada entrega é reservada antes
+// do envio, então várias instâncias da API podem rodar o dispatcher ao mesmo tempo.
+func (app *App) dispatchWebhooks() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ids, err := getDueWebhookDeliveries(ctx, app.DB, 50)
+	cancel()
+	if err != nil {
+		sqlErrorsTotal.Inc()
+		logrus.WithError(err).Warn("Falha ao buscar entregas de webhook pendentes")
+		return
+	}
+
+	for _, id := range ids {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		d, err := claimWebhookDelivery(ctx, app.DB, id)
+		cancel()
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			sqlErrorsTotal.Inc()
+			logrus.WithError(err).WithField("delivery_id", id).Warn("Falha ao reservar entrega de webhook")
+			continue
+		}
+		deliverWebhook(app.DB, d)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	counts, err := countWebhookDeliveries(ctx, app.DB)
+	if err != nil {
+		logrus.WithError(err).Warn("Falha ao atualizar a métrica 'webhook_deliveries'")
+		return
+	}
+	for status, count := range counts {
+		webhookDeliveriesQueued.WithLabelValues(status).Set(float64(count))
+	}
+}
+
+// sweepExpiredReservations expira cada reserva vencida em uma transação própria,
+// assim uma falha em uma reserva não impede as demais
+func (app *App) sweepExpiredReservations() {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	ids, err := getExpiredReservationIDs(ctx, app.DB, 100)
+	if err != nil {
+		sqlErrorsTotal.Inc()
+		logrus.WithError(err).Warn("Falha ao buscar reservas vencidas")
+		return
+	}
+
+	for _, id := range ids {
+		res := reservation{ID: id}
+		// Cada expiração publica o product.updated do produto no stream após o commit
+		ctx := contextWithPendingProductEvents(ctx)
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err == nil {
+			err = res.finishReservation(ctx, tx, reservationExpired)
+			if err == nil {
+				err = app.commitTx(ctx, tx)
+			} else {
+				tx.Rollback()
+				app.Stream.publishCommitted(ctx, false)
+			}
+		}
+		// Outra requisição pode ter finalizado a reserva entre a busca e a transação
+		if err != nil && !errors.Is(err, errReservationNotActive) {
+			sqlErrorsTotal.Inc()
+			logrus.WithError(err).WithField("reservation_id", id).Warn("Falha ao expirar reserva")
+		}
+	}
+	if len(ids) > 0 {
+		logrus.Infof("Expiração de reservas processou %d reservas vencidas", len(ids))
+	}
+
+	count, err := countActiveReservations(ctx, app.DB)
+	if err != nil {
+		sqlErrorsTotal.Inc()
+		logrus.WithError(err).Warn("Falha ao atualizar a métrica 'reservations_active'")
+		return
+	}
+	reservationsActive.Set(float64(count))
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+// Um PUT não pode deixar quantity abaixo das unidades seguradas por reservas ativas
+func TestUpdateProductBelowReserved(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	app := &App{Router: mux.NewRouter(), DB: db}
+	if err := app.HandleRequests(); err != nil {
+		t.Fatalf("HandleRequests: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		quantity string
+		status   int
+	}{
+		{name: "abaixo do reservado", quantity: "2", status: http.StatusConflict},
+		{name: "zerando com reserva ativa", quantity: "0", status: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT .* FROM products WHERE id = \? AND deleted_at IS NULL FOR UPDATE`).
+				WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "quantity", "reserved", "available", "price",
+					"currency", "category_id", "reorder_threshold", "version", "deleted_at"}).
+					AddRow(1, "NB-001", "Notebook", 5, 3, 2, "4500.00", "BRL", nil, 0, 1, nil))
+			mock.ExpectRollback()
+
+			body := `{"sku":"NB-001","name":"Notebook","quantity":` + tt.quantity + `,"price":"4500.00"}`
+			r := httptest.NewRequest(http.MethodPut, "/v1/product/1", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("If-Match", `"1"`)
+			w := httptest.NewRecorder()
+			app.Router.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, esperado %d: %s", w.Code, tt.status, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), "is lower than the 3 units reserved for product 1") {
+				t.Errorf("corpo = %s", w.Body)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("banco: %v", err)
+			}
+		})
+	}
+}
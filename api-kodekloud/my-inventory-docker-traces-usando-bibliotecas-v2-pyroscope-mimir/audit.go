@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Ações registradas em audit_events
+const (
+	auditCreate  = "create"
+	auditUpdate  = "update"
+	auditDelete  = "delete"
+	auditRestore = "restore"
+)
+
+// actorHeader identifica quem fez a requisição. Sem ele, vale o usuário do Authorization: Basic.
+const actorHeader = "X-Actor"
+
+// anonymousActor é gravado quando a requisição não identifica o autor
+const anonymousActor = "anonymous"
+
+type actorKey struct{}
+
+// actorMiddleware coloca o autor da requisição no contexto, de onde as escritas de produto o leem
+func actorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := strings.TrimSpace(r.Header.Get(actorHeader))
+		if actor == "" {
+			actor, _, _ = r.BasicAuth()
+		}
+		next.ServeHTTP(w, r.WithContext(contextWithActor(r.Context(), actor)))
+	})
+}
+
+// contextWithActor guarda o autor no contexto (anonymous se vazio; no máximo 100 caracteres)
+func contextWithActor(ctx context.Context, actor string) context.Context {
+	if actor == "" {
+		actor = anonymousActor
+	}
+	if len(actor) > 100 {
+		actor = actor[:100]
+	}
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFromContext devolve o autor da requisição (anonymous fora de uma requisição HTTP)
+func actorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok {
+		return actor
+	}
+	return anonymousActor
+}
+
+// fieldChange é o valor de um campo antes e depois da escrita
+type fieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// auditDiff são os campos alterados, pelo nome do campo no JSON
+type auditDiff map[string]fieldChange
+
+// MarshalXML escreve um <change field="..."> por campo, na ordem dos nomes como no JSON.
+// Os valores vão como texto; before ou after null ficam de fora.
+func (d auditDiff) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	type change struct {
+		Field  string  `xml:"field,attr"`
+		Before *string `xml:"before"`
+		After  *string `xml:"after"`
+	}
+	changes := make([]change, 0, len(d))
+	for _, field := range sortedKeys(d) {
+		changes = append(changes, change{Field: field, Before: auditValueText(d[field].Before), After: auditValueText(d[field].After)})
+	}
+	return enc.EncodeElement(struct {
+		Changes []change `xml:"change"`
+	}{changes}, start)
+}
+
+// auditValueText formata um valor do diff lido do JSON; números inteiros não viram notação científica
+func auditValueText(value interface{}) *string {
+	var text string
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		text = fmt.Sprint(v)
+	}
+	return &text
+}
+
+// auditEvent é uma linha de audit_events
+type auditEvent struct {
+	ID        int64     `json:"id" xml:"id"`
+	ProductID int       `json:"product_id" xml:"product_id"`
+	Actor     string    `json:"actor" xml:"actor"`
+	Action    string    `json:"action" xml:"action"`
+	Diff      auditDiff `json:"diff" xml:"diff"`
+	TraceID   string    `json:"trace_id,omitempty" xml:"trace_id,omitempty"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+}
+
+// auditPage é a resposta do GET /audit
+type auditPage struct {
+	Events []auditEvent `json:"events" xml:"events>item"`
+	pageLimits
+}
+
+func (p auditPage) pageItems() interface{} {
+	return p.Events
+}
+
+// auditQuery são os filtros do GET /audit
+type auditQuery struct {
+	ProductID int
+	Actor     string
+	Since     *time.Time
+	Limit     int
+	Offset    int
+}
+
+// productDiff compara os campos do produto, como aparecem no JSON, e devolve só os alterados.
+// before ou after nil (criação e exclusão) aparecem como null.
+func productDiff(before, after *product) (map[string]fieldChange, error) {
+	toMap := func(p *product) (map[string]interface{}, error) {
+		fields := map[string]interface{}{}
+		if p == nil {
+			return fields, nil
+		}
+		data, err := json.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(data, &fields)
+		// available é calculado (quantity - reserved) e não é uma mudança por si só
+		delete(fields, "available")
+		return fields, err
+	}
+
+	beforeFields, err := toMap(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := toMap(after)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := map[string]fieldChange{}
+	for field, value := range afterFields {
+		if old, ok := beforeFields[field]; !ok || !reflect.DeepEqual(old, value) {
+			diff[field] = fieldChange{Before: beforeFields[field], After: value}
+		}
+	}
+	for field, old := range beforeFields {
+		if _, ok := afterFields[field]; !ok {
+			diff[field] = fieldChange{Before: old}
+		}
+	}
+	return diff, nil
+}
+
+// recordAuditEvent grava quem alterou o produto e como. Assim como recordProductHistory,
+// deve usar a mesma transação da escrita auditada.
+func recordAuditEvent(ctx context.Context, db dbExecutor, productID int, action string, before, after *product) error {
+	diff, err := productDiff(before, after)
+	if err != nil {
+		return fmt.Errorf("erro ao calcular diff de auditoria do produto %d: %w", productID, err)
+	}
+	diffJSON, err := json.Marshal(diff)
+	if err != nil {
+		return fmt.Errorf("erro ao serializar diff de auditoria do produto %d: %w", productID, err)
+	}
+
+	var traceID interface{} // NULL fora de uma requisição rastreada
+	if spanContext := trace.SpanFromContext(ctx).SpanContext(); spanContext.IsValid() {
+		traceID = spanContext.TraceID().String()
+	}
+
+	query := "INSERT INTO audit_events(product_id, actor, action, diff, trace_id, created_at) VALUES(?,?,?,?,?,?)"
+	_, err = db.ExecContext(ctx, query, productID, actorFromContext(ctx), action, string(diffJSON), traceID, time.Now().UTC().Truncate(time.Microsecond))
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithFields(logrus.Fields{
+			"component":  "database",
+			"operation":  "record_audit_event",
+			"product_id": productID,
+			"action":     action,
+		}).Error("Erro ao gravar evento de auditoria")
+		return fmt.Errorf("erro ao gravar auditoria do produto %d: %w", productID, err)
+	}
+	return nil
+}
+
+// getAuditEvents busca os eventos de auditoria, do mais recente para o mais antigo
+func getAuditEvents(ctx context.Context, db dbExecutor, q auditQuery) ([]auditEvent, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	if q.ProductID > 0 {
+		conditions = append(conditions, "product_id = ?")
+		args = append(args, q.ProductID)
+	}
+	if q.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, q.Actor)
+	}
+	if q.Since != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, q.Since.UTC())
+	}
+
+	query := "SELECT id, product_id, actor, action, diff, COALESCE(trace_id, ''), created_at FROM audit_events" +
+		whereClause(conditions) + " ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?"
+	args = append(args, q.Limit, q.Offset)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao executar QueryContext em getAuditEvents")
+		return nil, fmt.Errorf("erro ao buscar eventos de auditoria: %w", err)
+	}
+	defer rows.Close()
+
+	events := []auditEvent{}
+	for rows.Next() {
+		var e auditEvent
+		var diff []byte
+		if err := rows.Scan(&e.ID, &e.ProductID, &e.Actor, &e.Action, &diff, &e.TraceID, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("erro ao ler evento de auditoria: %w", err)
+		}
+		if err := json.Unmarshal(diff, &e.Diff); err != nil {
+			return nil, fmt.Errorf("erro ao ler diff do evento de auditoria %d: %w", e.ID, err)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao iterar sobre eventos de auditoria: %w", err)
+	}
+	return events, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProductDiff(t *testing.T) {
+	category, otherCategory := 2, 3
+	price, _ := parseMoney("10.00")
+	newPrice, _ := parseMoney("12.50")
+	deletedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	base := product{ID: 1, SKU: "NB-001", Name: "Notebook", Quantity: 5, Available: 5, Price: price,
+		Currency: "BRL", CategoryID: &category, Version: 1}
+
+	modify := func(change func(p *product)) *product {
+		p := base
+		change(&p)
+		return &p
+	}
+
+	tests := []struct {
+		name   string
+		before *product
+		after  *product
+		want   map[string]fieldChange
+	}{
+		{
+			name:   "sem alterações",
+			before: &base,
+			after:  modify(func(p *product) {}),
+			want:   map[string]fieldChange{},
+		},
+		{
+			name:   "só os campos alterados",
+			before: &base,
+			after:  modify(func(p *product) { p.Name = "Notebook Pro"; p.Price = newPrice; p.Version = 2 }),
+			want: map[string]fieldChange{
+				"name":    {Before: "Notebook", After: "Notebook Pro"},
+				"price":   {Before: "10.00", After: "12.50"},
+				"version": {Before: float64(1), After: float64(2)},
+			},
+		},
+		{
+			name:   "available calculado não é mudança",
+			before: &base,
+			after:  modify(func(p *product) { p.Reserved = 2; p.Available = 3 }),
+			want:   map[string]fieldChange{"reserved": {Before: float64(0), After: float64(2)}},
+		},
+		{
+			name:   "categoria trocada",
+			before: &base,
+			after:  modify(func(p *product) { p.CategoryID = &otherCategory }),
+			want:   map[string]fieldChange{"category_id": {Before: float64(2), After: float64(3)}},
+		},
+		{
+			name:   "categoria removida",
+			before: &base,
+			after:  modify(func(p *product) { p.CategoryID = nil }),
+			want:   map[string]fieldChange{"category_id": {Before: float64(2), After: nil}},
+		},
+		{
+			name:   "restauração limpa deleted_at",
+			before: modify(func(p *product) { p.DeletedAt = &deletedAt }),
+			after:  &base,
+			want:   map[string]fieldChange{"deleted_at": {Before: "2024-05-01T12:00:00Z", After: nil}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := productDiff(tt.before, tt.after)
+			if err != nil {
+				t.Fatalf("erro inesperado: %v", err)
+			}
+			if !reflect.DeepEqual(diff, tt.want) {
+				t.Errorf("diff = %#v, esperado %#v", diff, tt.want)
+			}
+		})
+	}
+}
+
+// Na criação e na exclusão definitiva o lado ausente aparece como null em todos os campos
+func TestProductDiffCreateAndDelete(t *testing.T) {
+	p := product{ID: 1, SKU: "NB-001", Name: "Notebook", Currency: "BRL"}
+
+	created, err := productDiff(nil, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := productDiff(&p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := created["available"]; ok {
+		t.Error("available no diff da criação")
+	}
+	if len(created) == 0 || len(created) != len(deleted) {
+		t.Fatalf("campos na criação/exclusão = %d/%d", len(created), len(deleted))
+	}
+	for field, change := range created {
+		if change.Before != nil {
+			t.Errorf("criação: %s.before = %v", field, change.Before)
+		}
+		if deleted[field].After != nil || !reflect.DeepEqual(deleted[field].Before, change.After) {
+			t.Errorf("exclusão: %s = %#v", field, deleted[field])
+		}
+	}
+	if created["sku"].After != "NB-001" {
+		t.Errorf("sku = %#v", created["sku"])
+	}
+}
+
+func TestActorMiddleware(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(r *http.Request)
+		want  string
+	}{
+		{name: "sem identificação", setup: func(r *http.Request) {}, want: anonymousActor},
+		{name: "X-Actor", setup: func(r *http.Request) { r.Header.Set(actorHeader, " maria ") }, want: "maria"},
+		{name: "Basic auth", setup: func(r *http.Request) { r.SetBasicAuth("joao", "senha") }, want: "joao"},
+		{
+			name: "X-Actor tem prioridade",
+			setup: func(r *http.Request) {
+				r.SetBasicAuth("joao", "senha")
+				r.Header.Set(actorHeader, "maria")
+			},
+			want: "maria",
+		},
+		{name: "truncado", setup: func(r *http.Request) { r.Header.Set(actorHeader, strings.Repeat("a", 150)) }, want: strings.Repeat("a", 100)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := actorMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = actorFromContext(r.Context())
+			}))
+			r := httptest.NewRequest(http.MethodPut, "/v1/product/1", nil)
+			tt.setup(r)
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("actor = %q, esperado %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := actorFromContext(context.Background()); got != anonymousActor {
+		t.Errorf("actor fora de requisição = %q", got)
+	}
+}
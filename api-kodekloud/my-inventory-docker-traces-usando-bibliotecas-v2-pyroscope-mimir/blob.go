@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errBlobNotFound indica que a chave não existe no armazenamento
+var errBlobNotFound = errors.New("blob não encontrado")
+
+// blobStore guarda os arquivos binários (imagens de produto) fora do banco. As chaves usam "/"
+// como separador (products/1/abc.jpg). Delete de uma chave inexistente não é erro.
+type blobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// Backend identifica a implementação nos logs, spans e métricas (local, s3)
+	Backend() string
+}
+
+// newBlobStore escolhe o armazenamento por IMAGE_STORAGE: local (padrão) ou s3
+func newBlobStore(ctx context.Context) (blobStore, error) {
+	switch backend := os.Getenv("IMAGE_STORAGE"); backend {
+	case "", "local":
+		dir := os.Getenv("IMAGE_STORAGE_DIR")
+		if dir == "" {
+			dir = "/var/lib/inventory/images"
+		}
+		return newLocalBlobStore(dir)
+	case "s3":
+		return newS3BlobStoreFromEnv(ctx)
+	default:
+		return nil, fmt.Errorf("IMAGE_STORAGE inválido: %q (use local ou s3)", backend)
+	}
+}
+
+// validBlobKey recusa chaves vazias, absolutas ou com "..", que sairiam do diretório/bucket
+func validBlobKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("chave de blob inválida: %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("chave de blob inválida: %q", key)
+		}
+	}
+	return nil
+}
+
+// --- Sistema de arquivos local ---
+
+// localBlobStore grava cada chave como um arquivo abaixo de dir
+type localBlobStore struct {
+	dir string
+}
+
+func newLocalBlobStore(dir string) (*localBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("erro ao criar o diretório das imagens %s: %w", dir, err)
+	}
+	logrus.WithField("dir", dir).Info("Imagens de produto no sistema de arquivos local")
+	return &localBlobStore{dir: dir}, nil
+}
+
+func (s *localBlobStore) Backend() string { return "local" }
+
+func (s *localBlobStore) path(key string) (string, error) {
+	if err := validBlobKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put grava em um arquivo temporário e renomeia, para um Get concorrente nunca ler o arquivo pela metade
+func (s *localBlobStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("erro ao criar o diretório de %s: %w", key, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("erro ao criar o arquivo de %s: %w", key, err)
+	}
+	defer os.Remove(tmp.Name()) // sem efeito depois do Rename
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("erro ao gravar %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("erro ao gravar %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("erro ao gravar %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *localBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errBlobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir %s: %w", key, err)
+	}
+	return f, nil
+}
+
+func (s *localBlobStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("erro ao remover %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Modos do POST /products/bulk
+const (
+	bulkModeAtomic     = "atomic"      // tudo ou nada: qualquer falha desfaz o lote inteiro
+	bulkModeBestEffort = "best_effort" // cada item é isolado por um SAVEPOINT dentro da mesma transação
+)
+
+// maxBulkOperations limita o tamanho de um lote
+const maxBulkOperations = 1000
+
+// bulkOperation é um item do lote. Version é opcional em update/delete:
+// quando informada, funciona como o If-Match das rotas individuais.
+type bulkOperation struct {
+	Op      string   `json:"op"` // create | update | delete
+	ID      int      `json:"id,omitempty"`
+	Version int      `json:"version,omitempty"`
+	Product *product `json:"product,omitempty"`
+}
+
+type bulkRequest struct {
+	Mode       string          `json:"mode"`
+	Operations []bulkOperation `json:"operations"`
+}
+
+// bulkResult é o resultado de um item, na mesma posição do item no lote
+type bulkResult struct {
+	Index   int      `json:"index" xml:"index"`
+	Op      string   `json:"op" xml:"op"`
+	ID      int      `json:"id,omitempty" xml:"id,omitempty"`
+	Status  int      `json:"status" xml:"status"`
+	Error   string   `json:"error,omitempty" xml:"error,omitempty"`
+	Product *product `json:"product,omitempty" xml:"product,omitempty"`
+}
+
+type bulkResponse struct {
+	Mode      string       `json:"mode" xml:"mode"`
+	Committed bool         `json:"committed" xml:"committed"`
+	Succeeded int          `json:"succeeded" xml:"succeeded"`
+	Failed    int          `json:"failed" xml:"failed"`
+	Results   []bulkResult `json:"results" xml:"results>item"`
+}
+
+// validateBulkRequest valida o lote como um todo (os itens são validados em runBulkOperation)
+func validateBulkRequest(req *bulkRequest) error {
+	if req.Mode == "" {
+		req.Mode = bulkModeAtomic
+	}
+	if req.Mode != bulkModeAtomic && req.Mode != bulkModeBestEffort {
+		return fmt.Errorf("mode must be %q or %q", bulkModeAtomic, bulkModeBestEffort)
+	}
+	if len(req.Operations) == 0 {
+		return errors.New("operations cannot be empty")
+	}
+	if len(req.Operations) > maxBulkOperations {
+		return fmt.Errorf("a batch cannot have more than %d operations", maxBulkOperations)
+	}
+	return nil
+}
+
+// runBulk executa o lote em uma única transação. No modo atomic a primeira falha
+// interrompe o lote e a transação é desfeita; no best_effort cada item roda entre
+// SAVEPOINTs, então só o item com falha é desfeito.
+// Cada item vira um evento no span do lote.
+func runBulk(ctx context.Context, tx *sql.Tx, req bulkRequest) (bulkResponse, error) {
+	span := trace.SpanFromContext(ctx)
+	resp := bulkResponse{Mode: req.Mode, Results: make([]bulkResult, 0, len(req.Operations))}
+
+	for i, op := range req.Operations {
+		if req.Mode == bulkModeBestEffort {
+			if _, err := tx.ExecContext(ctx, "SAVEPOINT bulk_item"); err != nil {
+				return resp, fmt.Errorf("erro ao criar savepoint do item %d: %w", i, err)
+			}
+		}
+
+		result, err := runBulkOperation(ctx, tx, i, op)
+		span.AddEvent("bulk.item", trace.WithAttributes(
+			attribute.Int("bulk.item.index", i),
+			attribute.String("bulk.item.op", op.Op),
+			attribute.Int("bulk.item.product_id", result.ID),
+			attribute.Int("bulk.item.status", result.Status),
+		))
+
+		if err != nil {
+			// Erro de SQL: no atomic interrompe o lote; no best_effort só o item falha
+			if req.Mode == bulkModeAtomic {
+				return resp, err
+			}
+			sqlErrorsTotal.Inc()
+		}
+
+		resp.Results = append(resp.Results, result)
+		if result.Error == "" {
+			resp.Succeeded++
+			continue
+		}
+
+		resp.Failed++
+		if req.Mode == bulkModeAtomic {
+			return resp, nil
+		}
+		if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT bulk_item"); err != nil {
+			return resp, fmt.Errorf("erro ao desfazer o item %d: %w", i, err)
+		}
+	}
+	return resp, nil
+}
+
+// runBulkOperation executa um item do lote. Falhas do item (dados inválidos, produto
+// inexistente, versão desatualizada) vão só no bulkResult; falhas de SQL também
+// devolvem error, para que o modo atomic interrompa o lote.
+func runBulkOperation(ctx context.Context, tx *sql.Tx, index int, op bulkOperation) (bulkResult, error) {
+	result := bulkResult{Index: index, Op: op.Op, ID: op.ID}
+	fail := func(status int, msg string) (bulkResult, error) {
+		result.Status = status
+		result.Error = msg
+		return result, nil
+	}
+
+	var p product
+	var err error
+	switch op.Op {
+	case "create", "update":
+		if op.Product == nil {
+			return fail(http.StatusBadRequest, "product is required")
+		}
+		if op.Op == "update" && op.ID <= 0 {
+			return fail(http.StatusBadRequest, "id is required")
+		}
+		p = *op.Product
+		if verr := p.validate(); verr != nil {
+			return fail(http.StatusBadRequest, verr.Error())
+		}
+		if op.Op == "create" {
+			err = p.createProduct(ctx, tx)
+			result.Status = http.StatusCreated
+		} else {
+			p.ID, p.Version = op.ID, op.Version
+			err = p.updateProduct(ctx, tx)
+			result.Status = http.StatusOK
+		}
+		result.ID = p.ID
+		result.Product = &p
+	case "delete":
+		if op.ID <= 0 {
+			return fail(http.StatusBadRequest, "id is required")
+		}
+		p = product{ID: op.ID, Version: op.Version}
+		err = p.deleteProduct(ctx, tx)
+		result.Status = http.StatusOK
+	default:
+		return fail(http.StatusBadRequest, fmt.Sprintf("invalid op %q", op.Op))
+	}
+
+	if err == nil {
+		return result, nil
+	}
+
+	result.Product = nil
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return fail(http.StatusNotFound, fmt.Sprintf("product with ID %d not found", op.ID))
+	case errors.Is(err, errVersionConflict):
+		return fail(http.StatusPreconditionFailed, fmt.Sprintf("product with ID %d has been modified", op.ID))
+	case errors.Is(err, errInsufficientStock):
+		return fail(http.StatusConflict, locatedStockError(p).Error())
+	case isMySQLError(err, mysqlErrNoReferencedRow):
+		return fail(http.StatusBadRequest, categoryNotFoundError(*op.Product).Error())
+	case isMySQLError(err, mysqlErrDuplicateEntry):
+		sqlDuplicateKeyConflictsTotal.With(prometheus.Labels{"table": "products"}).Inc()
+		return fail(http.StatusConflict, fmt.Sprintf("a product with SKU %q already exists", op.Product.SKU))
+	default:
+		logrus.WithContext(ctx).WithError(err).WithFields(logrus.Fields{
+			"component":  "database",
+			"operation":  "bulk_products",
+			"bulk_index": index,
+		}).Error("Erro de SQL em item do lote")
+		result.Status = http.StatusInternalServerError
+		result.Error = "failed to apply operation"
+		return result, fmt.Errorf("erro no item %d do lote: %w", index, err)
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// exportColumns é o cabeçalho do GET /products/export.csv
+var exportColumns = []string{"id", "sku", "name", "quantity", "reserved", "available", "price", "currency", "category_id", "reorder_threshold", "version"}
+
+// importFields são as colunas aceitas pelo POST /products/import. Outras colunas são ignoradas.
+var importFields = map[string]bool{
+	"id": true, "sku": true, "name": true, "quantity": true, "price": true,
+	"currency": true, "category_id": true, "reorder_threshold": true,
+}
+
+// Limites do import: cada lote de importBatchSize linhas vira um span
+const (
+	maxImportRows   = 10000
+	importBatchSize = 500
+)
+
+// exportProducts percorre os produtos que passam pelos filtros do GET /products, em ordem de id,
+// chamando fn linha a linha: o catálogo nunca fica inteiro em memória
+func exportProducts(ctx context.Context, db dbExecutor, q productQuery, fn func(p product) error) error {
+	conditions, args := q.filterConditions()
+	query := "SELECT " + productColumns + " FROM products" + whereClause(conditions) + " ORDER BY id"
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao executar QueryContext em exportProducts")
+		return fmt.Errorf("erro ao exportar produtos: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p product
+		if err := rows.Scan(p.scanFields()...); err != nil {
+			return fmt.Errorf("erro ao ler dados do produto: %w", err)
+		}
+		p.normalizePrice()
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// csvRecord formata o produto na ordem de exportColumns
+func csvRecord(p product) []string {
+	categoryID := ""
+	if p.CategoryID != nil {
+		categoryID = strconv.Itoa(*p.CategoryID)
+	}
+	return []string{
+		strconv.Itoa(p.ID), p.SKU, p.Name, strconv.Itoa(p.Quantity), strconv.Itoa(p.Reserved),
+		strconv.Itoa(p.Available), p.Price.String(), p.Currency, categoryID,
+		strconv.Itoa(p.ReorderThreshold), strconv.Itoa(p.Version),
+	}
+}
+
+// importRowError é uma linha recusada no import. Row é a linha do arquivo (o cabeçalho é a linha 1).
+type importRowError struct {
+	Row   int    `json:"row" xml:"row"`
+	SKU   string `json:"sku,omitempty" xml:"sku,omitempty"`
+	Error string `json:"error" xml:"error"`
+}
+
+// importReport é a resposta do POST /products/import
+type importReport struct {
+	DryRun         bool             `json:"dry_run" xml:"dry_run"`
+	Committed      bool             `json:"committed" xml:"committed"`
+	Rows           int              `json:"rows" xml:"rows"`
+	Created        int              `json:"created" xml:"created"`
+	Updated        int              `json:"updated" xml:"updated"`
+	Failed         int              `json:"failed" xml:"failed"`
+	IgnoredColumns []string         `json:"ignored_columns,omitempty" xml:"ignored_columns>item,omitempty"`
+	Errors         []importRowError `json:"errors" xml:"errors>item"`
+}
+
+// parseColumnMapping interpreta ?map=Produto:name,Estoque:quantity (coluna da planilha:campo)
+func parseColumnMapping(raw string) (map[string]string, error) {
+	mapping := map[string]string{}
+	if raw == "" {
+		return mapping, nil
+	}
+	for _, pair := range strings.Split(raw, ",") {
+		column, field, ok := strings.Cut(pair, ":")
+		column, field = strings.ToLower(strings.TrimSpace(column)), strings.ToLower(strings.TrimSpace(field))
+		if !ok || column == "" || !importFields[field] {
+			return nil, fmt.Errorf("invalid column mapping %q: use column:field with field one of id, sku, name, quantity, price, currency, category_id, reorder_threshold", pair)
+		}
+		mapping[column] = field
+	}
+	return mapping, nil
+}
+
+// importHeader resolve o cabeçalho: devolve o campo de cada coluna ("" para colunas ignoradas)
+func importHeader(header []string, mapping map[string]string) ([]string, []string, error) {
+	fields := make([]string, len(header))
+	ignored := []string{}
+	seen := map[string]bool{}
+	for i, column := range header {
+		name := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))) // BOM do Excel
+		field, ok := mapping[name]
+		if !ok && importFields[name] {
+			field = name
+		}
+		if field == "" {
+			ignored = append(ignored, column)
+			continue
+		}
+		if seen[field] {
+			return nil, nil, fmt.Errorf("field %q is mapped by more than one column", field)
+		}
+		seen[field] = true
+		fields[i] = field
+	}
+	if !seen["id"] && !seen["sku"] {
+		return nil, nil, errors.New("the CSV needs an id or sku column to match products")
+	}
+	return fields, ignored, nil
+}
+
+// applyImportRecord copia as células preenchidas para o produto. Células vazias mantêm o valor atual.
+func applyImportRecord(p *product, fields, record []string) error {
+	for i, field := range fields {
+		if field == "" || field == "id" || i >= len(record) {
+			continue
+		}
+		value := strings.TrimSpace(record[i])
+		if value == "" {
+			continue
+		}
+		var err error
+		switch field {
+		case "sku":
+			p.SKU = value
+		case "name":
+			p.Name = value
+		case "currency":
+			p.Currency = strings.ToUpper(value)
+		case "quantity":
+			p.Quantity, err = strconv.Atoi(value)
+		case "reorder_threshold":
+			p.ReorderThreshold, err = strconv.Atoi(value)
+		case "price":
+			p.Price, err = parseMoney(value)
+		case "category_id":
+			var id int
+			id, err = strconv.Atoi(value)
+			p.CategoryID = &id
+		}
+		if err != nil {
+			return fmt.Errorf("invalid value %q for %s", value, field)
+		}
+	}
+	return nil
+}
+
+// importRow faz o upsert de uma linha: atualiza o produto com o id informado ou, sem id,
+// o produto com o mesmo SKU; se nenhum existir, cria. Falhas da linha vão em rowErr;
+// err só é devolvido para erros de SQL, que interrompem o import.
+func importRow(ctx context.Context, tx *sql.Tx, fields, record []string) (created bool, rowErr, err error) {
+	var id int
+	var sku string
+	for i, field := range fields {
+		if i >= len(record) {
+			break
+		}
+		switch field {
+		case "id":
+			if v := strings.TrimSpace(record[i]); v != "" {
+				if id, err = strconv.Atoi(v); err != nil || id <= 0 {
+					return false, fmt.Errorf("invalid value %q for id", v), nil
+				}
+			}
+		case "sku":
+			sku = strings.TrimSpace(record[i])
+		}
+	}
+
+	existing := product{ID: id, SKU: sku}
+	switch {
+	case id > 0:
+		err = existing.getProductForUpdate(ctx, tx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, fmt.Errorf("product with ID %d not found", id), nil
+		}
+	case sku != "":
+		err = existing.getProductBySKU(ctx, tx)
+		if errors.Is(err, sql.ErrNoRows) {
+			existing, err = product{}, nil
+			created = true
+		}
+	default:
+		return false, errors.New("id or sku is required"), nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+
+	p := existing
+	if rowErr := applyImportRecord(&p, fields, record); rowErr != nil {
+		return false, rowErr, nil
+	}
+	if rowErr := p.validate(); rowErr != nil {
+		return false, rowErr, nil
+	}
+
+	if created {
+		err = p.createProduct(ctx, tx)
+	} else {
+		err = p.updateProduct(ctx, tx)
+	}
+	switch {
+	case err == nil:
+		return created, nil, nil
+	case isMySQLError(err, mysqlErrNoReferencedRow):
+		return false, categoryNotFoundError(p), nil
+	case isMySQLError(err, mysqlErrDuplicateEntry):
+		return false, fmt.Errorf("a product with SKU %q already exists", p.SKU), nil
+	case errors.Is(err, errInsufficientStock):
+		return false, locatedStockError(p), nil
+	case errors.Is(err, errVersionConflict):
+		return false, fmt.Errorf("product with ID %d was modified during the import", p.ID), nil
+	}
+	return false, nil, err
+}
+
+// runImport lê o CSV e aplica as linhas na transação tx. Cada linha roda entre SAVEPOINTs,
+// então uma linha recusada não afeta as outras e todas as falhas aparecem no relatório.
+// Cada lote de importBatchSize linhas é um span (import_batch).
+func runImport(ctx context.Context, tx *sql.Tx, reader *csv.Reader, mapping map[string]string) (importReport, error) {
+	report := importReport{Errors: []importRowError{}}
+
+	header, err := reader.Read()
+	if err != nil {
+		return report, &importInputError{fmt.Errorf("invalid CSV header: %w", err)}
+	}
+	fields, ignored, err := importHeader(header, mapping)
+	if err != nil {
+		return report, &importInputError{err}
+	}
+	report.IgnoredColumns = ignored
+
+	tracer := otel.Tracer("inventory-app")
+	var batchSpan trace.Span
+	batchCtx, batchFailed := ctx, 0
+	endBatch := func() {
+		if batchSpan != nil {
+			batchSpan.SetAttributes(attribute.Int("import.batch.failed", batchFailed))
+			batchSpan.End()
+			batchSpan = nil
+		}
+	}
+	defer endBatch()
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return report, &importInputError{fmt.Errorf("failed to read CSV: %w", err)}
+		}
+		if report.Rows == maxImportRows {
+			return report, &importInputError{fmt.Errorf("an import cannot have more than %d rows", maxImportRows)}
+		}
+		report.Rows++
+		if report.Rows%importBatchSize == 1 {
+			endBatch()
+			batchCtx, batchSpan = tracer.Start(ctx, "import_batch", trace.WithAttributes(
+				attribute.Int("import.batch.index", report.Rows/importBatchSize),
+				attribute.Int("import.batch.first_row", line),
+			))
+			batchFailed = 0
+		}
+
+		var rowErr error
+		created := false
+		if err != nil {
+			// Linha malformada (ex: aspas sem fechar): reportada, sem tocar no banco
+			rowErr = err
+		} else {
+			if _, err := tx.ExecContext(batchCtx, "SAVEPOINT import_row"); err != nil {
+				return report, fmt.Errorf("erro ao criar savepoint da linha %d: %w", line, err)
+			}
+			created, rowErr, err = importRow(batchCtx, tx, fields, record)
+			if err != nil {
+				batchSpan.RecordError(err)
+				batchSpan.SetStatus(codes.Error, "import row failed")
+				return report, fmt.Errorf("erro na linha %d do import: %w", line, err)
+			}
+			if rowErr != nil {
+				if _, err := tx.ExecContext(batchCtx, "ROLLBACK TO SAVEPOINT import_row"); err != nil {
+					return report, fmt.Errorf("erro ao desfazer a linha %d: %w", line, err)
+				}
+			}
+		}
+
+		switch {
+		case rowErr != nil:
+			report.Failed++
+			batchFailed++
+			rowError := importRowError{Row: line, Error: rowErr.Error()}
+			for i, field := range fields {
+				if field == "sku" && i < len(record) {
+					rowError.SKU = record[i]
+				}
+			}
+			report.Errors = append(report.Errors, rowError)
+		case created:
+			report.Created++
+		default:
+			report.Updated++
+		}
+	}
+
+	if report.Rows == 0 {
+		return report, &importInputError{errors.New("the CSV has no data rows")}
+	}
+	return report, nil
+}
+
+// errImportNotCommitted desfaz a transação de um import em dry-run ou com linhas recusadas
+var errImportNotCommitted = errors.New("import não gravado")
+
+// importInputError é um CSV inválido como um todo (cabeçalho, tamanho): vira 400, não 500
+type importInputError struct{ err error }
+
+func (e *importInputError) Error() string { return e.err.Error() }
+func (e *importInputError) Unwrap() error { return e.err }
+
+// newImportReader configura o leitor de CSV. ";" é aceito porque é o separador padrão
+// das planilhas em português.
+func newImportReader(r *http.Request, body io.Reader) (*csv.Reader, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1 // linhas curtas são tratadas como células vazias
+	reader.ReuseRecord = true
+	switch delimiter := r.URL.Query().Get("delimiter"); delimiter {
+	case "", ",":
+	case ";":
+		reader.Comma = ';'
+	default:
+		return nil, errors.New("delimiter must be ',' or ';'")
+	}
+	return reader, nil
+}
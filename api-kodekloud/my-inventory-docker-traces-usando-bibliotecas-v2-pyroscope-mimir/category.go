@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Struct category: cada produto pertence a no máximo uma categoria (products.category_id)
+type category struct {
+	ID          int    `json:"id" xml:"id"`
+	Name        string `json:"name" xml:"name"`
+	Description string `json:"description" xml:"description"`
+}
+
+// validate aplica as regras de negócio da categoria, usadas no POST e no PUT
+func (c category) validate() error {
+	if c.Name == "" || utf8.RuneCountInString(c.Name) > 100 {
+		return errors.New("invalid category data: name is required and must have at most 100 characters")
+	}
+	return nil
+}
+
+// getCategoriesFromDB busca todas as categorias ordenadas pelo nome
+func getCategoriesFromDB(ctx context.Context, db dbExecutor) ([]category, error) {
+	query := "SELECT id, name, description FROM categories ORDER BY name"
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"component": "database",
+			"operation": "get_categories",
+			"error":     err.Error(),
+		}).Error("Erro ao executar QueryContext em getCategoriesFromDB")
+		return nil, fmt.Errorf("erro ao buscar categorias: %w", err)
+	}
+	defer rows.Close()
+
+	categories := []category{}
+	for rows.Next() {
+		var c category
+		if err := rows.Scan(&c.ID, &c.Name, &c.Description); err != nil {
+			return nil, fmt.Errorf("erro ao ler dados da categoria: %w", err)
+		}
+		categories = append(categories, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao iterar sobre categorias: %w", err)
+	}
+	return categories, nil
+}
+
+// getCategory busca uma categoria pelo ID
+func (c *category) getCategory(ctx context.Context, db dbExecutor) error {
+	query := "SELECT name, description FROM categories WHERE id = ?"
+	err := db.QueryRowContext(ctx, query, c.ID).Scan(&c.Name, &c.Description)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return sql.ErrNoRows
+		}
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"component":   "database",
+			"operation":   "get_category",
+			"category_id": c.ID,
+			"error":       err.Error(),
+		}).Error("Erro ao buscar categoria")
+		return fmt.Errorf("erro ao buscar categoria %d: %w", c.ID, err)
+	}
+	return nil
+}
+
+// createCategory cria uma categoria. Nome duplicado devolve o erro 1062 do MySQL.
+func (c *category) createCategory(ctx context.Context, db dbExecutor) error {
+	query := "INSERT INTO categories(name, description) VALUES(?,?)"
+	result, err := db.ExecContext(ctx, query, c.Name, c.Description)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"component": "database",
+			"operation": "create_category",
+			"error":     err.Error(),
+		}).Warn("Erro ao executar ExecContext em createCategory")
+		return fmt.Errorf("erro ao criar categoria: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("erro ao obter ID da categoria: %w", err)
+	}
+	c.ID = int(id)
+	return nil
+}
+
+// updateCategory atualiza uma categoria. Devolve sql.ErrNoRows se o ID não existir.
+func (c *category) updateCategory(ctx context.Context, db dbExecutor) error {
+	if err := c.exists(ctx, db); err != nil {
+		return err
+	}
+	query := "UPDATE categories SET name =?, description =? WHERE id =?"
+	if _, err := db.ExecContext(ctx, query, c.Name, c.Description, c.ID); err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"component":   "database",
+			"operation":   "update_category",
+			"category_id": c.ID,
+			"error":       err.Error(),
+		}).Warn("Erro ao executar ExecContext em updateCategory")
+		return fmt.Errorf("erro ao atualizar categoria %d: %w", c.ID, err)
+	}
+	return nil
+}
+
+// deleteCategory exclui uma categoria. Se ainda houver produtos nela, o MySQL devolve o erro 1451.
+func (c *category) deleteCategory(ctx context.Context, db dbExecutor) error {
+	query := "DELETE FROM categories WHERE id =?"
+	result, err := db.ExecContext(ctx, query, c.ID)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"component":   "database",
+			"operation":   "delete_category",
+			"category_id": c.ID,
+			"error":       err.Error(),
+		}).Warn("Erro ao executar ExecContext em deleteCategory")
+		return fmt.Errorf("erro ao excluir categoria %d: %w", c.ID, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("erro ao obter linhas afetadas para categoria %d: %w", c.ID, err)
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// exists devolve sql.ErrNoRows se a categoria não existir. O UPDATE sozinho não serve,
+// pois o MySQL conta 0 linhas afetadas quando os valores não mudam.
+func (c *category) exists(ctx context.Context, db dbExecutor) error {
+	var exists bool
+	query := "SELECT EXISTS(SELECT 1 FROM categories WHERE id = ?)"
+	if err := db.QueryRowContext(ctx, query, c.ID).Scan(&exists); err != nil {
+		return fmt.Errorf("erro ao verificar existência da categoria %d: %w", c.ID, err)
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -1,22 +1,287 @@
 #!/bin/bash
+# Cria o schema do inventory. Pode ser executado de novo em um volume existente: as tabelas usam
+# IF NOT EXISTS e as colunas adicionadas depois da criação entram por ALTER TABLE só quando faltam.
+# Na inicialização do MySQL (docker-entrypoint-initdb.d) roda pelo socket local; o serviço
+# db-migrate do docker-compose roda o mesmo script pela rede, com MYSQL_HOST=mysql.
+set -e
 
-mysql -u root -p"${MYSQL_ROOT_PASSWORD}" <<EOF
-CREATE DATABASE IF NOT EXISTS inventory;
-USE inventory;
+MYSQL_HOST="${MYSQL_HOST:-localhost}"
+
+if [ "$MYSQL_HOST" != "localhost" ]; then
+    for attempt in $(seq 1 60); do
+        mysqladmin ping -h "$MYSQL_HOST" -u root -p"${MYSQL_ROOT_PASSWORD}" --silent && break
+        echo "Aguardando o MySQL em $MYSQL_HOST ($attempt/60)..."
+        sleep 2
+    done
+fi
+
+run_sql() {
+    mysql -h "$MYSQL_HOST" -u root -p"${MYSQL_ROOT_PASSWORD}" inventory
+}
+
+mysql -h "$MYSQL_HOST" -u root -p"${MYSQL_ROOT_PASSWORD}" -e "CREATE DATABASE IF NOT EXISTS inventory"
+
+run_sql <<EOF
+-- setup_alter aplica o ALTER TABLE só se a coluna, o índice ou a constraint p_object ainda não existir.
+-- setup_modify aplica o ALTER TABLE só se o tipo da coluna for diferente de p_type.
+DROP PROCEDURE IF EXISTS setup_alter;
+DROP PROCEDURE IF EXISTS setup_modify;
+DELIMITER //
+CREATE PROCEDURE setup_alter(IN p_table VARCHAR(64), IN p_object VARCHAR(64), IN p_ddl TEXT)
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.COLUMNS
+                   WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = p_table AND COLUMN_NAME = p_object)
+       AND NOT EXISTS (SELECT 1 FROM information_schema.STATISTICS
+                       WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = p_table AND INDEX_NAME = p_object)
+       AND NOT EXISTS (SELECT 1 FROM information_schema.TABLE_CONSTRAINTS
+                       WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = p_table AND CONSTRAINT_NAME = p_object) THEN
+        SET @setup_ddl = CONCAT('ALTER TABLE ', p_table, ' ', p_ddl);
+        PREPARE stmt FROM @setup_ddl;
+        EXECUTE stmt;
+        DEALLOCATE PREPARE stmt;
+    END IF;
+END //
+CREATE PROCEDURE setup_modify(IN p_table VARCHAR(64), IN p_column VARCHAR(64), IN p_type TEXT, IN p_ddl TEXT)
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.COLUMNS
+               WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = p_table AND COLUMN_NAME = p_column AND COLUMN_TYPE <> p_type) THEN
+        SET @setup_ddl = CONCAT('ALTER TABLE ', p_table, ' ', p_ddl);
+        PREPARE stmt FROM @setup_ddl;
+        EXECUTE stmt;
+        DEALLOCATE PREPARE stmt;
+    END IF;
+END //
+DELIMITER ;
+
+CREATE TABLE IF NOT EXISTS categories (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    UNIQUE KEY uq_categories_name (name)
+);
 
 CREATE TABLE IF NOT EXISTS products (
     id INT AUTO_INCREMENT PRIMARY KEY,
+    sku VARCHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    price DECIMAL(15,3) NOT NULL,
+    currency CHAR(3) NOT NULL DEFAULT 'BRL',
+    quantity INT NOT NULL,
+    reserved INT NOT NULL DEFAULT 0,
+    category_id INT NULL DEFAULT NULL,
+    reorder_threshold INT NOT NULL DEFAULT 0,
+    version INT NOT NULL DEFAULT 1,
+    deleted_at DATETIME NULL DEFAULT NULL,
+    UNIQUE KEY uq_products_sku (sku),
+    INDEX idx_products_deleted_at (deleted_at),
+    INDEX idx_products_currency (currency),
+    CONSTRAINT fk_products_category FOREIGN KEY (category_id) REFERENCES categories (id) ON DELETE RESTRICT
+);
+
+-- Colunas que entraram depois da primeira versão da tabela (volumes antigos tinham só id, name, price e quantity)
+CALL setup_alter('products', 'version', 'ADD COLUMN version INT NOT NULL DEFAULT 1 AFTER quantity');
+CALL setup_alter('products', 'deleted_at', 'ADD COLUMN deleted_at DATETIME NULL DEFAULT NULL AFTER version');
+CALL setup_alter('products', 'idx_products_deleted_at', 'ADD INDEX idx_products_deleted_at (deleted_at)');
+CALL setup_alter('products', 'category_id', 'ADD COLUMN category_id INT NULL DEFAULT NULL AFTER quantity');
+CALL setup_alter('products', 'fk_products_category',
+    'ADD CONSTRAINT fk_products_category FOREIGN KEY (category_id) REFERENCES categories (id) ON DELETE RESTRICT');
+CALL setup_alter('products', 'sku', "ADD COLUMN sku VARCHAR(64) NOT NULL DEFAULT '' AFTER id");
+UPDATE products SET sku = CONCAT('SKU-', id) WHERE sku = '';
+ALTER TABLE products ALTER COLUMN sku DROP DEFAULT;
+CALL setup_alter('products', 'uq_products_sku', 'ADD UNIQUE KEY uq_products_sku (sku)');
+CALL setup_alter('products', 'reserved', 'ADD COLUMN reserved INT NOT NULL DEFAULT 0 AFTER quantity');
+CALL setup_alter('products', 'reorder_threshold', 'ADD COLUMN reorder_threshold INT NOT NULL DEFAULT 0 AFTER category_id');
+CALL setup_modify('products', 'price', 'decimal(15,3)', 'MODIFY price DECIMAL(15,3) NOT NULL');
+CALL setup_alter('products', 'currency', "ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'BRL' AFTER price");
+CALL setup_alter('products', 'idx_products_currency', 'ADD INDEX idx_products_currency (currency)');
+
+-- Depósitos; MAIN é o depósito padrão usado por POST/PUT/PATCH de produto
+CREATE TABLE IF NOT EXISTS locations (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    code VARCHAR(20) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    UNIQUE KEY uq_locations_code (code)
+);
+
+INSERT INTO locations (code, name)
+SELECT 'MAIN', 'Depósito principal'
+WHERE NOT EXISTS (SELECT 1 FROM locations WHERE code = 'MAIN');
+
+-- Saldo de cada produto por depósito; products.quantity é a soma destas linhas
+CREATE TABLE IF NOT EXISTS product_stock (
+    product_id INT NOT NULL,
+    location_id INT NOT NULL,
+    quantity INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (product_id, location_id),
+    CONSTRAINT fk_product_stock_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE,
+    CONSTRAINT fk_product_stock_location FOREIGN KEY (location_id) REFERENCES locations (id) ON DELETE CASCADE
+);
+
+-- Livro-razão de estoque: cada alteração de quantity/reserved feita por POST /product/{id}/stock
+-- e cada perna de uma transferência entre depósitos (location_id é NULL para reserve/release)
+CREATE TABLE IF NOT EXISTS stock_movements (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    product_id INT NOT NULL,
+    location_id INT NULL DEFAULT NULL,
+    type ENUM('adjust', 'receive', 'ship', 'reserve', 'release', 'transfer') NOT NULL,
+    quantity INT NOT NULL,
+    reason VARCHAR(50) NOT NULL,
+    quantity_after INT NOT NULL,
+    reserved_after INT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_stock_movements_product (product_id, id),
+    CONSTRAINT fk_stock_movements_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE,
+    CONSTRAINT fk_stock_movements_location FOREIGN KEY (location_id) REFERENCES locations (id) ON DELETE SET NULL
+);
+
+CALL setup_alter('stock_movements', 'location_id', 'ADD COLUMN location_id INT NULL DEFAULT NULL AFTER product_id');
+CALL setup_modify('stock_movements', 'type', "enum('adjust','receive','ship','reserve','release','transfer')",
+    "MODIFY type ENUM('adjust', 'receive', 'ship', 'reserve', 'release', 'transfer') NOT NULL");
+CALL setup_alter('stock_movements', 'fk_stock_movements_location',
+    'ADD CONSTRAINT fk_stock_movements_location FOREIGN KEY (location_id) REFERENCES locations (id) ON DELETE SET NULL');
+
+-- Histórico temporal: uma linha com o estado completo do produto a cada escrita, gravada na
+-- mesma transação da escrita. Sem FOREIGN KEY para sobreviver ao purge da lixeira.
+CREATE TABLE IF NOT EXISTS product_history (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    product_id INT NOT NULL,
+    sku VARCHAR(64) NOT NULL,
     name VARCHAR(255) NOT NULL,
-    price DECIMAL(10,2) NOT NULL,
-    quantity INT NOT NULL
+    quantity INT NOT NULL,
+    reserved INT NOT NULL,
+    price DECIMAL(15,3) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    category_id INT NULL DEFAULT NULL,
+    reorder_threshold INT NOT NULL,
+    version INT NOT NULL,
+    deleted_at DATETIME NULL DEFAULT NULL,
+    operation ENUM('create', 'update', 'delete', 'restore', 'stock') NOT NULL,
+    changed_at DATETIME(6) NOT NULL,
+    INDEX idx_product_history_product (product_id, changed_at)
+);
+
+CALL setup_modify('product_history', 'price', 'decimal(15,3)', 'MODIFY price DECIMAL(15,3) NOT NULL');
+CALL setup_alter('product_history', 'currency', "ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'BRL' AFTER price");
+
+-- Auditoria: quem alterou o produto, como (diff antes/depois) e em qual trace.
+-- Gravada na mesma transação da escrita; sem FOREIGN KEY para sobreviver ao purge da lixeira.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    product_id INT NOT NULL,
+    actor VARCHAR(100) NOT NULL,
+    action ENUM('create', 'update', 'delete', 'restore') NOT NULL,
+    diff JSON NOT NULL,
+    trace_id CHAR(32) NULL DEFAULT NULL,
+    created_at DATETIME(6) NOT NULL,
+    INDEX idx_audit_events_product (product_id, created_at),
+    INDEX idx_audit_events_actor (actor, created_at),
+    INDEX idx_audit_events_created_at (created_at)
+);
+
+-- Idempotency-Key do POST /product: a resposta fica gravada até expires_at
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    idem_key VARCHAR(255) NOT NULL PRIMARY KEY,
+    request_hash CHAR(64) NOT NULL,
+    status_code SMALLINT NULL DEFAULT NULL,
+    response_body MEDIUMTEXT NULL DEFAULT NULL, -- texto, e não JSON, para repetir os bytes originais
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    INDEX idx_idempotency_keys_expires (expires_at)
 );
 
+-- Inscrições de webhooks e a fila de entregas (outbox). dead é a dead-letter list.
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    url VARCHAR(2048) NOT NULL,
+    secret VARCHAR(128) NOT NULL,
+    events VARCHAR(255) NOT NULL, -- lista separada por vírgula: product.created,product.updated,product.deleted
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    subscription_id INT NOT NULL,
+    event VARCHAR(32) NOT NULL,
+    product_id INT NOT NULL,
+    payload MEDIUMTEXT NOT NULL,
+    traceparent VARCHAR(55) NULL DEFAULT NULL,
+    status ENUM('pending', 'delivered', 'dead') NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME(6) NOT NULL,
+    last_status_code SMALLINT NULL DEFAULT NULL,
+    last_error VARCHAR(1024) NULL DEFAULT NULL,
+    created_at DATETIME(6) NOT NULL,
+    updated_at DATETIME(6) NOT NULL,
+    INDEX idx_webhook_deliveries_due (status, next_attempt_at),
+    CONSTRAINT fk_webhook_deliveries_subscription FOREIGN KEY (subscription_id) REFERENCES webhook_subscriptions (id) ON DELETE CASCADE
+);
+
+-- Reservas de estoque com prazo; só as active seguram unidades em products.reserved
+CREATE TABLE IF NOT EXISTS reservations (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    product_id INT NOT NULL,
+    quantity INT NOT NULL,
+    status ENUM('active', 'confirmed', 'cancelled', 'expired') NOT NULL,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_reservations_status_expires (status, expires_at),
+    CONSTRAINT fk_reservations_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE
+);
+
+-- Imagens de produto; os arquivos (original e miniatura) ficam no blob store, nas chaves abaixo
+CREATE TABLE IF NOT EXISTS product_images (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    product_id INT NOT NULL,
+    content_type VARCHAR(50) NOT NULL,
+    size_bytes INT NOT NULL,
+    width INT NOT NULL,
+    height INT NOT NULL,
+    thumbnail_content_type VARCHAR(50) NOT NULL,
+    original_key VARCHAR(255) NOT NULL,
+    thumbnail_key VARCHAR(255) NOT NULL,
+    created_at DATETIME NOT NULL,
+    INDEX idx_product_images_product (product_id),
+    CONSTRAINT fk_product_images_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE
+);
+
+DROP PROCEDURE setup_alter;
+DROP PROCEDURE setup_modify;
+EOF
+
+# Dados de exemplo só na criação do volume; o db-migrate usa SEED_SAMPLE_DATA=false para não
+# recriar produtos e categorias que tenham sido excluídos
+if [ "${SEED_SAMPLE_DATA:-true}" = "true" ]; then
+run_sql <<EOF
+-- Insere as categorias de exemplo apenas se a tabela estiver vazia
+INSERT INTO categories (name, description)
+SELECT * FROM (SELECT 'Computadores', 'Notebooks, desktops e monitores' UNION ALL
+               SELECT 'Periféricos', 'Mouses, teclados e acessórios' UNION ALL
+               SELECT 'Móveis', 'Cadeiras e mesas') AS tmp
+WHERE NOT EXISTS (SELECT 1 FROM categories LIMIT 1);
+
 -- Insere os produtos apenas se a tabela estiver vazia
-INSERT INTO products (name, price, quantity)
-SELECT * FROM (SELECT 'Notebook', 3500.00, 10 UNION ALL
-               SELECT 'Mouse', 150.00, 25 UNION ALL
-               SELECT 'Teclado', 200.00, 15 UNION ALL
-               SELECT 'Monitor', 1200.00, 8 UNION ALL
-               SELECT 'Cadeira Gamer', 800.00, 5) AS tmp
+INSERT INTO products (sku, name, price, quantity, category_id, reorder_threshold)
+SELECT tmp.sku, tmp.name, tmp.price, tmp.quantity, c.id, tmp.reorder_threshold
+FROM (SELECT 'NB-001' AS sku, 'Notebook' AS name, 3500.00 AS price, 10 AS quantity, 'Computadores' AS category, 3 AS reorder_threshold UNION ALL
+      SELECT 'MS-001', 'Mouse', 150.00, 25, 'Periféricos', 10 UNION ALL
+      SELECT 'KB-001', 'Teclado', 200.00, 15, 'Periféricos', 5 UNION ALL
+      SELECT 'MN-001', 'Monitor', 1200.00, 8, 'Computadores', 2 UNION ALL
+      SELECT 'CH-001', 'Cadeira Gamer', 800.00, 5, 'Móveis', 0) AS tmp
+LEFT JOIN categories c ON c.name = tmp.category
 WHERE NOT EXISTS (SELECT 1 FROM products LIMIT 1);
+EOF
+fi
+
+# Em um volume antigo estas tabelas começam vazias e são preenchidas a partir de products
+run_sql <<EOF
+-- O estoque dos produtos de exemplo começa todo no depósito MAIN
+INSERT INTO product_stock (product_id, location_id, quantity)
+SELECT p.id, l.id, p.quantity FROM products p JOIN locations l ON l.code = 'MAIN'
+WHERE NOT EXISTS (SELECT 1 FROM product_stock LIMIT 1);
+
+-- Primeira revisão dos produtos de exemplo
+INSERT INTO product_history (product_id, sku, name, quantity, reserved, price, currency, category_id, reorder_threshold, version, deleted_at, operation, changed_at)
+SELECT id, sku, name, quantity, reserved, price, currency, category_id, reorder_threshold, version, deleted_at, 'create', UTC_TIMESTAMP(6) FROM products
+WHERE NOT EXISTS (SELECT 1 FROM product_history LIMIT 1);
 EOF
\ No newline at end of file
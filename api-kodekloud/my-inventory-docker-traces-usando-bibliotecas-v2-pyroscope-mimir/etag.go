@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// productETag formata a versão do produto como um ETag forte (ex: "3")
+func productETag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// parseIfMatch interpreta o cabeçalho If-Match e devolve a versão esperada.
+// "*" aceita qualquer versão e é representado por 0. ETags fracos (W/"3") nunca
+// casam com If-Match, pois a RFC 9110 exige comparação forte.
+func parseIfMatch(header string) (int, error) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return 0, nil
+	}
+	if strings.HasPrefix(header, "W/") {
+		return 0, errors.New("weak ETags cannot be used with If-Match")
+	}
+	unquoted, err := strconv.Unquote(header)
+	if err != nil {
+		return 0, fmt.Errorf("invalid If-Match header %q", header)
+	}
+	version, err := strconv.Atoi(unquoted)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid If-Match header %q", header)
+	}
+	return version, nil
+}
+
+// requireIfMatch valida a pré-condição de PUT e DELETE. Em caso de falha já responde
+// 428 (cabeçalho ausente) ou 412 (ETag inválido), conta a métrica e devolve ok=false.
+func requireIfMatch(w http.ResponseWriter, r *http.Request, productID int) (int, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		preconditionFailuresTotal.With(prometheus.Labels{"method": r.Method, "reason": "missing"}).Inc()
+		logrus.WithContext(r.Context()).WithField("product_id", productID).Warn("Requisição sem If-Match")
+		sendError(w, r, http.StatusPreconditionRequired, errors.New("If-Match header is required; use the ETag returned by GET /product/{id}"))
+		return 0, false
+	}
+
+	version, err := parseIfMatch(header)
+	if err != nil {
+		preconditionFailuresTotal.With(prometheus.Labels{"method": r.Method, "reason": "mismatch"}).Inc()
+		logrus.WithContext(r.Context()).WithError(err).WithField("product_id", productID).Warn("If-Match inválido")
+		sendError(w, r, http.StatusPreconditionFailed, err)
+		return 0, false
+	}
+	return version, true
+}
+
+// sendVersionConflict responde 412 quando o If-Match não corresponde mais à versão do produto
+func sendVersionConflict(w http.ResponseWriter, r *http.Request, productID int) {
+	preconditionFailuresTotal.With(prometheus.Labels{"method": r.Method, "reason": "mismatch"}).Inc()
+	logrus.WithContext(r.Context()).WithField("product_id", productID).Warn("Versão do produto desatualizada (If-Match)")
+	sendError(w, r, http.StatusPreconditionFailed, withProblemType(problemVersionConflict, "Version conflict",
+		fmt.Errorf("product with ID %d has been modified; fetch it again and retry with the new ETag", productID)))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseIfMatch(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    int
+		wantErr string
+	}{
+		{header: `"3"`, want: 3},
+		{header: ` "12" `, want: 12},
+		{header: `*`, want: 0},
+		{header: `W/"3"`, wantErr: "weak ETags"},
+		{header: `3`, wantErr: "invalid If-Match"},
+		{header: `"abc"`, wantErr: "invalid If-Match"},
+		{header: `"0"`, wantErr: "invalid If-Match"},
+		{header: `"-1"`, wantErr: "invalid If-Match"},
+		{header: `"1", "2"`, wantErr: "invalid If-Match"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got, err := parseIfMatch(tt.header)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("erro = %v, esperado %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("parseIfMatch(%s) = %d, %v; esperado %d", tt.header, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestProductETagRoundTrip(t *testing.T) {
+	version, err := parseIfMatch(productETag(7))
+	if err != nil || version != 7 {
+		t.Fatalf("parseIfMatch(productETag(7)) = %d, %v", version, err)
+	}
+}
+
+func TestRequireIfMatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		wantOK     bool
+		wantStatus int
+		want       int
+	}{
+		{name: "ausente", header: "", wantStatus: http.StatusPreconditionRequired},
+		{name: "inválido", header: "3", wantStatus: http.StatusPreconditionFailed},
+		{name: "fraco", header: `W/"3"`, wantStatus: http.StatusPreconditionFailed},
+		{name: "versão", header: `"3"`, wantOK: true, want: 3},
+		{name: "qualquer versão", header: "*", wantOK: true, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/v1/product/1", nil)
+			if tt.header != "" {
+				r.Header.Set("If-Match", tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			version, ok := requireIfMatch(w, r, 1)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, esperado %v", ok, tt.wantOK)
+			}
+			if ok {
+				if version != tt.want {
+					t.Errorf("versão = %d, esperado %d", version, tt.want)
+				}
+				if w.Body.Len() != 0 {
+					t.Errorf("resposta escrita com If-Match válido: %s", w.Body)
+				}
+				return
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, esperado %d", w.Code, tt.wantStatus)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+				t.Errorf("Content-Type = %s", ct)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"example.com/my-inventory/inventorypb"
+)
+
+// grpcActorMetadata é o equivalente do header X-Actor nas chamadas gRPC
+const grpcActorMetadata = "x-actor"
+
+// grpcAddr lê GRPC_PORT (padrão 10001), a porta do InventoryService ao lado da API REST na 10000
+func grpcAddr() string {
+	port := os.Getenv("GRPC_PORT")
+	if port == "" {
+		port = "10001"
+	}
+	return ":" + port
+}
+
+// newGRPCServer monta o servidor gRPC: InventoryService, health e reflection. O trace vem do
+// otelgrpc (stats handler) e as métricas grpc_server_* do go-grpc-middleware.
+func newGRPCServer(app *App) (*grpc.Server, *health.Server) {
+	server := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(
+			grpcServerMetrics.UnaryServerInterceptor(),
+			grpcLoggingInterceptor,
+			grpcRequestContextInterceptor, // Autor e eventos de produto, como actorMiddleware e productEventsMiddleware
+		),
+	)
+	inventorypb.RegisterInventoryServiceServer(server, &inventoryServer{app: app})
+
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	reflection.Register(server)
+	grpcServerMetrics.InitializeMetrics(server)
+	return server, healthServer
+}
+
+// startGRPCHealthCheck marca o servidor como NOT_SERVING enquanto o banco não responder,
+// com a mesma verificação do GET /health
+func (app *App) startGRPCHealthCheck(healthServer *health.Server) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		err := app.DB.PingContext(ctx)
+		cancel()
+		status := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			logrus.WithError(err).Warn("Health check gRPC falhou (DB ping)")
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		healthServer.SetServingStatus("", status)
+		healthServer.SetServingStatus(inventorypb.InventoryService_ServiceDesc.ServiceName, status)
+		<-ticker.C
+	}
+}
+
+// --- Interceptors ---
+
+// grpcLoggingInterceptor registra cada RPC concluída, como o loggingMiddleware das requisições HTTP
+func grpcLoggingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	startTime := time.Now()
+	resp, err := handler(ctx, req)
+	logWithTrace(ctx).WithFields(logrus.Fields{
+		"component":   "grpc_interceptor",
+		"grpc_method": info.FullMethod,
+		"grpc_code":   status.Code(err).String(),
+		"duration_ms": time.Since(startTime).Milliseconds(),
+	}).Info("Requisição gRPC processada")
+	return resp, err
+}
+
+// grpcRequestContextInterceptor coloca no contexto o autor (metadata x-actor) e a lista de
+// eventos de produto, como os middlewares do Router fazem nas requisições HTTP
+func grpcRequestContextInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	actor := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(grpcActorMetadata); len(values) > 0 {
+			actor = strings.TrimSpace(values[0])
+		}
+	}
+	ctx = contextWithActor(ctx, actor)
+	ctx = contextWithPendingProductEvents(ctx)
+	return handler(ctx, req)
+}
+
+// --- InventoryService ---
+
+// inventoryServer implementa o InventoryService sobre a mesma camada de dados da API REST
+type inventoryServer struct {
+	inventorypb.UnimplementedInventoryServiceServer
+	app *App
+}
+
+// productToProto converte o produto para a mensagem do gRPC
+func productToProto(p product) *inventorypb.Product {
+	msg := &inventorypb.Product{
+		Id:               int32(p.ID),
+		Sku:              p.SKU,
+		Name:             p.Name,
+		Quantity:         int32(p.Quantity),
+		Reserved:         int32(p.Reserved),
+		Available:        int32(p.Available),
+		Price:            p.Price.String(),
+		Currency:         p.Currency,
+		ReorderThreshold: int32(p.ReorderThreshold),
+		Version:          int32(p.Version),
+	}
+	if p.CategoryID != nil {
+		msg.CategoryId = int32(*p.CategoryID)
+	}
+	return msg
+}
+
+// productFromProto monta o produto a partir dos campos de CreateProductRequest/UpdateProductRequest.
+// price vazio vale zero, como um price omitido no JSON.
+func productFromProto(sku, name string, quantity int32, price, currency string, categoryID, reorderThreshold int32) (product, error) {
+	p := product{
+		SKU:              sku,
+		Name:             name,
+		Quantity:         int(quantity),
+		Currency:         currency,
+		ReorderThreshold: int(reorderThreshold),
+	}
+	if price != "" {
+		parsed, err := parseMoney(price)
+		if err != nil {
+			return p, fmt.Errorf("invalid product data: %w", err)
+		}
+		p.Price = parsed
+	}
+	if categoryID > 0 {
+		id := int(categoryID)
+		p.CategoryID = &id
+	}
+	return p, p.validate()
+}
+
+// requireExpectedVersion é o requireIfMatch do gRPC: sem expected_version a escrita é recusada com
+// FAILED_PRECONDITION, como o 428 do REST
+func requireExpectedVersion(ctx context.Context, expectedVersion int32, productID int) error {
+	if expectedVersion > 0 {
+		return nil
+	}
+	preconditionFailuresTotal.With(prometheus.Labels{"method": "grpc", "reason": "missing"}).Inc()
+	logWithTrace(ctx).WithField("product_id", productID).Warn("Requisição gRPC sem expected_version")
+	return status.Error(codes.FailedPrecondition, "expected_version is required; use the version returned by GetProduct")
+}
+
+// productWriteError converte os erros de escrita de produto nos códigos gRPC equivalentes aos status do REST
+func productWriteError(ctx context.Context, err error, p product, operation string) error {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return status.Errorf(codes.NotFound, "product with ID %d not found", p.ID)
+	case errors.Is(err, errVersionConflict):
+		preconditionFailuresTotal.With(prometheus.Labels{"method": "grpc", "reason": "mismatch"}).Inc()
+		return status.Errorf(codes.FailedPrecondition, "product with ID %d has been modified; fetch it again and retry with the new version", p.ID)
+	case errors.Is(err, errInsufficientStock):
+		return status.Error(codes.FailedPrecondition, locatedStockError(p).Error())
+	case isMySQLError(err, mysqlErrNoReferencedRow):
+		return status.Error(codes.InvalidArgument, categoryNotFoundError(p).Error())
+	case isMySQLError(err, mysqlErrDuplicateEntry):
+		sqlDuplicateKeyConflictsTotal.With(prometheus.Labels{"table": "products"}).Inc()
+		return status.Errorf(codes.AlreadyExists, "a product with SKU %q already exists (including products in trash)", p.SKU)
+	}
+	logWithTrace(ctx).WithError(err).WithField("product_id", p.ID).Errorf("Erro ao executar %s via gRPC", operation)
+	sqlErrorsTotal.Inc()
+	return status.Errorf(codes.Internal, "failed to %s product", operation)
+}
+
+func (s *inventoryServer) GetProduct(ctx context.Context, req *inventorypb.GetProductRequest) (*inventorypb.Product, error) {
+	p := product{ID: int(req.GetId())}
+	err := p.getProduct(ctx, s.app.DB)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Errorf(codes.NotFound, "product with ID %d not found", p.ID)
+	}
+	if err != nil {
+		sqlErrorsTotal.Inc()
+		return nil, status.Error(codes.Internal, "failed to retrieve product")
+	}
+	return productToProto(p), nil
+}
+
+// ListProducts reaproveita a validação da query string do GET /products
+func (s *inventoryServer) ListProducts(ctx context.Context, req *inventorypb.ListProductsRequest) (*inventorypb.ListProductsResponse, error) {
+	values := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	setInt := func(key string, value int32) {
+		if value != 0 {
+			values.Set(key, strconv.Itoa(int(value)))
+		}
+	}
+	set("name", req.GetName())
+	set("category", req.GetCategory())
+	set("currency", req.GetCurrency())
+	set("min_price", req.GetMinPrice())
+	set("max_price", req.GetMaxPrice())
+	setInt("min_quantity", req.GetMinQuantity())
+	set("sort", req.GetSort())
+	setInt("limit", req.GetLimit())
+	setInt("offset", req.GetOffset())
+	set("cursor", req.GetCursor())
+
+	q, err := parseProductQuery(values)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	products, hasMore, err := getProductsFromDB(ctx, s.app.DB, q)
+	total := 0
+	if err == nil {
+		total, err = countFilteredProducts(ctx, s.app.DB, q)
+	}
+	if err != nil {
+		logWithTrace(ctx).WithError(err).Error("Erro ao obter produtos do banco de dados via gRPC")
+		sqlErrorsTotal.Inc()
+		return nil, status.Error(codes.Internal, "failed to retrieve products")
+	}
+
+	resp := &inventorypb.ListProductsResponse{
+		Products: make([]*inventorypb.Product, 0, len(products)),
+		Total:    int32(total),
+		Limit:    int32(q.Limit),
+		Offset:   int32(q.Offset),
+	}
+	for _, p := range products {
+		resp.Products = append(resp.Products, productToProto(p))
+	}
+	if hasMore && len(products) > 0 {
+		resp.NextCursor = q.nextCursor(products[len(products)-1])
+	}
+	return resp, nil
+}
+
+func (s *inventoryServer) CreateProduct(ctx context.Context, req *inventorypb.CreateProductRequest) (*inventorypb.Product, error) {
+	p, err := productFromProto(req.GetSku(), req.GetName(), req.GetQuantity(), req.GetPrice(), req.GetCurrency(),
+		req.GetCategoryId(), req.GetReorderThreshold())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	err = s.app.withTx(ctx, func(tx *sql.Tx) error {
+		return p.createProduct(ctx, tx)
+	})
+	if err != nil {
+		return nil, productWriteError(ctx, err, p, "create")
+	}
+	logWithTrace(ctx).WithField("product_id", p.ID).Info("Produto criado via gRPC")
+	return productToProto(p), nil
+}
+
+// UpdateProduct substitui o produto, como o PUT /product/{id}; expected_version faz o papel do If-Match
+func (s *inventoryServer) UpdateProduct(ctx context.Context, req *inventorypb.UpdateProductRequest) (*inventorypb.Product, error) {
+	p, err := productFromProto(req.GetSku(), req.GetName(), req.GetQuantity(), req.GetPrice(), req.GetCurrency(),
+		req.GetCategoryId(), req.GetReorderThreshold())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	p.ID = int(req.GetId())
+	if err := requireExpectedVersion(ctx, req.GetExpectedVersion(), p.ID); err != nil {
+		return nil, err
+	}
+	p.Version = int(req.GetExpectedVersion())
+	err = s.app.withTx(ctx, func(tx *sql.Tx) error {
+		return p.updateProduct(ctx, tx)
+	})
+	if err != nil {
+		return nil, productWriteError(ctx, err, p, "update")
+	}
+	// Relê o produto para devolver os campos calculados pelo servidor, como o PUT
+	if err := p.getProduct(ctx, s.app.DB); err != nil {
+		logWithTrace(ctx).WithError(err).WithField("product_id", p.ID).Warn("Erro ao reler produto após atualização via gRPC")
+	}
+	logWithTrace(ctx).WithField("product_id", p.ID).Info("Produto atualizado via gRPC")
+	return productToProto(p), nil
+}
+
+// DeleteProduct move o produto para a lixeira, como o DELETE /product/{id}
+func (s *inventoryServer) DeleteProduct(ctx context.Context, req *inventorypb.DeleteProductRequest) (*inventorypb.DeleteProductResponse, error) {
+	if err := requireExpectedVersion(ctx, req.GetExpectedVersion(), int(req.GetId())); err != nil {
+		return nil, err
+	}
+	p := product{ID: int(req.GetId()), Version: int(req.GetExpectedVersion())}
+	err := s.app.withTx(ctx, func(tx *sql.Tx) error {
+		return p.deleteProduct(ctx, tx)
+	})
+	if err != nil {
+		return nil, productWriteError(ctx, err, p, "delete")
+	}
+	logWithTrace(ctx).WithField("product_id", p.ID).Info("Produto movido para a lixeira via gRPC")
+	return &inventorypb.DeleteProductResponse{}, nil
+}
+
+// CountProducts conta os produtos ativos, no total e por categoria ("none" para sem categoria)
+func (s *inventoryServer) CountProducts(ctx context.Context, req *inventorypb.CountProductsRequest) (*inventorypb.CountProductsResponse, error) {
+	counts, err := countProductsByCategory(ctx, s.app.DB)
+	if err != nil {
+		sqlErrorsTotal.Inc()
+		return nil, status.Error(codes.Internal, "failed to count products")
+	}
+	resp := &inventorypb.CountProductsResponse{ByCategory: make(map[string]int32, len(counts))}
+	for category, count := range counts {
+		resp.ByCategory[category] = int32(count)
+		resp.Total += int32(count)
+	}
+	return resp, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"example.com/my-inventory/inventorypb"
+)
+
+// Sem expected_version, UpdateProduct e DeleteProduct são recusados antes de tocar no banco, como o 428 do REST
+func TestGRPCWriteWithoutExpectedVersion(t *testing.T) {
+	server := &inventoryServer{app: &App{}}
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{name: "UpdateProduct", call: func() error {
+			_, err := server.UpdateProduct(ctx, &inventorypb.UpdateProductRequest{Id: 1, Sku: "NB-001", Name: "Notebook", Price: "4500.00"})
+			return err
+		}},
+		{name: "DeleteProduct", call: func() error {
+			_, err := server.DeleteProduct(ctx, &inventorypb.DeleteProductRequest{Id: 1})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := status.Code(tt.call()); code != codes.FailedPrecondition {
+				t.Errorf("código = %s, esperado FailedPrecondition", code)
+			}
+		})
+	}
+}
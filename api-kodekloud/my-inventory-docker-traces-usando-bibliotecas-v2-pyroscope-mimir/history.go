@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Operações registradas no histórico do produto (product_history.operation)
+const (
+	historyCreate  = "create"
+	historyUpdate  = "update"
+	historyDelete  = "delete"
+	historyRestore = "restore"
+	historyStock   = "stock" // movimentação de estoque, transferência ou reserva
+)
+
+// productRevision é o estado completo do produto logo após uma escrita.
+// O produto fica válido de ChangedAt até o ChangedAt da revisão seguinte.
+type productRevision struct {
+	product
+	Operation string    `json:"operation" xml:"operation"`
+	ChangedAt time.Time `json:"changed_at" xml:"changed_at"`
+}
+
+// revisionPage é a resposta do GET /product/{id}/history
+type revisionPage struct {
+	Revisions []productRevision `json:"revisions" xml:"revisions>item"`
+	pageLimits
+}
+
+func (p revisionPage) pageItems() interface{} {
+	return p.Revisions
+}
+
+// historyColumns são as colunas do produto copiadas para product_history, na mesma ordem de revisionScanFields
+const historyColumns = "sku, name, quantity, reserved, price, currency, category_id, reorder_threshold, version, deleted_at"
+
+func (rev *productRevision) revisionScanFields() []interface{} {
+	return []interface{}{&rev.ID, &rev.SKU, &rev.Name, &rev.Quantity, &rev.Reserved, &rev.Price, &rev.Currency, &rev.CategoryID,
+		&rev.ReorderThreshold, &rev.Version, &rev.DeletedAt, &rev.Operation, &rev.ChangedAt}
+}
+
+// recordProductHistory copia o estado atual do produto para product_history.
+// Deve usar o mesmo db (transação) da escrita que o alterou, para que histórico e
+// produto nunca divirjam: se a escrita for desfeita, a revisão também é.
+func recordProductHistory(ctx context.Context, db dbExecutor, id int, operation string) error {
+	query := "INSERT INTO product_history(product_id, " + historyColumns + ", operation, changed_at) " +
+		"SELECT id, " + historyColumns + ", ?, ? FROM products WHERE id = ?"
+	// Microssegundos: várias escritas no mesmo segundo continuam ordenadas
+	changedAt := time.Now().UTC().Truncate(time.Microsecond)
+	if _, err := db.ExecContext(ctx, query, operation, changedAt, id); err != nil {
+		logrus.WithContext(ctx).WithError(err).WithFields(logrus.Fields{
+			"component":  "database",
+			"operation":  "record_product_history",
+			"product_id": id,
+		}).Error("Erro ao gravar histórico do produto")
+		return fmt.Errorf("erro ao gravar histórico do produto %d: %w", id, err)
+	}
+	return nil
+}
+
+// getProductHistory lista as revisões do produto, da mais recente para a mais antiga
+func getProductHistory(ctx context.Context, db dbExecutor, id, limit, offset int) ([]productRevision, error) {
+	query := "SELECT product_id, " + historyColumns + ", operation, changed_at FROM product_history " +
+		"WHERE product_id = ? ORDER BY changed_at DESC, id DESC LIMIT ? OFFSET ?"
+	rows, err := db.QueryContext(ctx, query, id, limit, offset)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("product_id", id).Error("Erro ao executar QueryContext em getProductHistory")
+		return nil, fmt.Errorf("erro ao buscar histórico do produto %d: %w", id, err)
+	}
+	defer rows.Close()
+
+	revisions := []productRevision{}
+	for rows.Next() {
+		var rev productRevision
+		if err := rows.Scan(rev.revisionScanFields()...); err != nil {
+			return nil, fmt.Errorf("erro ao ler revisão do produto: %w", err)
+		}
+		rev.Available = rev.Quantity - rev.Reserved
+		rev.normalizePrice()
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao iterar sobre histórico do produto: %w", err)
+	}
+	return revisions, nil
+}
+
+// getProductAsOf busca o estado do produto no instante asOf (a última revisão até esse momento).
+// Devolve sql.ErrNoRows se o produto ainda não existia ou estava na lixeira.
+func getProductAsOf(ctx context.Context, db dbExecutor, id int, asOf time.Time) (productRevision, error) {
+	var rev productRevision
+	query := "SELECT product_id, " + historyColumns + ", operation, changed_at FROM product_history " +
+		"WHERE product_id = ? AND changed_at <= ? ORDER BY changed_at DESC, id DESC LIMIT 1"
+	err := db.QueryRowContext(ctx, query, id, asOf.UTC()).Scan(rev.revisionScanFields()...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return rev, sql.ErrNoRows
+	}
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("product_id", id).Error("Erro ao buscar revisão do produto")
+		return rev, fmt.Errorf("erro ao buscar produto %d em %s: %w", id, asOf, err)
+	}
+	if rev.DeletedAt != nil {
+		return rev, sql.ErrNoRows
+	}
+	rev.Available = rev.Quantity - rev.Reserved
+	rev.normalizePrice()
+	return rev, nil
+}
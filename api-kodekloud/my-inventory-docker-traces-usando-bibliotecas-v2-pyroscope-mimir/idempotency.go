@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// idempotencyKeyHeader permite ao cliente repetir um POST /product sem criar duplicatas
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength acompanha a coluna idempotency_keys.idem_key
+const maxIdempotencyKeyLength = 255
+
+// errIdempotencyKeyExists indica que a chave já foi usada (e a escrita já foi feita) dentro do TTL
+var errIdempotencyKeyExists = errors.New("chave de idempotência já utilizada")
+
+// idempotentResponse é a resposta gravada para uma chave, devolvida de novo nas repetições
+type idempotentResponse struct {
+	RequestHash string
+	StatusCode  int
+	Body        []byte
+}
+
+// idempotencyKeyTTL lê IDEMPOTENCY_KEY_TTL (duração do Go, ex: 24h; padrão 24 horas)
+func idempotencyKeyTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_KEY_TTL"))
+	if err != nil || ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return ttl
+}
+
+// idempotencyRequestHash identifica o payload da requisição. Usa o produto já decodificado,
+// assim espaços e ordem das chaves no JSON não tornam diferente uma repetição legítima.
+func idempotencyRequestHash(method, path string, payload interface{}) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(method+" "+path+"\n"), data...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// claimIdempotencyKey reserva a chave na transação tx. Se outra requisição estiver usando a
+// mesma chave, o INSERT espera a transação dela terminar: se ela gravar, devolve
+// errIdempotencyKeyExists; se for desfeita, a chave fica com esta requisição.
+// Uma chave vencida (ainda não removida pelo purge) é substituída.
+func claimIdempotencyKey(ctx context.Context, tx *sql.Tx, key, requestHash string, ttl time.Duration) error {
+	now := time.Now().UTC()
+	query := "INSERT INTO idempotency_keys(idem_key, request_hash, created_at, expires_at) VALUES(?,?,?,?)"
+	_, err := tx.ExecContext(ctx, query, key, requestHash, now, now.Add(ttl))
+	if isMySQLError(err, mysqlErrDuplicateEntry) {
+		// O DELETE só acontece depois do INSERT falhar: assim ele trava a linha existente,
+		// e não o intervalo do índice, o que levaria chaves novas concorrentes a deadlock
+		result, delErr := tx.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE idem_key = ? AND expires_at <= ?", key, now)
+		if delErr != nil {
+			return fmt.Errorf("erro ao descartar chave de idempotência vencida: %w", delErr)
+		}
+		if deleted, _ := result.RowsAffected(); deleted == 0 {
+			return errIdempotencyKeyExists
+		}
+		_, err = tx.ExecContext(ctx, query, key, requestHash, now, now.Add(ttl))
+	}
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("component", "database").Error("Erro ao gravar chave de idempotência")
+		return fmt.Errorf("erro ao gravar chave de idempotência: %w", err)
+	}
+	return nil
+}
+
+// saveIdempotentResponse grava a resposta da chave, na mesma transação da escrita:
+// a chave só fica registrada se o produto também ficar
+func saveIdempotentResponse(ctx context.Context, tx *sql.Tx, key string, statusCode int, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("erro ao serializar resposta idempotente: %w", err)
+	}
+	query := "UPDATE idempotency_keys SET status_code = ?, response_body = ? WHERE idem_key = ?"
+	if _, err := tx.ExecContext(ctx, query, statusCode, string(data), key); err != nil {
+		return fmt.Errorf("erro ao gravar resposta idempotente: %w", err)
+	}
+	return nil
+}
+
+// getIdempotentResponse busca a resposta gravada para a chave (sql.ErrNoRows se não houver ou se tiver vencido)
+func getIdempotentResponse(ctx context.Context, db dbExecutor, key string) (idempotentResponse, error) {
+	var res idempotentResponse
+	query := "SELECT request_hash, status_code, response_body FROM idempotency_keys WHERE idem_key = ? AND expires_at > ? AND status_code IS NOT NULL"
+	err := db.QueryRowContext(ctx, query, key, time.Now().UTC()).Scan(&res.RequestHash, &res.StatusCode, &res.Body)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logrus.WithContext(ctx).WithError(err).WithField("component", "database").Error("Erro ao buscar chave de idempotência")
+		return res, fmt.Errorf("erro ao buscar chave de idempotência: %w", err)
+	}
+	return res, err
+}
+
+// purgeExpiredIdempotencyKeys remove as chaves que passaram do TTL
+func purgeExpiredIdempotencyKeys(ctx context.Context, db dbExecutor) (int64, error) {
+	result, err := db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= ?", time.Now().UTC())
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao executar ExecContext em purgeExpiredIdempotencyKeys")
+		return 0, fmt.Errorf("erro ao remover chaves de idempotência vencidas: %w", err)
+	}
+	return result.RowsAffected()
+}
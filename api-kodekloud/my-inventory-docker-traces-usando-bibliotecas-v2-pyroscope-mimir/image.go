@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // registra o decoder de GIF no image.Decode
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Limites das imagens de produto
+const (
+	maxImageSize        = 5 << 20    // bytes do arquivo enviado
+	maxImagePixels      = 25_000_000 // largura x altura, conferida antes de decodificar a imagem toda
+	maxImagesPerProduct = 10
+	thumbnailMaxSide    = 256 // maior lado da miniatura, em pixels
+)
+
+// imageFormats são os tipos aceitos no upload, pelo conteúdo do arquivo (não pelo Content-Type
+// declarado), com a extensão das chaves
+var imageFormats = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// errImageLimit indica que o produto já tem maxImagesPerProduct imagens
+var errImageLimit = fmt.Errorf("product already has %d images", maxImagesPerProduct)
+
+// imageInputError é um arquivo recusado no upload (tipo, tamanho ou conteúdo inválido)
+type imageInputError struct {
+	status int
+	err    error
+}
+
+func (e *imageInputError) Error() string { return e.err.Error() }
+func (e *imageInputError) Unwrap() error { return e.err }
+
+// productImage é uma imagem de um produto. O arquivo original e a miniatura ficam no blobStore.
+type productImage struct {
+	ID                   int       `json:"id" xml:"id"`
+	ProductID            int       `json:"product_id" xml:"product_id"`
+	ContentType          string    `json:"content_type" xml:"content_type"`
+	SizeBytes            int       `json:"size_bytes" xml:"size_bytes"`
+	Width                int       `json:"width" xml:"width"`
+	Height               int       `json:"height" xml:"height"`
+	URL                  string    `json:"url" xml:"url"`
+	ThumbnailURL         string    `json:"thumbnail_url" xml:"thumbnail_url"`
+	ThumbnailContentType string    `json:"thumbnail_content_type" xml:"thumbnail_content_type"`
+	CreatedAt            time.Time `json:"created_at" xml:"created_at"`
+	originalKey          string
+	thumbnailKey         string
+}
+
+const productImageColumns = "id, product_id, content_type, size_bytes, width, height, thumbnail_content_type, original_key, thumbnail_key, created_at"
+
+// imageColumnsWithAlias são as mesmas colunas nas consultas com JOIN em products (alias i)
+var imageColumnsWithAlias = "i." + strings.ReplaceAll(productImageColumns, ", ", ", i.")
+
+func (img *productImage) scanFields() []interface{} {
+	return []interface{}{&img.ID, &img.ProductID, &img.ContentType, &img.SizeBytes, &img.Width, &img.Height,
+		&img.ThumbnailContentType, &img.originalKey, &img.thumbnailKey, &img.CreatedAt}
+}
+
+// setURLs preenche os links da imagem no formato de rota da versão da requisição
+func (img *productImage) setURLs(version string) {
+	path := "/product/" + strconv.Itoa(img.ProductID) + "/images/" + strconv.Itoa(img.ID)
+	switch version {
+	case apiV1:
+		path = "/" + apiV1 + path
+	case apiV2:
+		path = "/" + apiV2 + v2Path(path)
+	}
+	img.URL = path
+	img.ThumbnailURL = path + "/thumbnail"
+}
+
+// processedImage é o resultado do processamento de um upload
+type processedImage struct {
+	contentType          string
+	width, height        int
+	thumbnail            []byte
+	thumbnailContentType string
+}
+
+// processImage confere o conteúdo do arquivo (sniffing), as dimensões e gera a miniatura,
+// em um span próprio e com a duração em product_image_processing_duration_seconds
+func processImage(ctx context.Context, data []byte) (*processedImage, error) {
+	ctx, span := otel.Tracer("inventory-app").Start(ctx, "product_image.process",
+		trace.WithAttributes(attribute.Int("image.size_bytes", len(data))))
+	defer span.End()
+	start := time.Now()
+	result := "ok"
+	defer func() {
+		imageProcessingDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	}()
+
+	processed, err := decodeAndThumbnail(data)
+	if err != nil {
+		result = "rejected"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "imagem recusada")
+		return nil, err
+	}
+	span.SetAttributes(
+		attribute.String("image.content_type", processed.contentType),
+		attribute.Int("image.width", processed.width),
+		attribute.Int("image.height", processed.height),
+		attribute.Int("image.thumbnail_bytes", len(processed.thumbnail)),
+	)
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"content_type": processed.contentType,
+		"width":        processed.width,
+		"height":       processed.height,
+		"duration_ms":  time.Since(start).Milliseconds(),
+	}).Debug("Imagem processada")
+	return processed, nil
+}
+
+func decodeAndThumbnail(data []byte) (*processedImage, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := imageFormats[contentType]; !ok {
+		return nil, &imageInputError{http.StatusUnsupportedMediaType,
+			fmt.Errorf("file content is %s; images must be JPEG, PNG or GIF", contentType)}
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, &imageInputError{http.StatusBadRequest, fmt.Errorf("invalid image: %w", err)}
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxImagePixels {
+		return nil, &imageInputError{http.StatusBadRequest,
+			fmt.Errorf("image has %dx%d pixels; the maximum is %d pixels", config.Width, config.Height, maxImagePixels)}
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, &imageInputError{http.StatusBadRequest, fmt.Errorf("invalid image: %w", err)}
+	}
+
+	thumb := thumbnail(src, thumbnailMaxSide)
+	var buf bytes.Buffer
+	thumbnailType := "image/png" // PNG e GIF mantêm a transparência
+	if contentType == "image/jpeg" {
+		thumbnailType = "image/jpeg"
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("erro ao codificar a miniatura: %w", err)
+	}
+	return &processedImage{
+		contentType:          contentType,
+		width:                config.Width,
+		height:               config.Height,
+		thumbnail:            buf.Bytes(),
+		thumbnailContentType: thumbnailType,
+	}, nil
+}
+
+// thumbnail reduz src para caber em maxSide x maxSide, mantendo a proporção. Cada pixel da
+// miniatura é a média da área correspondente da imagem (box filter); imagens menores não são ampliadas.
+func thumbnail(src image.Image, maxSide int) *image.RGBA {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	dw, dh := sw, sh
+	if sw > maxSide || sh > maxSide {
+		if sw >= sh {
+			dw, dh = maxSide, max(1, sh*maxSide/sw)
+		} else {
+			dw, dh = max(1, sw*maxSide/sh), maxSide
+		}
+	}
+
+	// draw.Draw tem caminhos rápidos para os tipos do jpeg, png e gif
+	rgba := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	if dw == sw && dh == sh {
+		return rgba
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := dy*sh/dh, max((dy+1)*sh/dh, dy*sh/dh+1)
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := dx*sw/dw, max((dx+1)*sw/dw, dx*sw/dw+1)
+			var r, g, b, a, n int
+			for y := y0; y < y1; y++ {
+				row := rgba.Pix[y*rgba.Stride+x0*4 : y*rgba.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					r += int(row[i])
+					g += int(row[i+1])
+					b += int(row[i+2])
+					a += int(row[i+3])
+					n++
+				}
+			}
+			i := dst.PixOffset(dx, dy)
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// readImageUpload lê o campo image de um multipart/form-data, limitado a maxImageSize
+func readImageUpload(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return nil, &imageInputError{http.StatusUnsupportedMediaType, errors.New("image upload must be multipart/form-data with an image field")}
+	}
+	// Folga para os cabeçalhos das partes; o arquivo em si é limitado abaixo
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageSize+64<<10)
+	tooLarge := &imageInputError{http.StatusRequestEntityTooLarge, fmt.Errorf("image must have at most %d bytes", maxImageSize)}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, &imageInputError{http.StatusBadRequest, fmt.Errorf("invalid multipart body: %v", err)}
+	}
+	for {
+		part, err := reader.NextPart()
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil, &imageInputError{http.StatusBadRequest, errors.New("image field is required")}
+		case errors.As(err, &maxBytesErr):
+			return nil, tooLarge
+		case err != nil:
+			return nil, &imageInputError{http.StatusBadRequest, fmt.Errorf("invalid multipart body: %v", err)}
+		}
+		if part.FormName() != "image" {
+			part.Close()
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(part, maxImageSize+1))
+		part.Close()
+		if errors.As(err, &maxBytesErr) || len(data) > maxImageSize {
+			return nil, tooLarge
+		}
+		if err != nil {
+			return nil, &imageInputError{http.StatusBadRequest, fmt.Errorf("invalid multipart body: %v", err)}
+		}
+		if len(data) == 0 {
+			return nil, &imageInputError{http.StatusBadRequest, errors.New("image file is empty")}
+		}
+		return data, nil
+	}
+}
+
+// newImageKeys gera as chaves do original e da miniatura: products/{id}/{aleatório}.{ext}
+func newImageKeys(productID int, contentType, thumbnailType string) (string, string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	base := "products/" + strconv.Itoa(productID) + "/" + hex.EncodeToString(random)
+	return base + "." + imageFormats[contentType], base + "_thumb." + imageFormats[thumbnailType], nil
+}
+
+// --- Banco de dados ---
+
+// createImage grava a imagem na transação tx. Trava o produto para contar as imagens sem corrida:
+// devolve sql.ErrNoRows se o produto não existir e errImageLimit se ele já tiver o máximo.
+func (img *productImage) createImage(ctx context.Context, tx dbExecutor) error {
+	p := product{ID: img.ProductID}
+	if err := p.getProductForUpdate(ctx, tx); err != nil {
+		return err
+	}
+	var count int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM product_images WHERE product_id = ?", img.ProductID).Scan(&count); err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("product_id", img.ProductID).Error("Erro ao contar imagens do produto")
+		return fmt.Errorf("erro ao contar imagens do produto %d: %w", img.ProductID, err)
+	}
+	if count >= maxImagesPerProduct {
+		return errImageLimit
+	}
+
+	img.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	query := `INSERT INTO product_images(product_id, content_type, size_bytes, width, height, thumbnail_content_type, original_key, thumbnail_key, created_at)
+		VALUES(?,?,?,?,?,?,?,?,?)`
+	result, err := tx.ExecContext(ctx, query, img.ProductID, img.ContentType, img.SizeBytes, img.Width, img.Height,
+		img.ThumbnailContentType, img.originalKey, img.thumbnailKey, img.CreatedAt)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("product_id", img.ProductID).Error("Erro ao gravar imagem do produto")
+		return fmt.Errorf("erro ao gravar imagem do produto %d: %w", img.ProductID, err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("erro ao obter ID da imagem: %w", err)
+	}
+	img.ID = int(id)
+	return nil
+}
+
+// getImage busca uma imagem de um produto ativo; sql.ErrNoRows se não existir
+func (img *productImage) getImage(ctx context.Context, db dbExecutor) error {
+	query := "SELECT " + imageColumnsWithAlias + " FROM product_images i " +
+		"JOIN products p ON p.id = i.product_id WHERE i.id = ? AND i.product_id = ? AND p.deleted_at IS NULL"
+	err := db.QueryRowContext(ctx, query, img.ID, img.ProductID).Scan(img.scanFields()...)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logrus.WithContext(ctx).WithError(err).WithField("image_id", img.ID).Error("Erro ao buscar imagem do produto")
+		return fmt.Errorf("erro ao buscar imagem %d: %w", img.ID, err)
+	}
+	return err
+}
+
+// getProductImages lista as imagens de um produto, da mais antiga para a mais recente
+func getProductImages(ctx context.Context, db dbExecutor, productID int) ([]productImage, error) {
+	query := "SELECT " + productImageColumns + " FROM product_images WHERE product_id = ? ORDER BY id"
+	rows, err := db.QueryContext(ctx, query, productID)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("product_id", productID).Error("Erro ao executar QueryContext em getProductImages")
+		return nil, fmt.Errorf("erro ao listar imagens do produto %d: %w", productID, err)
+	}
+	defer rows.Close()
+
+	images := []productImage{}
+	for rows.Next() {
+		var img productImage
+		if err := rows.Scan(img.scanFields()...); err != nil {
+			return nil, fmt.Errorf("erro ao ler imagem do produto %d: %w", productID, err)
+		}
+		images = append(images, img)
+	}
+	return images, rows.Err()
+}
+
+// deleteImage remove a linha da imagem; sql.ErrNoRows se ela não existir. Os blobs são removidos pelo chamador.
+func (img *productImage) deleteImage(ctx context.Context, tx dbExecutor) error {
+	if err := img.getImage(ctx, tx); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM product_images WHERE id = ?", img.ID); err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("image_id", img.ID).Error("Erro ao remover imagem do produto")
+		return fmt.Errorf("erro ao remover imagem %d: %w", img.ID, err)
+	}
+	return nil
+}
+
+// deleteImageBlobs remove o original e a miniatura. Falhas só geram log: o arquivo fica órfão,
+// mas a imagem já saiu da API.
+func deleteImageBlobs(ctx context.Context, store blobStore, img productImage) {
+	for _, key := range []string{img.originalKey, img.thumbnailKey} {
+		if err := store.Delete(ctx, key); err != nil {
+			logrus.WithContext(ctx).WithError(err).WithFields(logrus.Fields{
+				"image_id": img.ID,
+				"key":      key,
+				"backend":  store.Backend(),
+			}).Warn("Falha ao remover arquivo de imagem")
+		}
+	}
+}
+
+// purgeDeletedProductImages remove as imagens (linhas e arquivos) dos produtos que o purge da
+// lixeira vai apagar. Roda antes do purgeDeletedProducts, com a mesma retenção.
+func purgeDeletedProductImages(ctx context.Context, db dbExecutor, store blobStore, retention time.Duration) (int, error) {
+	query := "SELECT " + imageColumnsWithAlias + ` FROM product_images i
+		JOIN products p ON p.id = i.product_id
+		WHERE p.deleted_at IS NOT NULL AND p.deleted_at < NOW() - INTERVAL ? SECOND`
+	rows, err := db.QueryContext(ctx, query, int64(retention.Seconds()))
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao executar QueryContext em purgeDeletedProductImages")
+		return 0, fmt.Errorf("erro ao listar imagens da lixeira: %w", err)
+	}
+	var images []productImage
+	for rows.Next() {
+		var img productImage
+		if err := rows.Scan(img.scanFields()...); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("erro ao ler imagem da lixeira: %w", err)
+		}
+		images = append(images, img)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("erro ao listar imagens da lixeira: %w", err)
+	}
+
+	for _, img := range images {
+		deleteImageBlobs(ctx, store, img)
+		if _, err := db.ExecContext(ctx, "DELETE FROM product_images WHERE id = ?", img.ID); err != nil {
+			return 0, fmt.Errorf("erro ao remover imagem %d da lixeira: %w", img.ID, err)
+		}
+	}
+	return len(images), nil
+}
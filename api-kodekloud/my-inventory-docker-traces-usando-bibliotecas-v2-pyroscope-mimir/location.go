@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultLocationCode é o depósito padrão (criado pelo setup.sh). Ele absorve as mudanças de
+// quantity feitas por POST/PUT/PATCH de produto, que não informam depósito.
+const defaultLocationCode = "MAIN"
+
+// locationCodePattern: código curto do depósito em maiúsculas (ex: MAIN, SP-01)
+var locationCodePattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9_-]{0,19}$`)
+
+// errLocationNotFound indica um código de depósito inexistente
+var errLocationNotFound = errors.New("depósito não encontrado")
+
+// location é um depósito. O estoque de cada produto por depósito fica em product_stock;
+// products.quantity é sempre a soma dos depósitos.
+type location struct {
+	ID   int    `json:"id" xml:"id"`
+	Code string `json:"code" xml:"code"`
+	Name string `json:"name" xml:"name"`
+}
+
+// locationStock é o saldo de um produto em um depósito
+type locationStock struct {
+	Location string `json:"location" xml:"location"`
+	Quantity int    `json:"quantity" xml:"quantity"`
+}
+
+// productStock é a resposta do GET /product/{id}/stock: o total e o saldo por depósito
+type productStock struct {
+	ProductID int             `json:"product_id" xml:"product_id"`
+	Quantity  int             `json:"quantity" xml:"quantity"`
+	Locations []locationStock `json:"locations" xml:"locations>item"`
+}
+
+// locationTransfer move unidades de um produto entre dois depósitos (o total não muda)
+type locationTransfer struct {
+	ProductID int    `json:"product_id" xml:"product_id"`
+	From      string `json:"from" xml:"from"`
+	To        string `json:"to" xml:"to"`
+	Quantity  int    `json:"quantity" xml:"quantity"`
+	Reason    string `json:"reason" xml:"reason"`
+}
+
+// validate aplica as regras de criação do depósito
+func (l location) validate() error {
+	if !locationCodePattern.MatchString(l.Code) {
+		return errors.New("invalid location data: code is required and must have up to 20 uppercase letters, digits, '-' or '_'")
+	}
+	if l.Name == "" || len(l.Name) > 100 {
+		return errors.New("invalid location data: name is required and must have at most 100 characters")
+	}
+	return nil
+}
+
+// validate aplica as regras da transferência
+func (t locationTransfer) validate() error {
+	if t.Quantity <= 0 {
+		return errors.New("invalid transfer: quantity must be positive")
+	}
+	if t.From == "" || t.To == "" || t.From == t.To {
+		return errors.New("invalid transfer: from and to are required and must be different locations")
+	}
+	if !reasonPattern.MatchString(t.Reason) {
+		return errors.New("invalid transfer: reason is required and must be a snake_case code with up to 50 characters")
+	}
+	return nil
+}
+
+// getLocationsFromDB busca todos os depósitos
+func getLocationsFromDB(ctx context.Context, db dbExecutor) ([]location, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, code, name FROM locations ORDER BY code")
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao executar QueryContext em getLocationsFromDB")
+		return nil, fmt.Errorf("erro ao buscar depósitos: %w", err)
+	}
+	defer rows.Close()
+
+	locations := []location{}
+	for rows.Next() {
+		var l location
+		if err := rows.Scan(&l.ID, &l.Code, &l.Name); err != nil {
+			return nil, fmt.Errorf("erro ao ler dados do depósito: %w", err)
+		}
+		locations = append(locations, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao iterar sobre depósitos: %w", err)
+	}
+	return locations, nil
+}
+
+// createLocation cria um depósito. Código duplicado devolve o erro 1062 do MySQL.
+func (l *location) createLocation(ctx context.Context, db dbExecutor) error {
+	result, err := db.ExecContext(ctx, "INSERT INTO locations(code, name) VALUES(?,?)", l.Code, l.Name)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("location", l.Code).Warn("Erro ao executar ExecContext em createLocation")
+		return fmt.Errorf("erro ao criar depósito: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("erro ao obter ID do depósito: %w", err)
+	}
+	l.ID = int(id)
+	return nil
+}
+
+// locationIDByCode resolve o código do depósito. Devolve errLocationNotFound se não existir.
+func locationIDByCode(ctx context.Context, db dbExecutor, code string) (int, error) {
+	var id int
+	err := db.QueryRowContext(ctx, "SELECT id FROM locations WHERE code = ?", code).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errLocationNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("erro ao buscar depósito %q: %w", code, err)
+	}
+	return id, nil
+}
+
+// adjustLocationStock soma delta ao saldo do produto no depósito, travando a linha até o commit.
+// Devolve o novo saldo, ou errInsufficientStock se ele ficaria negativo.
+func adjustLocationStock(ctx context.Context, tx dbExecutor, productID, locationID, delta int) (int, error) {
+	var quantity int
+	query := "SELECT quantity FROM product_stock WHERE product_id = ? AND location_id = ? FOR UPDATE"
+	err := tx.QueryRowContext(ctx, query, productID, locationID).Scan(&quantity)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("erro ao buscar saldo do produto %d no depósito %d: %w", productID, locationID, err)
+	}
+
+	quantity += delta
+	if quantity < 0 {
+		return 0, errInsufficientStock
+	}
+
+	query = "INSERT INTO product_stock(product_id, location_id, quantity) VALUES(?,?,?) ON DUPLICATE KEY UPDATE quantity = VALUES(quantity)"
+	if _, err := tx.ExecContext(ctx, query, productID, locationID, quantity); err != nil {
+		return 0, fmt.Errorf("erro ao atualizar saldo do produto %d no depósito %d: %w", productID, locationID, err)
+	}
+	return quantity, nil
+}
+
+// syncDefaultLocation recalcula o saldo do depósito padrão para que a soma dos depósitos
+// continue igual a products.quantity depois de um POST/PUT/PATCH de produto.
+// Devolve errInsufficientStock se quantity for menor que o estoque dos outros depósitos.
+func syncDefaultLocation(ctx context.Context, tx dbExecutor, productID, quantity int) error {
+	defaultID, err := locationIDByCode(ctx, tx, defaultLocationCode)
+	if err != nil {
+		return err
+	}
+
+	var others int
+	query := "SELECT COALESCE(SUM(quantity), 0) FROM product_stock WHERE product_id = ? AND location_id <> ?"
+	if err := tx.QueryRowContext(ctx, query, productID, defaultID).Scan(&others); err != nil {
+		return fmt.Errorf("erro ao somar saldo dos depósitos do produto %d: %w", productID, err)
+	}
+	if quantity < others {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"component":  "database",
+			"operation":  "sync_default_location",
+			"product_id": productID,
+			"quantity":   quantity,
+			"others":     others,
+		}).Warn("Quantidade menor que o estoque alocado em outros depósitos")
+		return errInsufficientStock
+	}
+
+	query = "INSERT INTO product_stock(product_id, location_id, quantity) VALUES(?,?,?) ON DUPLICATE KEY UPDATE quantity = VALUES(quantity)"
+	if _, err := tx.ExecContext(ctx, query, productID, defaultID, quantity-others); err != nil {
+		return fmt.Errorf("erro ao atualizar depósito padrão do produto %d: %w", productID, err)
+	}
+	return nil
+}
+
+// getProductStock busca o saldo do produto por depósito. locationCode vazio lista todos.
+func getProductStock(ctx context.Context, db dbExecutor, productID int, locationCode string) ([]locationStock, error) {
+	query := `SELECT l.code, ps.quantity FROM product_stock ps
+		JOIN locations l ON l.id = ps.location_id
+		WHERE ps.product_id = ?`
+	args := []interface{}{productID}
+	if locationCode != "" {
+		query += " AND l.code = ?"
+		args = append(args, locationCode)
+	}
+	query += " ORDER BY l.code"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("product_id", productID).Error("Erro ao executar QueryContext em getProductStock")
+		return nil, fmt.Errorf("erro ao buscar estoque por depósito do produto %d: %w", productID, err)
+	}
+	defer rows.Close()
+
+	stock := []locationStock{}
+	for rows.Next() {
+		var s locationStock
+		if err := rows.Scan(&s.Location, &s.Quantity); err != nil {
+			return nil, fmt.Errorf("erro ao ler estoque por depósito: %w", err)
+		}
+		stock = append(stock, s)
+	}
+	return stock, rows.Err()
+}
+
+// apply executa a transferência na transação tx: trava o produto, tira do depósito de origem,
+// coloca no de destino e registra as duas pernas no livro-razão (transfer -N e +N).
+func (t *locationTransfer) apply(ctx context.Context, tx dbExecutor) error {
+	var quantity, reserved int
+	query := "SELECT quantity, reserved FROM products WHERE id = ? AND deleted_at IS NULL FOR UPDATE"
+	if err := tx.QueryRowContext(ctx, query, t.ProductID).Scan(&quantity, &reserved); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return sql.ErrNoRows
+		}
+		return fmt.Errorf("erro ao travar produto %d para transferência: %w", t.ProductID, err)
+	}
+
+	fromID, err := locationIDByCode(ctx, tx, t.From)
+	if err != nil {
+		return err
+	}
+	toID, err := locationIDByCode(ctx, tx, t.To)
+	if err != nil {
+		return err
+	}
+
+	if _, err := adjustLocationStock(ctx, tx, t.ProductID, fromID, -t.Quantity); err != nil {
+		return err
+	}
+	if _, err := adjustLocationStock(ctx, tx, t.ProductID, toID, t.Quantity); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	query = "INSERT INTO stock_movements(product_id, location_id, type, quantity, reason, quantity_after, reserved_after, created_at) VALUES(?,?,?,?,?,?,?,?),(?,?,?,?,?,?,?,?)"
+	_, err = tx.ExecContext(ctx, query,
+		t.ProductID, fromID, stockTransfer, -t.Quantity, t.Reason, quantity, reserved, now,
+		t.ProductID, toID, stockTransfer, t.Quantity, t.Reason, quantity, reserved, now,
+	)
+	if err != nil {
+		return fmt.Errorf("erro ao gravar transferência do produto %d: %w", t.ProductID, err)
+	}
+	return nil
+}
+
+// countStockByLocation soma o estoque dos produtos ativos por depósito (gauge products_stock_by_location)
+func countStockByLocation(ctx context.Context, db dbExecutor) (map[string]int, error) {
+	query := `SELECT l.code, COALESCE(SUM(ps.quantity), 0) FROM locations l
+		LEFT JOIN product_stock ps ON ps.location_id = l.id
+			AND ps.product_id IN (SELECT id FROM products WHERE deleted_at IS NULL)
+		GROUP BY l.code`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao executar QueryContext em countStockByLocation")
+		return nil, fmt.Errorf("erro ao somar estoque por depósito: %w", err)
+	}
+	defer rows.Close()
+
+	totals := map[string]int{}
+	for rows.Next() {
+		var code string
+		var quantity int
+		if err := rows.Scan(&code, &quantity); err != nil {
+			return nil, fmt.Errorf("erro ao ler estoque por depósito: %w", err)
+		}
+		totals[code] = quantity
+	}
+	return totals, rows.Err()
+}
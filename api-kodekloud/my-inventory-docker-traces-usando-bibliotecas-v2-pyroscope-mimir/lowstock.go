@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// stockLevel é o estoque de um produto comparado ao seu ponto de reposição
+type stockLevel struct {
+	Quantity         int
+	ReorderThreshold int
+}
+
+// lowStockPage é a resposta do GET /products/low-stock
+type lowStockPage struct {
+	Products []product `json:"products" xml:"products>item"`
+	pageLimits
+}
+
+func (p lowStockPage) pageItems() interface{} {
+	return p.Products
+}
+
+// below indica se o nível está abaixo do ponto de reposição (threshold 0 nunca está)
+func (l stockLevel) below() bool {
+	return l.ReorderThreshold > 0 && l.Quantity < l.ReorderThreshold
+}
+
+// stockLevel devolve o nível de estoque do produto
+func (p product) stockLevel() stockLevel {
+	return stockLevel{Quantity: p.Quantity, ReorderThreshold: p.ReorderThreshold}
+}
+
+// lowStockEvent é o campo event do log emitido quando o produto cruza o ponto de reposição
+const lowStockEvent = "low_stock"
+
+// logThresholdCrossing emite um WARN (com trace_id, para os alertas do Loki) quando a escrita
+// leva o produto para baixo do ponto de reposição. Produtos que já estavam abaixo não repetem o alerta.
+// Dentro de uma requisição o log espera o commit da transação (commitTx), como os eventos do stream:
+// dry-run de import e lotes atômicos que falharam não alertam.
+func logThresholdCrossing(ctx context.Context, p product, before stockLevel) {
+	after := p.stockLevel()
+	if !after.below() || before.below() {
+		return
+	}
+	if holdProductEvent(ctx, pendingProductEvent{event: lowStockEvent, product: p, before: before}) {
+		return
+	}
+	logLowStock(ctx, p, before)
+}
+
+// logLowStock emite o WARN de low_stock
+func logLowStock(ctx context.Context, p product, before stockLevel) {
+	logWithTrace(ctx).WithFields(logrus.Fields{
+		"component":         "database",
+		"event":             lowStockEvent,
+		"product_id":        p.ID,
+		"sku":               p.SKU,
+		"quantity":          p.Quantity,
+		"quantity_before":   before.Quantity,
+		"reorder_threshold": p.ReorderThreshold,
+	}).Warn("Estoque do produto abaixo do ponto de reposição")
+}
+
+// getLowStockProducts busca os produtos ativos abaixo do ponto de reposição, dos mais críticos
+// (maior falta em relação ao threshold) para os menos críticos
+func getLowStockProducts(ctx context.Context, db dbExecutor, limit, offset int) ([]product, error) {
+	query := "SELECT " + productColumns + ` FROM products
+		WHERE deleted_at IS NULL AND reorder_threshold > 0 AND quantity < reorder_threshold
+		ORDER BY quantity - reorder_threshold, id LIMIT ? OFFSET ?`
+	rows, err := db.QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao executar QueryContext em getLowStockProducts")
+		return nil, fmt.Errorf("erro ao buscar produtos com estoque baixo: %w", err)
+	}
+	defer rows.Close()
+
+	products := []product{}
+	for rows.Next() {
+		var p product
+		if err := rows.Scan(p.scanFields()...); err != nil {
+			return nil, fmt.Errorf("erro ao ler dados do produto: %w", err)
+		}
+		p.normalizePrice()
+		products = append(products, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao iterar sobre produtos com estoque baixo: %w", err)
+	}
+	return products, nil
+}
+
+// countLowStockProducts conta os produtos ativos abaixo do ponto de reposição (gauge products_below_threshold)
+func countLowStockProducts(ctx context.Context, db dbExecutor) (int, error) {
+	var count int
+	query := "SELECT COUNT(*) FROM products WHERE deleted_at IS NULL AND reorder_threshold > 0 AND quantity < reorder_threshold"
+	if err := db.QueryRowContext(ctx, query).Scan(&count); err != nil {
+		return 0, fmt.Errorf("erro ao contar produtos com estoque baixo: %w", err)
+	}
+	return count, nil
+}
+
+// getStockLevelsByProduct busca quantity de todos os produtos ativos (gauge product_stock_level)
+func getStockLevelsByProduct(ctx context.Context, db dbExecutor) ([]product, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, sku, quantity FROM products WHERE deleted_at IS NULL")
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar nível de estoque dos produtos: %w", err)
+	}
+	defer rows.Close()
+
+	products := []product{}
+	for rows.Next() {
+		var p product
+		if err := rows.Scan(&p.ID, &p.SKU, &p.Quantity); err != nil {
+			return nil, fmt.Errorf("erro ao ler nível de estoque: %w", err)
+		}
+		products = append(products, p)
+	}
+	return products, rows.Err()
+}
+
+// productStockLevelMetricsEnabled lê PRODUCT_STOCK_LEVEL_METRICS. O gauge product_stock_level
+// tem uma série por produto, então fica desligado por padrão para controlar a cardinalidade.
+func productStockLevelMetricsEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("PRODUCT_STOCK_LEVEL_METRICS"))
+	return enabled
+}
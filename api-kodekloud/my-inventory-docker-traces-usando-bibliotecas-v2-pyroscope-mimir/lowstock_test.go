@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestStockLevelBelow(t *testing.T) {
+	tests := []struct {
+		level stockLevel
+		want  bool
+	}{
+		{stockLevel{Quantity: 4, ReorderThreshold: 5}, true},
+		{stockLevel{Quantity: 5, ReorderThreshold: 5}, false},
+		{stockLevel{Quantity: 0, ReorderThreshold: 0}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.level.below(); got != tt.want {
+			t.Errorf("%+v.below() = %v, esperado %v", tt.level, got, tt.want)
+		}
+	}
+}
+
+// O low_stock de uma requisição só é logado depois do commit da transação
+func TestLogThresholdCrossingWaitsForCommit(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	crossed := product{ID: 1, SKU: "NB-001", Quantity: 2, ReorderThreshold: 5}
+	before := stockLevel{Quantity: 8, ReorderThreshold: 5}
+
+	tests := []struct {
+		name      string
+		committed bool
+		want      int
+	}{
+		{name: "commit", committed: true, want: 1},
+		{name: "rollback", committed: false, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook.Reset()
+			ctx := contextWithPendingProductEvents(context.Background())
+			logThresholdCrossing(ctx, crossed, before)
+			// Produto que já estava abaixo não repete o alerta
+			logThresholdCrossing(ctx, crossed, crossed.stockLevel())
+			if n := countLowStockLogs(hook); n != 0 {
+				t.Fatalf("low_stock logado antes do commit: %d", n)
+			}
+
+			newProductStream().publishCommitted(ctx, tt.committed)
+			if n := countLowStockLogs(hook); n != tt.want {
+				t.Errorf("logs low_stock = %d, esperado %d", n, tt.want)
+			}
+		})
+	}
+
+	// Fora de uma requisição não há transação para esperar
+	hook.Reset()
+	logThresholdCrossing(context.Background(), crossed, before)
+	if n := countLowStockLogs(hook); n != 1 {
+		t.Errorf("logs low_stock fora de requisição = %d, esperado 1", n)
+	}
+}
+
+func countLowStockLogs(hook *test.Hook) int {
+	n := 0
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel && entry.Data["event"] == lowStockEvent {
+			n++
+		}
+	}
+	return n
+}
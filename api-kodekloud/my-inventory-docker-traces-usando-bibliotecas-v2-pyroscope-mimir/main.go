@@ -3,13 +3,17 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	_ "net/http/pprof" // Importa pprof para profiling
 	"os"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/grafana/pyroscope-go"
+	grpcprom "github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -73,16 +77,166 @@ var (
 	})
 
 	//Exemplo de métrica específica da aplicação
-	productsInDB = promauto.NewGauge(prometheus.GaugeOpts{
-		Name: "products_in_db",
-		Help: "Número de produtos no banco de dados",
+	productsInDB = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "products_in_db",
+			Help: "Número de produtos no banco de dados, por categoria",
+		},
+		[]string{"category"}, // "none" para produtos sem categoria
+	)
+
+	// Estoque físico (soma de product_stock) de produtos ativos, por depósito
+	productsStockByLocation = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "products_stock_by_location",
+			Help: "Unidades em estoque por depósito",
+		},
+		[]string{"location"},
+	)
+
+	// Produtos ativos com quantity abaixo do reorder_threshold
+	productsBelowThreshold = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "products_below_threshold",
+		Help: "Número de produtos com estoque abaixo do ponto de reposição",
 	})
 
+	// Uma série por produto: só é preenchida com PRODUCT_STOCK_LEVEL_METRICS=true
+	productStockLevel = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "product_stock_level",
+			Help: "Quantidade em estoque de cada produto",
+		},
+		[]string{"product_id", "sku"},
+	)
+
 	//Exemplo de métrica de erro
 	sqlErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "sql_errors_total",
 		Help: "Número total de erros de SQL",
 	})
+
+	// Violações de UNIQUE (erro 1062 do MySQL), contadas à parte do sql_errors_total por serem erros do cliente
+	sqlDuplicateKeyConflictsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sql_duplicate_key_conflicts_total",
+			Help: "Número total de escritas rejeitadas por chave duplicada (409 Conflict)",
+		},
+		[]string{"table"},
+	)
+
+	// Movimentações de estoque aplicadas, por tipo (adjust, receive, ship, reserve, release)
+	stockMovementsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "stock_movements_total",
+			Help: "Número total de movimentações de estoque aplicadas",
+		},
+		[]string{"type"},
+	)
+
+	// Reservas de estoque com prazo (checkout)
+	reservationsActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "reservations_active",
+		Help: "Número de reservas de estoque ativas (atualizado pela expiração periódica de reservas)",
+	})
+
+	reservationsExpiredTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "reservations_expired_total",
+		Help: "Número total de reservas de estoque expiradas",
+	})
+
+	// Produtos removidos definitivamente da lixeira pelo job de purge
+	productsPurgedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "products_purged_total",
+		Help: "Número total de produtos removidos definitivamente da lixeira",
+	})
+
+	// Pré-condições (If-Match) ausentes ou que não batem com a versão atual do produto
+	preconditionFailuresTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_precondition_failures_total",
+			Help: "Número total de requisições rejeitadas por If-Match ausente ou desatualizado",
+		},
+		[]string{"method", "reason"}, // reason: missing | mismatch
+	)
+
+	// Métricas dos webhooks de eventos de produto
+	webhookDeliveriesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_deliveries_total",
+			Help: "Número total de tentativas de entrega de webhooks",
+		},
+		[]string{"event", "result"}, // result: delivered | retry | dead
+	)
+
+	webhookDeliveryDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "webhook_delivery_duration_seconds",
+			Help:    "Duração das requisições de entrega de webhooks em segundos",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"event"},
+	)
+
+	webhookDeliveriesQueued = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "webhook_deliveries",
+			Help: "Número atual de entregas de webhook pendentes e na dead-letter list",
+		},
+		[]string{"status"}, // status: pending | dead
+	)
+
+	// Métricas do servidor gRPC (grpc_server_started_total, _handled_total e _handling_seconds),
+	// registradas no init
+	grpcServerMetrics = grpcprom.NewServerMetrics(grpcprom.WithServerHandlingTimeHistogram())
+
+	// Imagens de produto: upload (leitura do multipart até gravar no armazenamento) e processamento (miniatura)
+	imageUploadDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "product_image_upload_duration_seconds",
+			Help:    "Duração dos uploads de imagem de produto em segundos",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		},
+		[]string{"backend", "result"}, // result: created | rejected | error
+	)
+
+	imageProcessingDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "product_image_processing_duration_seconds",
+			Help:    "Duração da validação e geração de miniatura das imagens de produto em segundos",
+			Buckets: []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+		},
+		[]string{"result"}, // result: ok | rejected
+	)
+
+	// Clientes conectados ao GET /products/stream
+	productStreamSubscribers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "product_stream_subscribers",
+		Help: "Número atual de clientes conectados ao stream de eventos de produto (SSE)",
+	})
+
+	// Requisições por versão da API; deprecated="true" são as rotas sem prefixo (legado)
+	apiVersionRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "api_version_requests_total",
+			Help: "Número total de requisições por versão da API",
+		},
+		[]string{"version", "deprecated"},
+	)
+
+	// Falhas das regras de validação, por recurso e campo (uma por campo inválido em cada validação)
+	validationFailuresTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "validation_failures_total",
+			Help: "Número total de falhas de validação por campo",
+		},
+		[]string{"resource", "field"},
+	)
+
+	// Métrica para POST /product repetidos com a mesma Idempotency-Key
+	idempotentReplaysTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "idempotency_replays_total",
+		Help: "Número total de respostas repetidas a partir de uma Idempotency-Key já utilizada",
+	})
 )
 
 // ResponseWriterWrapper para capturar o status code
@@ -96,6 +250,18 @@ func (rw *ResponseWriterWrapper) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Flush repassa o flush ao writer original (necessário para o GET /products/stream)
+func (rw *ResponseWriterWrapper) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap permite ao http.ResponseController chegar ao writer original
+func (rw *ResponseWriterWrapper) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func NewResponseWriterWrapper(w http.ResponseWriter) *ResponseWriterWrapper {
 	return &ResponseWriterWrapper{w, http.StatusOK} // Status padrão
 }
@@ -113,17 +279,26 @@ func prometheusMiddleware(next http.Handler) http.Handler {
 		duration := time.Since(startTime)
 		statusCode := wrappedWriter.statusCode
 
+		// 404 e 405 ficam num path só, para que paths inventados não criem séries novas
+		path := r.URL.Path
+		if mux.CurrentRoute(r) == nil {
+			path = "unmatched"
+		}
+
 		httpRequestsTotal.With(prometheus.Labels{
-			"path":   r.URL.Path,
+			"path":   path,
 			"method": r.Method,
 			"status": fmt.Sprintf("%d", statusCode),
 		}).Inc()
 
-		// Registra a duração no histograma
-		httpRequestDuration.With(prometheus.Labels{
-			"path":   r.URL.Path,
-			"method": r.Method,
-		}).Observe(duration.Seconds())
+		// Registra a duração no histograma. O stream SSE fica de fora: a duração é o tempo
+		// que o cliente ficou conectado e distorceria os percentis de latência.
+		if !strings.HasSuffix(r.URL.Path, productStreamPath) {
+			httpRequestDuration.With(prometheus.Labels{
+				"path":   path,
+				"method": r.Method,
+			}).Observe(duration.Seconds())
+		}
 
 		// Verificação de debug para ver se há span ativo no contexto
 		span := trace.SpanFromContext(r.Context())
@@ -165,6 +340,8 @@ func init() {
 	})
 	logrus.SetOutput(os.Stdout)
 
+	prometheus.MustRegister(grpcServerMetrics)
+
 	// Hook do otellogrus será adicionado no main() após o tracer provider estar configurado
 }
 
@@ -272,7 +449,7 @@ func main() {
 	// --- Fim da Inicialização do OpenTelemetry ---
 
 	var wg sync.WaitGroup
-	wg.Add(2) // Incrementando para 2 goroutines
+	wg.Add(3) // Métricas, API REST e gRPC
 
 	// Inicia o servidor de métricas
 	go func() {
@@ -306,6 +483,22 @@ func main() {
 		}
 	}()
 
+	// Inicia o servidor gRPC (InventoryService, health e reflection)
+	go func() {
+		defer wg.Done()
+		addr := grpcAddr()
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			logrus.WithError(err).Fatalf("Erro ao abrir a porta %s do servidor gRPC", addr)
+		}
+		grpcServer, healthServer := newGRPCServer(&app)
+		go app.startGRPCHealthCheck(healthServer)
+		logrus.Infof("Servidor gRPC iniciando na porta %s", addr)
+		if err := grpcServer.Serve(listener); err != nil {
+			logrus.WithError(err).Fatalf("Erro ao iniciar o servidor gRPC na porta %s", addr)
+		}
+	}()
+
 	logrus.Info("Servidores iniciados. Aguardando...")
 	wg.Wait()
 	logrus.Info("Todos os servidores foram encerrados.")
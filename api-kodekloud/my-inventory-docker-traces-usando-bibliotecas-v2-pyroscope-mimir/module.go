@@ -5,49 +5,112 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
 )
 
 
-// Struct product (sem alterações)
+// Struct product
+// Version é incrementada a cada escrita e exposta como ETag (controle de concorrência otimista)
 type product struct {
-	ID       int     `json:"id"`
-	Name     string  `json:"name"`
-	Quantity int     `json:"quantity"`
-	Price    float64 `json:"price"`
+	ID         int     `json:"id" xml:"id"`
+	SKU        string  `json:"sku" xml:"sku"`
+	Name       string  `json:"name" xml:"name"`
+	Quantity   int     `json:"quantity" xml:"quantity"`
+	Reserved   int     `json:"reserved" xml:"reserved"`   // só muda por movimentação de estoque (reserve/release) e reservas
+	Available  int     `json:"available" xml:"available"` // quantity - reserved, calculado na consulta
+	Price      money   `json:"price" xml:"price"`         // decimal exato, serializado como string ("3500.00")
+	Currency   string  `json:"currency" xml:"currency"`   // ISO 4217; padrão BRL
+	CategoryID *int    `json:"category_id" xml:"category_id"`
+	// ReorderThreshold: quantity abaixo deste valor entra em GET /products/low-stock (0 desliga o alerta)
+	ReorderThreshold int `json:"reorder_threshold" xml:"reorder_threshold"`
+	Version    int     `json:"version" xml:"version"`
+	// DeletedAt só é preenchido para produtos na lixeira
+	DeletedAt *time.Time `json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`
+}
+
+// productColumns são as colunas lidas nas consultas de produto, na mesma ordem de scanFields
+const productColumns = "id, sku, name, quantity, reserved, quantity - reserved, price, currency, category_id, reorder_threshold, version, deleted_at"
+
+// scanFields devolve os destinos do Scan para uma linha lida com productColumns.
+// Depois do Scan, chame normalizePrice para ajustar as casas decimais à moeda.
+func (p *product) scanFields() []interface{} {
+	return []interface{}{&p.ID, &p.SKU, &p.Name, &p.Quantity, &p.Reserved, &p.Available, &p.Price, &p.Currency, &p.CategoryID, &p.ReorderThreshold, &p.Version, &p.DeletedAt}
 }
 
-// getProductsFromDB busca todos os produtos, agora com contexto
-func getProductsFromDB(ctx context.Context, db *sql.DB) ([]product, error) {
+// dbExecutor é satisfeita por *sql.DB e *sql.Tx, permitindo que as operações de produto
+// rodem tanto direto no banco quanto dentro de uma transação
+type dbExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// skuPattern: letras, números, ponto, hífen e underscore, começando por letra ou número (até 64 caracteres)
+var skuPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Códigos de erro do MySQL tratados pela aplicação
+const (
+	mysqlErrDuplicateEntry  = 1062 // violação de UNIQUE
+	mysqlErrRowIsReferenced = 1451 // linha referenciada por uma FOREIGN KEY
+	mysqlErrNoReferencedRow = 1452 // FOREIGN KEY aponta para uma linha inexistente
+)
+
+// isMySQLError verifica se err (ou algum erro encapsulado nele) é o erro number do MySQL
+func isMySQLError(err error, number uint16) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == number
+}
+
+// errVersionConflict indica que a versão informada no If-Match não é mais a versão atual do produto
+var errVersionConflict = errors.New("versão do produto desatualizada")
+
+// getProductsFromDB busca uma página de produtos aplicando filtros, ordenação e paginação.
+// Busca limit+1 linhas para saber se existe uma próxima página (hasMore).
+func getProductsFromDB(ctx context.Context, db *sql.DB, q productQuery) ([]product, bool, error) {
 	logrus.WithContext(ctx).WithFields(logrus.Fields{
 		"component": "database",
 		"operation": "get_products",
+		"limit":     q.Limit,
+		"offset":    q.Offset,
+		"sort":      q.sortKey(),
 	}).Debug("Iniciando getProductsFromDB")
 
-	query := "SELECT id, name, quantity, price FROM products"
-	rows, err := db.QueryContext(ctx, query)
+	conditions, args := q.filterConditions()
+	if condition, cursorArgs := q.cursorCondition(); condition != "" {
+		conditions = append(conditions, condition)
+		args = append(args, cursorArgs...)
+	}
+	query := "SELECT " + productColumns + " FROM products" + whereClause(conditions) + q.orderClause() + " LIMIT ? OFFSET ?"
+	args = append(args, q.Limit+1, q.Offset)
+
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		logrus.WithContext(ctx).WithFields(logrus.Fields{
 			"component": "database",
 			"operation": "get_products",
 			"error":     err.Error(),
 		}).Error("Erro ao executar QueryContext em getProductsFromDB")
-		return nil, fmt.Errorf("erro ao buscar produtos: %w", err)
+		return nil, false, fmt.Errorf("erro ao buscar produtos: %w", err)
 	}
 	defer rows.Close()
 
 	products := []product{}
 	for rows.Next() {
 		var p product
-		err := rows.Scan(&p.ID, &p.Name, &p.Quantity, &p.Price)
+		err := rows.Scan(p.scanFields()...)
 		if err != nil {
 			logrus.WithContext(ctx).WithFields(logrus.Fields{
 				"component": "database",
 				"operation": "get_products",
 				"error":     err.Error(),
 			}).Error("Erro ao ler os dados da linha em getProductsFromDB")
-			return nil, fmt.Errorf("erro ao ler dados do produto: %w", err)
+			return nil, false, fmt.Errorf("erro ao ler dados do produto: %w", err)
 		}
+		p.normalizePrice()
 		products = append(products, p)
 	}
 
@@ -57,28 +120,47 @@ func getProductsFromDB(ctx context.Context, db *sql.DB) ([]product, error) {
 			"operation": "get_products",
 			"error":     err.Error(),
 		}).Error("Erro durante a iteração das linhas em getProductsFromDB")
-		return nil, fmt.Errorf("erro ao iterar sobre produtos: %w", err)
+		return nil, false, fmt.Errorf("erro ao iterar sobre produtos: %w", err)
+	}
+
+	hasMore := len(products) > q.Limit
+	if hasMore {
+		products = products[:q.Limit]
 	}
 
 	logrus.WithContext(ctx).WithFields(logrus.Fields{
 		"component":    "database",
 		"operation":    "get_products",
 		"num_products": len(products),
+		"has_more":     hasMore,
 	}).Debug("Produtos encontrados em getProductsFromDB")
-	return products, nil
+	return products, hasMore, nil
+}
+
+// countFilteredProducts conta os produtos que atendem aos filtros do GET /products (ignora paginação)
+func countFilteredProducts(ctx context.Context, db *sql.DB, q productQuery) (int, error) {
+	var count int
+	conditions, args := q.filterConditions()
+	query := "SELECT COUNT(*) FROM products" + whereClause(conditions)
+	err := db.QueryRowContext(ctx, query, args...).Scan(&count)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao executar QueryRowContext ou Scan em countFilteredProducts")
+		return 0, fmt.Errorf("erro ao contar produtos filtrados: %w", err)
+	}
+	return count, nil
 }
 
 // getProduct busca um produto pelo ID, agora com contexto
-func (p *product) getProduct(ctx context.Context, db *sql.DB) error {
+func (p *product) getProduct(ctx context.Context, db dbExecutor) error {
 	logrus.WithContext(ctx).WithFields(logrus.Fields{
 		"component":  "database",
 		"operation": "get_product",
 		"product_id": p.ID,
 	}).Debug("Iniciando getProduct")
 
-	query := "SELECT name, quantity, price FROM products WHERE id = ?"
+	query := "SELECT " + productColumns + " FROM products WHERE id = ? AND deleted_at IS NULL"
 	row := db.QueryRowContext(ctx, query, p.ID)
-	err := row.Scan(&p.Name, &p.Quantity, &p.Price)
+	err := row.Scan(p.scanFields()...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			logrus.WithContext(ctx).WithFields(logrus.Fields{
@@ -97,6 +179,8 @@ func (p *product) getProduct(ctx context.Context, db *sql.DB) error {
 		return fmt.Errorf("erro ao buscar produto %d: %w", p.ID, err)
 	}
 
+	p.normalizePrice()
+
 	logrus.WithContext(ctx).WithFields(logrus.Fields{
 		"component":  "database",
 		"operation": "get_product",
@@ -105,16 +189,57 @@ func (p *product) getProduct(ctx context.Context, db *sql.DB) error {
 	return nil
 }
 
-// createProduct cria um novo produto, agora com contexto
-func (p *product) createProduct(ctx context.Context, db *sql.DB) error {
+// getProductForUpdate lê o produto ativo travando a linha até o fim da transação
+func (p *product) getProductForUpdate(ctx context.Context, db dbExecutor) error {
+	query := "SELECT " + productColumns + " FROM products WHERE id = ? AND deleted_at IS NULL FOR UPDATE"
+	if err := db.QueryRowContext(ctx, query, p.ID).Scan(p.scanFields()...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return sql.ErrNoRows
+		}
+		return fmt.Errorf("erro ao travar produto %d: %w", p.ID, err)
+	}
+	p.normalizePrice()
+	return nil
+}
+
+// getProductBySKU busca um produto ativo pelo SKU
+func (p *product) getProductBySKU(ctx context.Context, db dbExecutor) error {
+	query := "SELECT " + productColumns + " FROM products WHERE sku = ? AND deleted_at IS NULL"
+	err := db.QueryRowContext(ctx, query, p.SKU).Scan(p.scanFields()...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logrus.WithContext(ctx).WithFields(logrus.Fields{
+				"component": "database",
+				"operation": "get_product_by_sku",
+				"sku":       p.SKU,
+			}).Warn("Produto não encontrado pelo SKU")
+			return sql.ErrNoRows
+		}
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"component": "database",
+			"operation": "get_product_by_sku",
+			"sku":       p.SKU,
+			"error":     err.Error(),
+		}).Error("Erro ao buscar produto pelo SKU")
+		return fmt.Errorf("erro ao buscar produto pelo SKU %q: %w", p.SKU, err)
+	}
+	p.normalizePrice()
+	return nil
+}
+
+// createProduct cria um novo produto, agora com contexto.
+// Deve rodar em uma transação junto com o saldo do depósito padrão (product_stock).
+func (p *product) createProduct(ctx context.Context, db dbExecutor) error {
 	logrus.WithContext(ctx).WithFields(logrus.Fields{
 		"component":  "database",
 		"operation": "create_product",
 		"product_name": p.Name,
 	}).Debug("Iniciando createProduct")
-	query := "INSERT INTO products(name, quantity, price) VALUES(?,?,?)"
+	p.Currency = p.currencyCode()
+	p.normalizePrice()
+	query := "INSERT INTO products(sku, name, quantity, price, currency, category_id, reorder_threshold) VALUES(?,?,?,?,?,?,?)"
 	// Usa ExecContext para passar o contexto
-	result, err := db.ExecContext(ctx, query, p.Name, p.Quantity, p.Price)
+	result, err := db.ExecContext(ctx, query, p.SKU, p.Name, p.Quantity, p.Price, p.Currency, p.CategoryID, p.ReorderThreshold)
 	if err != nil {
 		logrus.WithContext(ctx).WithFields(logrus.Fields{
 			"component":  "database",
@@ -131,6 +256,24 @@ func (p *product) createProduct(ctx context.Context, db *sql.DB) error {
 		return fmt.Errorf("erro ao obter ID do produto: %w", err)
 	}
 	p.ID = int(id)
+	p.Version = 1
+	p.Reserved, p.Available = 0, p.Quantity
+
+	// O estoque inicial entra inteiro no depósito padrão
+	if err := syncDefaultLocation(ctx, db, p.ID, p.Quantity); err != nil {
+		return err
+	}
+	if err := recordProductHistory(ctx, db, p.ID, historyCreate); err != nil {
+		return err
+	}
+	if err := recordAuditEvent(ctx, db, p.ID, auditCreate, nil, p); err != nil {
+		return err
+	}
+	if err := recordProductEvent(ctx, db, webhookProductCreated, *p); err != nil {
+		return err
+	}
+	// Um produto já criado abaixo do ponto de reposição também dispara o alerta
+	logThresholdCrossing(ctx, *p, stockLevel{})
 
 	logrus.WithContext(ctx).WithFields(logrus.Fields{
 		"component":  "database",
@@ -140,16 +283,43 @@ func (p *product) createProduct(ctx context.Context, db *sql.DB) error {
 	return nil
 }
 
-// updateProduct atualiza um produto, agora com contexto
-func (p *product) updateProduct(ctx context.Context, db *sql.DB) error {
+// updateProduct atualiza um produto, agora com contexto.
+// Se p.Version for maior que zero, a escrita só acontece se a versão no banco for a mesma
+// (errVersionConflict caso contrário). Ao final, p.Version contém a nova versão.
+// Assim como o createProduct, deve rodar em uma transação: devolve errInsufficientStock
+// se a nova quantity for menor que o reserved gravado ou que o estoque alocado fora do
+// depósito padrão.
+func (p *product) updateProduct(ctx context.Context, db dbExecutor) error {
 	logrus.WithContext(ctx).WithFields(logrus.Fields{
 		"component":  "database",
 		"operation": "update_product",
 		"product_id": p.ID,
 	}).Debug("Iniciando updateProduct")
-	query := "UPDATE products SET name =?, quantity =?, price =? WHERE id =?"
+	// Estado anterior, para a auditoria e para detectar a passagem pelo ponto de reposição.
+	// Se o produto não existir, o UPDATE abaixo devolve o erro adequado.
+	before := product{ID: p.ID}
+	if err := before.getProductForUpdate(ctx, db); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	// reserved não vem no corpo do PUT/PATCH; a nova quantity precisa continuar cobrindo as
+	// unidades reservadas, a mesma regra do stockMovement.apply
+	if p.Quantity < before.Reserved {
+		p.Reserved = before.Reserved
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"component":  "database",
+			"operation":  "update_product",
+			"product_id": p.ID,
+			"quantity":   p.Quantity,
+			"reserved":   before.Reserved,
+		}).Warn("Quantidade menor que o estoque reservado")
+		return errInsufficientStock
+	}
+	// LAST_INSERT_ID(expr) guarda a nova versão na conexão, devolvida por result.LastInsertId()
+	p.Currency = p.currencyCode()
+	p.normalizePrice()
+	query := "UPDATE products SET sku =?, name =?, quantity =?, price =?, currency =?, category_id =?, reorder_threshold =?, version = LAST_INSERT_ID(version + 1) WHERE id =? AND deleted_at IS NULL AND (? = 0 OR version = ?)"
 	// Usa ExecContext para passar o contexto
-	result, err := db.ExecContext(ctx, query, p.Name, p.Quantity, p.Price, p.ID)
+	result, err := db.ExecContext(ctx, query, p.SKU, p.Name, p.Quantity, p.Price, p.Currency, p.CategoryID, p.ReorderThreshold, p.ID, p.Version, p.Version)
 	if err != nil {
 		logrus.WithContext(ctx).WithFields(logrus.Fields{
 			"component":  "database",
@@ -176,10 +346,34 @@ func (p *product) updateProduct(ctx context.Context, db *sql.DB) error {
 			"component":  "database",
 			"operation": "update_product",
 			"product_id": p.ID,
-		}).Warn("Nenhum produto atualizado em updateProduct (ID não encontrado?)")
-		// Retorna ErrNoRows para indicar que o ID não foi encontrado
-		return sql.ErrNoRows
+		}).Warn("Nenhum produto atualizado em updateProduct (ID não encontrado ou versão desatualizada)")
+		return productMissingOrStale(ctx, db, p.ID)
+	}
+
+	version, err := result.LastInsertId()
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao obter a nova versão do produto em updateProduct")
+		return fmt.Errorf("erro ao obter versão do produto %d: %w", p.ID, err)
+	}
+	p.Version = int(version)
+
+	// A diferença de quantity vai para o depósito padrão; os outros depósitos não mudam
+	if err := syncDefaultLocation(ctx, db, p.ID, p.Quantity); err != nil {
+		return err
 	}
+	// reserved não vem no corpo do PUT/PATCH; mantém o valor gravado
+	p.Reserved = before.Reserved
+	p.Available = p.Quantity - p.Reserved
+	if err := recordProductHistory(ctx, db, p.ID, historyUpdate); err != nil {
+		return err
+	}
+	if err := recordAuditEvent(ctx, db, p.ID, auditUpdate, &before, p); err != nil {
+		return err
+	}
+	if err := recordProductEvent(ctx, db, webhookProductUpdated, *p); err != nil {
+		return err
+	}
+	logThresholdCrossing(ctx, *p, before.stockLevel())
 
 	logrus.WithContext(ctx).WithFields(logrus.Fields{
 		"component":  "database",
@@ -189,16 +383,24 @@ func (p *product) updateProduct(ctx context.Context, db *sql.DB) error {
 	return nil
 }
 
-// deleteProduct deleta um produto, agora com contexto
-func (p *product) deleteProduct(ctx context.Context, db *sql.DB) error {
+// deleteProduct move um produto para a lixeira (soft delete), preenchendo deleted_at.
+// A remoção definitiva fica a cargo de purgeDeletedProducts, após o período de retenção
+// (o histórico em product_history é mantido).
+// Assim como no updateProduct, p.Version > 0 exige que a versão no banco seja a mesma.
+func (p *product) deleteProduct(ctx context.Context, db dbExecutor) error {
 	logrus.WithContext(ctx).WithFields(logrus.Fields{
 		"component":  "database",
 		"operation": "delete_product",
 		"product_id": p.ID,
 	}).Debug("Iniciando deleteProduct")
-	query := "DELETE FROM products WHERE id =?"
+	// Estado anterior, para a auditoria. Se o produto não existir, o UPDATE abaixo devolve o erro adequado.
+	before := product{ID: p.ID}
+	if err := before.getProductForUpdate(ctx, db); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	query := "UPDATE products SET deleted_at = NOW(), version = version + 1 WHERE id =? AND deleted_at IS NULL AND (? = 0 OR version = ?)"
 	// Usa ExecContext para passar o contexto
-	result, err := db.ExecContext(ctx, query, p.ID)
+	result, err := db.ExecContext(ctx, query, p.ID, p.Version, p.Version)
 	if err != nil {
 		logrus.WithContext(ctx).WithFields(logrus.Fields{
 			"component":  "database",
@@ -224,9 +426,18 @@ func (p *product) deleteProduct(ctx context.Context, db *sql.DB) error {
 			"component":  "database",
 			"operation": "delete_product",
 			"product_id": p.ID,
-		}).Warn("Nenhum produto excluído em deleteProduct (ID não encontrado?)")
-		// Retorna ErrNoRows para indicar que o ID não foi encontrado
-		return sql.ErrNoRows
+		}).Warn("Nenhum produto excluído em deleteProduct (ID não encontrado ou versão desatualizada)")
+		return productMissingOrStale(ctx, db, p.ID)
+	}
+	if err := recordProductHistory(ctx, db, p.ID, historyDelete); err != nil {
+		return err
+	}
+	if err := recordAuditEvent(ctx, db, p.ID, auditDelete, &before, nil); err != nil {
+		return err
+	}
+	// O evento leva o último estado do produto antes de ir para a lixeira
+	if err := recordProductEvent(ctx, db, webhookProductDeleted, before); err != nil {
+		return err
 	}
 
 	logrus.WithContext(ctx).WithFields(logrus.Fields{
@@ -237,10 +448,123 @@ func (p *product) deleteProduct(ctx context.Context, db *sql.DB) error {
 	return nil
 }
 
+// restoreProduct tira um produto da lixeira. Devolve sql.ErrNoRows se o produto não estiver na lixeira.
+func (p *product) restoreProduct(ctx context.Context, db dbExecutor) error {
+	logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"component":  "database",
+		"operation":  "restore_product",
+		"product_id": p.ID,
+	}).Debug("Iniciando restoreProduct")
+	// Estado na lixeira, para a auditoria
+	before := product{ID: p.ID}
+	trashed := "SELECT " + productColumns + " FROM products WHERE id = ? AND deleted_at IS NOT NULL FOR UPDATE"
+	if err := db.QueryRowContext(ctx, trashed, p.ID).Scan(before.scanFields()...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return sql.ErrNoRows
+		}
+		return fmt.Errorf("erro ao buscar produto %d na lixeira: %w", p.ID, err)
+	}
+	before.normalizePrice()
+	query := "UPDATE products SET deleted_at = NULL, version = version + 1 WHERE id =? AND deleted_at IS NOT NULL"
+	result, err := db.ExecContext(ctx, query, p.ID)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"component":  "database",
+			"operation":  "restore_product",
+			"product_id": p.ID,
+			"error":      err.Error(),
+		}).Error("Erro ao executar ExecContext em restoreProduct")
+		return fmt.Errorf("erro ao restaurar produto %d: %w", p.ID, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("erro ao obter linhas afetadas para produto %d: %w", p.ID, err)
+	}
+	if rowsAffected == 0 {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"component":  "database",
+			"operation":  "restore_product",
+			"product_id": p.ID,
+		}).Warn("Nenhum produto restaurado em restoreProduct (ID não está na lixeira?)")
+		return sql.ErrNoRows
+	}
+	if err := recordProductHistory(ctx, db, p.ID, historyRestore); err != nil {
+		return err
+	}
+
+	// Lê o produto restaurado para devolver a versão atual
+	if err := p.getProduct(ctx, db); err != nil {
+		return err
+	}
+	if err := recordAuditEvent(ctx, db, p.ID, auditRestore, &before, p); err != nil {
+		return err
+	}
+	return recordProductEvent(ctx, db, webhookProductRestored, *p)
+}
+
+// purgeDeletedProducts remove definitivamente os produtos que estão na lixeira há mais que retention
+func purgeDeletedProducts(ctx context.Context, db dbExecutor, retention time.Duration) (int64, error) {
+	// O corte é calculado pelo MySQL (NOW()) para usar o mesmo relógio que preencheu deleted_at
+	query := "DELETE FROM products WHERE deleted_at IS NOT NULL AND deleted_at < NOW() - INTERVAL ? SECOND"
+	result, err := db.ExecContext(ctx, query, int64(retention.Seconds()))
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao executar ExecContext em purgeDeletedProducts")
+		return 0, fmt.Errorf("erro ao remover produtos da lixeira: %w", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("erro ao obter linhas removidas da lixeira: %w", err)
+	}
+	return purged, nil
+}
+
+// productMissingOrStale é chamada quando uma escrita condicionada à versão não afetou nenhuma linha:
+// devolve sql.ErrNoRows se o produto não existe, ou errVersionConflict se ele existe com outra versão.
+func productMissingOrStale(ctx context.Context, db dbExecutor, id int) error {
+	var exists bool
+	query := "SELECT EXISTS(SELECT 1 FROM products WHERE id = ? AND deleted_at IS NULL)"
+	if err := db.QueryRowContext(ctx, query, id).Scan(&exists); err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("product_id", id).Error("Erro ao verificar existência do produto")
+		return fmt.Errorf("erro ao verificar existência do produto %d: %w", id, err)
+	}
+	if exists {
+		return errVersionConflict
+	}
+	return sql.ErrNoRows
+}
+
+// countProductsByCategory conta os produtos ativos agrupados pelo nome da categoria ("none" para sem categoria)
+func countProductsByCategory(ctx context.Context, db *sql.DB) (map[string]int, error) {
+	query := `SELECT COALESCE(c.name, 'none'), COUNT(*) FROM products p
+		LEFT JOIN categories c ON c.id = p.category_id
+		WHERE p.deleted_at IS NULL GROUP BY c.name`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao executar QueryContext em countProductsByCategory")
+		return nil, fmt.Errorf("erro ao contar produtos por categoria: %w", err)
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var category string
+		var count int
+		if err := rows.Scan(&category, &count); err != nil {
+			return nil, fmt.Errorf("erro ao ler contagem por categoria: %w", err)
+		}
+		counts[category] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao iterar sobre contagem por categoria: %w", err)
+	}
+	return counts, nil
+}
+
 // countProducts conta os produtos, agora com contexto
 func countProducts(ctx context.Context, db *sql.DB) (int, error) {
 	var count int
-	query := "SELECT COUNT(*) FROM products"
+	query := "SELECT COUNT(*) FROM products WHERE deleted_at IS NULL"
 	// Usa QueryRowContext para passar o contexto
 	err := db.QueryRowContext(ctx, query).Scan(&count)
 	if err != nil {
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// defaultCurrency é usada quando o produto não informa currency
+const defaultCurrency = "BRL"
+
+// maxPriceIntegerDigits acompanha a coluna price DECIMAL(15,3): até 12 dígitos antes da vírgula
+const maxPriceIntegerDigits = 12
+
+// currencyMinorUnits: moedas ISO 4217 aceitas e quantas casas decimais cada uma permite
+var currencyMinorUnits = map[string]int{
+	"BRL": 2,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"ARS": 2,
+	"MXN": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CLP": 0,
+	"JPY": 0,
+	"KRW": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+// money é um valor decimal exato: units * 10^-scale (ex: units 1050 e scale 2 = 10.50).
+// Nunca passa por float64: é lido do JSON e do MySQL como texto e gravado como texto.
+type money struct {
+	units int64
+	scale int
+}
+
+// parseMoney interpreta um decimal como "10", "10.5" ou "-3.25". Notação exponencial não é aceita.
+func parseMoney(s string) (money, error) {
+	invalid := fmt.Errorf("invalid decimal value %q", s)
+	digits := strings.TrimPrefix(s, "-")
+	intPart, fracPart, hasPoint := strings.Cut(digits, ".")
+	if intPart == "" || (hasPoint && fracPart == "") || len(intPart)+len(fracPart) > 18 {
+		return money{}, invalid
+	}
+	for _, c := range intPart + fracPart {
+		if c < '0' || c > '9' {
+			return money{}, invalid
+		}
+	}
+	units, err := strconv.ParseInt(intPart+fracPart, 10, 64)
+	if err != nil {
+		return money{}, invalid
+	}
+	if strings.HasPrefix(s, "-") {
+		units = -units
+	}
+	return money{units: units, scale: len(fracPart)}, nil
+}
+
+// String formata o valor com exatamente scale casas decimais
+func (m money) String() string {
+	s := strconv.FormatInt(m.units, 10)
+	sign := ""
+	if m.units < 0 {
+		sign, s = "-", s[1:]
+	}
+	if m.scale == 0 {
+		return sign + s
+	}
+	if len(s) <= m.scale {
+		s = strings.Repeat("0", m.scale-len(s)+1) + s
+	}
+	return sign + s[:len(s)-m.scale] + "." + s[len(s)-m.scale:]
+}
+
+func (m money) isNegative() bool {
+	return m.units < 0
+}
+
+// cmp compara dois valores: -1 se m < o, 0 se iguais, 1 se m > o
+func (m money) cmp(o money) int {
+	for m.scale < o.scale {
+		m.units *= 10
+		m.scale++
+	}
+	for o.scale < m.scale {
+		o.units *= 10
+		o.scale++
+	}
+	switch {
+	case m.units < o.units:
+		return -1
+	case m.units > o.units:
+		return 1
+	}
+	return 0
+}
+
+// integerDigits conta os dígitos da parte inteira
+func (m money) integerDigits() int {
+	intPart, _, _ := strings.Cut(strings.TrimPrefix(m.String(), "-"), ".")
+	return len(strings.TrimLeft(intPart, "0"))
+}
+
+// rescale muda o número de casas decimais. Só descarta zeros: dígitos significativos
+// além de scale continuam lá (a validação garante que isso não acontece com preços gravados).
+func (m money) rescale(scale int) money {
+	for m.scale < scale {
+		m.units *= 10
+		m.scale++
+	}
+	for m.scale > scale && m.units%10 == 0 {
+		m.units /= 10
+		m.scale--
+	}
+	return m
+}
+
+// MarshalJSON devolve o valor como string JSON ("10.50"), para que nenhum cliente o leia como float
+func (m money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON aceita string ("10.50") ou número (10.50); o número é lido pelo texto, sem float64
+func (m *money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	text := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+	}
+	parsed, err := parseMoney(text)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// MarshalText devolve o mesmo texto do JSON, usado nas respostas em XML e MessagePack
+func (m money) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// EncodeMsgpack escreve o valor como string do MessagePack, como no JSON (o MarshalText viraria bin)
+func (m money) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return enc.EncodeString(m.String())
+}
+
+// UnmarshalText lê o texto do valor ("10.50"), como nos corpos em XML
+func (m *money) UnmarshalText(text []byte) error {
+	parsed, err := parseMoney(string(text))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan lê uma coluna DECIMAL, que o driver do MySQL entrega como texto
+func (m *money) Scan(src interface{}) error {
+	var text string
+	switch v := src.(type) {
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	case int64:
+		*m = money{units: v}
+		return nil
+	default:
+		return fmt.Errorf("tipo %T não suportado para money", src)
+	}
+	parsed, err := parseMoney(text)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Value grava o valor como texto; o MySQL converte para DECIMAL sem arredondamento
+func (m money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// currencyCode devolve a moeda do produto, ou a padrão se não informada
+func (p product) currencyCode() string {
+	if p.Currency == "" {
+		return defaultCurrency
+	}
+	return p.Currency
+}
+
+// normalizePrice ajusta o preço lido do banco (3 casas na coluna) às casas decimais da moeda
+func (p *product) normalizePrice() {
+	if digits, ok := currencyMinorUnits[p.currencyCode()]; ok {
+		p.Price = p.Price.rescale(digits)
+	}
+}
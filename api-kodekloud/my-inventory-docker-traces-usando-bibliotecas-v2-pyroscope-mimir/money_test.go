@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in      string
+		units   int64
+		scale   int
+		wantErr bool
+	}{
+		{in: "10", units: 10},
+		{in: "10.5", units: 105, scale: 1},
+		{in: "10.50", units: 1050, scale: 2},
+		{in: "-3.25", units: -325, scale: 2},
+		{in: "0.001", units: 1, scale: 3},
+		{in: "999999999999.999", units: 999999999999999, scale: 3},
+		{in: "", wantErr: true},
+		{in: "-", wantErr: true},
+		{in: ".5", wantErr: true},
+		{in: "1.", wantErr: true},
+		{in: "+1", wantErr: true},
+		{in: "1e3", wantErr: true},
+		{in: "1,50", wantErr: true},
+		{in: "1.2.3", wantErr: true},
+		{in: " 1", wantErr: true},
+		{in: "1234567890123456789", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseMoney(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseMoney(%q) = %+v, esperado erro", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("erro inesperado: %v", err)
+			}
+			if got.units != tt.units || got.scale != tt.scale {
+				t.Errorf("parseMoney(%q) = %+v, esperado units %d e scale %d", tt.in, got, tt.units, tt.scale)
+			}
+			if got.String() != tt.in {
+				t.Errorf("String() = %s, esperado %s", got, tt.in)
+			}
+		})
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		m    money
+		want string
+	}{
+		{money{units: 5, scale: 2}, "0.05"},
+		{money{units: -5, scale: 2}, "-0.05"},
+		{money{units: 0, scale: 2}, "0.00"},
+		{money{units: 1200}, "1200"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("%+v.String() = %s, esperado %s", tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyRescale(t *testing.T) {
+	tests := []struct {
+		in    string
+		scale int
+		want  string
+	}{
+		{in: "10.500", scale: 2, want: "10.50"},
+		{in: "10", scale: 2, want: "10.00"},
+		{in: "10.5", scale: 3, want: "10.500"},
+		{in: "1500.000", scale: 0, want: "1500"},
+		// Dígitos significativos não são descartados
+		{in: "10.505", scale: 2, want: "10.505"},
+		{in: "10.550", scale: 0, want: "10.55"},
+		{in: "-2.100", scale: 2, want: "-2.10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			m, _ := parseMoney(tt.in)
+			if got := m.rescale(tt.scale).String(); got != tt.want {
+				t.Errorf("rescale(%d) = %s, esperado %s", tt.scale, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyCmp(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "10", b: "10.00", want: 0},
+		{a: "10.5", b: "10.49", want: 1},
+		{a: "9.999", b: "10", want: -1},
+		{a: "-1", b: "0.01", want: -1},
+		{a: "0", b: "-0.001", want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" vs "+tt.b, func(t *testing.T) {
+			a, _ := parseMoney(tt.a)
+			b, _ := parseMoney(tt.b)
+			if got := a.cmp(b); got != tt.want {
+				t.Errorf("cmp = %d, esperado %d", got, tt.want)
+			}
+			if got := b.cmp(a); got != -tt.want {
+				t.Errorf("cmp invertido = %d, esperado %d", got, -tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	for _, body := range []string{`"10.50"`, `10.50`} {
+		var m money
+		if err := json.Unmarshal([]byte(body), &m); err != nil {
+			t.Fatalf("%s: %v", body, err)
+		}
+		out, _ := json.Marshal(m)
+		if string(out) != `"10.50"` {
+			t.Errorf("%s: MarshalJSON = %s", body, out)
+		}
+	}
+	var m money
+	if err := json.Unmarshal([]byte(`1e2`), &m); err == nil {
+		t.Errorf("notação exponencial aceita: %s", m)
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	for _, src := range []interface{}{[]byte("12.340"), "12.340"} {
+		var m money
+		if err := m.Scan(src); err != nil || m.String() != "12.340" {
+			t.Errorf("Scan(%v) = %s, %v", src, m, err)
+		}
+	}
+	var m money
+	if err := m.Scan(int64(7)); err != nil || m.String() != "7" {
+		t.Errorf("Scan(int64) = %s, %v", m, err)
+	}
+	if err := m.Scan(1.5); err == nil {
+		t.Error("Scan(float64) aceito")
+	}
+}
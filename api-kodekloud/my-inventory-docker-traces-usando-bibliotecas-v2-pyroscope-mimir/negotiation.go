@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/munnerz/goautoneg"
+	"github.com/sirupsen/logrus"
+	"github.com/vmihailenco/msgpack/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Formatos das respostas de sucesso, escolhidos pelo Accept. Os erros continuam em problem+json.
+const (
+	formatJSON    = "json"
+	formatXML     = "xml"
+	formatCSV     = "csv" // só nas listas
+	formatMsgpack = "msgpack"
+)
+
+// Media types de cada formato
+const (
+	xmlContentType     = "application/xml"
+	csvContentType     = "text/csv"
+	msgpackContentType = "application/msgpack"
+)
+
+// responseContentTypes é o Content-Type devolvido em cada formato
+var responseContentTypes = map[string]string{
+	formatJSON:    "application/json",
+	formatXML:     xmlContentType + "; charset=utf-8",
+	formatCSV:     csvContentType + "; charset=utf-8",
+	formatMsgpack: msgpackContentType,
+}
+
+// acceptedMediaTypes são os media types aceitos no Accept, em ordem de preferência do servidor
+// (usada no empate e nos curingas: */* dá JSON)
+var acceptedMediaTypes = []struct {
+	mediaType string
+	format    string
+}{
+	{"application/json", formatJSON},
+	{xmlContentType, formatXML},
+	{"text/xml", formatXML},
+	{msgpackContentType, formatMsgpack},
+	{"application/vnd.msgpack", formatMsgpack},
+	{"application/x-msgpack", formatMsgpack},
+	{csvContentType, formatCSV},
+}
+
+// requestBodyAliases leva os nomes alternativos ao media type documentado no OpenAPI
+var requestBodyAliases = map[string]string{
+	"text/xml":                xmlContentType,
+	"application/vnd.msgpack": msgpackContentType,
+	"application/x-msgpack":   msgpackContentType,
+}
+
+// negotiateFormat escolhe o formato da resposta pelo Accept (RFC 9110, 12.5.1).
+// Sem Accept a resposta é JSON; ok=false quando nenhum formato disponível é aceito.
+func negotiateFormat(accept string, list bool) (format string, ok bool) {
+	if strings.TrimSpace(accept) == "" {
+		return formatJSON, true
+	}
+	clauses := goautoneg.ParseAccept(accept)
+	refused := map[string]bool{}
+	for _, clause := range clauses {
+		if clause.Q == 0 {
+			refused[clause.Type+"/"+clause.SubType] = true
+		}
+	}
+	for _, clause := range clauses {
+		if clause.Q == 0 {
+			continue
+		}
+		for _, accepted := range acceptedMediaTypes {
+			if accepted.format == formatCSV && !list || refused[accepted.mediaType] {
+				continue
+			}
+			mainType, subType, _ := strings.Cut(accepted.mediaType, "/")
+			if (clause.Type == "*" || clause.Type == mainType) && (clause.SubType == "*" || clause.SubType == subType) {
+				return accepted.format, true
+			}
+		}
+	}
+	return "", false
+}
+
+type responseFormatKey struct{}
+
+// responseFormatFromContext devolve o formato negociado para a requisição (JSON por padrão)
+func responseFormatFromContext(ctx context.Context) string {
+	if format, ok := ctx.Value(responseFormatKey{}).(string); ok {
+		return format
+	}
+	return formatJSON
+}
+
+// negotiableOperation diz se a operação responde JSON no sucesso, pelo sendResponse
+func negotiableOperation(op openAPIOperation) bool {
+	for status, res := range op.Responses {
+		if _, ok := res.Content["application/json"]; ok && strings.HasPrefix(status, "2") {
+			return true
+		}
+	}
+	return false
+}
+
+// listOperation diz se a resposta de sucesso da operação é uma lista (array ou página): só essas têm CSV
+func listOperation(op openAPIOperation) bool {
+	media, ok := op.Responses["200"].Content["application/json"]
+	if !ok {
+		return false
+	}
+	_, page := v2PageItems[strings.TrimPrefix(media.Schema.Ref, "#/components/schemas/")]
+	return page || media.Schema.Type == "array"
+}
+
+// formatsDescription lista os media types de resposta de uma operação, para o detail do 406
+func formatsDescription(list bool) string {
+	types := []string{"application/json", xmlContentType, msgpackContentType}
+	if list {
+		types = append(types, csvContentType)
+	}
+	return strings.Join(types, ", ")
+}
+
+// contentNegotiationMiddleware escolhe o formato da resposta pelo Accept (406 se nenhum serve)
+// e converte para JSON os corpos em XML ou MessagePack das operações que os aceitam (415 nos demais
+// media types). Assim a validação do OpenAPI e os handlers continuam lendo só JSON.
+func contentNegotiationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, version, _ := routeContract(r.Method, template)
+		op, ok := openAPIOperations[key]
+		if !ok || version == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if negotiableOperation(op) {
+			w.Header().Add("Vary", "Accept")
+			list := listOperation(op)
+			format, ok := negotiateFormat(r.Header.Get("Accept"), list)
+			if !ok {
+				sendError(w, r, http.StatusNotAcceptable, fmt.Errorf("none of the media types in Accept is available; use %s", formatsDescription(list)))
+				return
+			}
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("http.response.format", format))
+			r = r.WithContext(context.WithValue(r.Context(), responseFormatKey{}, format))
+		}
+
+		if op.RequestBody != nil {
+			if _, ok := op.RequestBody.Content["application/json"]; ok {
+				if status, err := convertRequestBody(r, op.RequestBody); err != nil {
+					sendError(w, r, status, err)
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// convertRequestBody confere o Content-Type do corpo com o contrato e converte XML e MessagePack para JSON.
+// Sem Content-Type o corpo é lido como JSON; os demais media types (inclusive o form-urlencoded
+// que o curl -d manda por padrão) recebem 415.
+func convertRequestBody(r *http.Request, body *openAPIRequestBody) (int, error) {
+	mediaType := ""
+	if header := r.Header.Get("Content-Type"); header != "" {
+		parsed, _, err := mime.ParseMediaType(header)
+		if err != nil {
+			return http.StatusUnsupportedMediaType, fmt.Errorf("invalid Content-Type %q", header)
+		}
+		mediaType = parsed
+	}
+	if alias, ok := requestBodyAliases[mediaType]; ok {
+		mediaType = alias
+	}
+
+	switch {
+	case mediaType == "", mediaType == "application/json":
+		return 0, nil
+	case mediaType != xmlContentType && mediaType != msgpackContentType, body.Content[mediaType].Schema == nil:
+		return http.StatusUnsupportedMediaType, fmt.Errorf("Content-Type %s is not supported; use %s", mediaType, strings.Join(sortedKeys(body.Content), ", "))
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxValidatedBodySize+1))
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("could not read the request body: %w", err)
+	}
+	if len(data) > maxValidatedBodySize {
+		return http.StatusBadRequest, errors.New("request body is too large")
+	}
+
+	var converted []byte
+	if mediaType == xmlContentType {
+		var p productXMLBody
+		if err := xml.Unmarshal(data, &p); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid XML body: %w", err)
+		}
+		converted, err = json.Marshal(p)
+	} else {
+		var value interface{}
+		if err := msgpack.Unmarshal(data, &value); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid MessagePack body: %w", err)
+		}
+		converted, err = json.Marshal(value)
+	}
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("could not convert the request body to JSON: %w", err)
+	}
+
+	logWithTrace(r.Context()).WithFields(logrus.Fields{
+		"component":    "content_negotiation",
+		"content_type": mediaType,
+		"bytes":        len(data),
+	}).Debug("Corpo da requisição convertido para JSON")
+	r.Body = io.NopCloser(bytes.NewReader(converted))
+	r.ContentLength = int64(len(converted))
+	r.Header.Set("Content-Type", "application/json")
+	return 0, nil
+}
+
+// --- Escrita das respostas ---
+
+// writeResponseBody escreve data no formato negociado. XML e MessagePack usam as tags xml e json
+// dos tipos de resposta; o CSV parte do JSON da resposta, então segue as mesmas tags json.
+func writeResponseBody(w io.Writer, format string, data interface{}) error {
+	var buf bytes.Buffer
+	switch format {
+	case formatJSON:
+		return json.NewEncoder(w).Encode(data)
+	case formatXML:
+		buf.WriteString(xml.Header)
+		if err := xml.NewEncoder(&buf).EncodeElement(xmlValue(data), xml.StartElement{Name: xml.Name{Local: "response"}}); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+	case formatCSV:
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		value, err := decodeOrderedJSON(raw)
+		if err != nil {
+			return err
+		}
+		if err := writeCSVRows(&buf, value); err != nil {
+			return err
+		}
+	case formatMsgpack:
+		encoder := msgpack.NewEncoder(&buf)
+		encoder.SetCustomStructTag("json")
+		encoder.UseCompactInts(true)
+		if err := encoder.Encode(data); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("formato de resposta desconhecido: %s", format)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// xmlItems é uma lista no XML, com um <item> por valor
+type xmlItems struct {
+	Items interface{} `xml:"item"`
+}
+
+// xmlValue põe as listas em xmlItems; os demais valores já têm as tags xml
+func xmlValue(data interface{}) interface{} {
+	if v := reflect.ValueOf(data); v.Kind() == reflect.Slice {
+		return xmlItems{Items: data}
+	}
+	return data
+}
+
+// productXMLBody é o corpo de produto em XML (<product><sku>NB-001</sku>...</product>; o nome da
+// raiz não importa). Os campos são ponteiros para que os elementos ausentes também fiquem de fora
+// do JSON e a validação do OpenAPI aponte os mesmos erros do corpo em JSON.
+type productXMLBody struct {
+	SKU              *string `xml:"sku" json:"sku,omitempty"`
+	Name             *string `xml:"name" json:"name,omitempty"`
+	Quantity         *int    `xml:"quantity" json:"quantity,omitempty"`
+	Price            *string `xml:"price" json:"price,omitempty"`
+	Currency         *string `xml:"currency" json:"currency,omitempty"`
+	CategoryID       *int    `xml:"category_id" json:"category_id,omitempty"`
+	ReorderThreshold *int    `xml:"reorder_threshold" json:"reorder_threshold,omitempty"`
+}
+
+// orderedObject é um objeto JSON com os campos na ordem do documento (a do struct de origem)
+type orderedObject []orderedField
+
+type orderedField struct {
+	Key   string
+	Value interface{}
+}
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.Key)
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// decodeOrderedJSON decodifica JSON em orderedObject, []interface{}, string, json.Number, bool e nil
+func decodeOrderedJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decodeOrderedValue(decoder)
+}
+
+func decodeOrderedValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return token, nil
+	}
+	switch delim {
+	case '{':
+		obj := orderedObject{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, orderedField{Key: key.(string), Value: value})
+		}
+		_, err = decoder.Token() // '}'
+		return obj, err
+	case '[':
+		items := []interface{}{}
+		for decoder.More() {
+			item, err := decodeOrderedValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		_, err = decoder.Token() // ']'
+		return items, err
+	}
+	return nil, fmt.Errorf("delimitador JSON inesperado: %v", delim)
+}
+
+// csvItemFields são os campos de itens das páginas da v1
+var csvItemFields = func() map[string]bool {
+	fields := map[string]bool{}
+	for _, field := range v2PageItems {
+		fields[field] = true
+	}
+	return fields
+}()
+
+// csvItems acha os itens de uma lista: o próprio array, o data do envelope da v2 ou o campo de
+// itens das páginas da v1
+func csvItems(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		return v
+	case orderedObject:
+		for _, field := range v {
+			items, ok := field.Value.([]interface{})
+			if ok && (field.Key == "data" || csvItemFields[field.Key]) {
+				return items
+			}
+		}
+	}
+	return []interface{}{value}
+}
+
+// writeCSVRows escreve uma linha por item, com as colunas na ordem em que aparecem nos itens.
+// Objetos e arrays aninhados vão como JSON na célula; null vira célula vazia.
+func writeCSVRows(w io.Writer, value interface{}) error {
+	items := csvItems(value)
+	var columns []string
+	index := map[string]int{}
+	for _, item := range items {
+		obj, ok := item.(orderedObject)
+		if !ok {
+			obj = orderedObject{{Key: "value", Value: item}}
+		}
+		for _, field := range obj {
+			if _, ok := index[field.Key]; !ok {
+				index[field.Key] = len(columns)
+				columns = append(columns, field.Key)
+			}
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+	for _, item := range items {
+		obj, ok := item.(orderedObject)
+		if !ok {
+			obj = orderedObject{{Key: "value", Value: item}}
+		}
+		row := make([]string, len(columns))
+		for _, field := range obj {
+			cell, err := csvCell(field.Value)
+			if err != nil {
+				return err
+			}
+			row[index[field.Key]] = cell
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func csvCell(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		raw, err := json.Marshal(v)
+		return string(raw), err
+	}
+}
+
+// --- OpenAPI ---
+
+// negotiatedOperation documenta na operação os formatos de resposta do Accept, o 406 e, nas
+// operações com corpo JSON, o 415
+func negotiatedOperation(op openAPIOperation) openAPIOperation {
+	if op.RequestBody != nil {
+		if _, ok := op.RequestBody.Content["application/json"]; ok {
+			if _, ok := op.Responses["415"]; !ok {
+				op.Responses = withResponse(op.Responses, "415", errorResponse("Content-Type não suportado pela operação"))
+			}
+		}
+	}
+	if !negotiableOperation(op) {
+		return op
+	}
+
+	list := listOperation(op)
+	responses := make(map[string]openAPIResponse, len(op.Responses)+1)
+	for status, res := range op.Responses {
+		if media, ok := res.Content["application/json"]; ok && strings.HasPrefix(status, "2") {
+			content := map[string]openAPIMediaType{
+				"application/json": media,
+				xmlContentType:     media,
+				msgpackContentType: media,
+			}
+			if list && status == "200" {
+				content[csvContentType] = openAPIMediaType{Schema: stringSchema("Uma linha por item; objetos aninhados vão como JSON na célula")}
+			}
+			res.Content = content
+		}
+		responses[status] = res
+	}
+	responses["406"] = errorResponse("Nenhum media type do Accept disponível (" + formatsDescription(list) + ")")
+	op.Responses = responses
+	return op
+}
+
+// withResponse copia responses com mais uma resposta, sem alterar o mapa de openAPIOperations
+func withResponse(responses map[string]openAPIResponse, status string, res openAPIResponse) map[string]openAPIResponse {
+	copied := make(map[string]openAPIResponse, len(responses)+1)
+	for s, r := range responses {
+		copied[s] = r
+	}
+	copied[status] = res
+	return copied
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		list   bool
+		want   string
+		wantOK bool
+	}{
+		{accept: "", want: formatJSON, wantOK: true},
+		{accept: "*/*", want: formatJSON, wantOK: true},
+		{accept: "application/json", want: formatJSON, wantOK: true},
+		{accept: "application/xml", want: formatXML, wantOK: true},
+		{accept: "text/xml", want: formatXML, wantOK: true},
+		{accept: "application/x-msgpack", want: formatMsgpack, wantOK: true},
+		{accept: "text/csv", list: true, want: formatCSV, wantOK: true},
+		{accept: "text/csv", wantOK: false},
+		{accept: "text/html", wantOK: false},
+		{accept: "application/json;q=0.5, application/xml", want: formatXML, wantOK: true},
+		{accept: "application/*;q=0.8, application/json;q=0", want: formatXML, wantOK: true},
+		{accept: "text/*", list: true, want: formatXML, wantOK: true}, // text/xml vem antes de text/csv na preferência
+		{accept: "application/json;q=0", wantOK: false},
+		{accept: "text/html, */*;q=0.1", want: formatJSON, wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			got, ok := negotiateFormat(tt.accept, tt.list)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("negotiateFormat(%q, %v) = %q, %v; esperado %q, %v", tt.accept, tt.list, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// Os corpos chegam ao handler sempre em JSON; media types fora do contrato recebem 415
+func TestContentNegotiationRequestBody(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(contentNegotiationMiddleware)
+	router.HandleFunc("/v1/product", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		w.Write(body)
+	}).Methods("POST")
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		contains    string
+	}{
+		{name: "sem Content-Type", body: `{"sku":"NB-1"}`, status: http.StatusOK, contains: `"sku":"NB-1"`},
+		{name: "JSON", contentType: "application/json; charset=utf-8", body: `{"sku":"NB-1"}`, status: http.StatusOK, contains: `"sku":"NB-1"`},
+		{name: "XML", contentType: "text/xml", body: `<product><sku>NB-3</sku><quantity>5</quantity></product>`, status: http.StatusOK, contains: `"sku":"NB-3"`},
+		{name: "MessagePack", contentType: "application/x-msgpack", body: "\x82\xa3sku\xa4NB-4\xa8quantity\x05", status: http.StatusOK, contains: `"sku":"NB-4"`},
+		{name: "XML inválido", contentType: "application/xml", body: `<product><sku>`, status: http.StatusBadRequest},
+		{name: "form-urlencoded do curl -d", contentType: "application/x-www-form-urlencoded", body: `{"sku":"NB-1"}`, status: http.StatusUnsupportedMediaType},
+		{name: "multipart", contentType: "multipart/form-data; boundary=x", body: `--x--`, status: http.StatusUnsupportedMediaType},
+		{name: "texto", contentType: "text/plain", body: `sku`, status: http.StatusUnsupportedMediaType},
+		{name: "Content-Type inválido", contentType: "application/", body: `{}`, status: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/product", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, esperado %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+					t.Errorf("Content-Type = %s", ct)
+				}
+				return
+			}
+			if !strings.Contains(w.Body.String(), tt.contains) {
+				t.Errorf("corpo no handler = %s, esperado %s", w.Body, tt.contains)
+			}
+		})
+	}
+}
+
+// XML e MessagePack seguem as tags dos tipos de resposta: listas em <item> e price como string
+func TestWriteResponseBody(t *testing.T) {
+	price, _ := parseMoney("4500.00")
+	p := product{ID: 1, SKU: "NB-1", Name: "Notebook", Quantity: 5, Price: price, Currency: "BRL", Version: 1}
+
+	tests := []struct {
+		name     string
+		format   string
+		data     interface{}
+		contains string
+	}{
+		{name: "XML do produto", format: formatXML, data: p, contains: `<response><id>1</id><sku>NB-1</sku>`},
+		{name: "XML sem category_id nulo", format: formatXML, data: p, contains: `<reorder_threshold>0</reorder_threshold><version>1</version></response>`},
+		{name: "XML da lista", format: formatXML, data: []product{p}, contains: `<response><item><id>1</id>`},
+		{name: "XML da página", format: formatXML, data: lowStockPage{Products: []product{p}, pageLimits: pageLimits{Limit: 10}}, contains: `<products><item><id>1</id>`},
+		{name: "XML do envelope da v2", format: formatXML, data: envelopeV2(productPage{Products: []product{p}, Total: 1, Limit: 10}), contains: `<data><item><id>1</id>`},
+		{name: "CSV da página", format: formatCSV, data: productPage{Products: []product{p}, Total: 1}, contains: "1,NB-1,Notebook,5,0,0,4500.00,BRL,,0,1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeResponseBody(&buf, tt.format, tt.data); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(buf.String(), tt.contains) {
+				t.Errorf("corpo = %s, esperado %s", buf.String(), tt.contains)
+			}
+		})
+	}
+
+	t.Run("MessagePack", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := writeResponseBody(&buf, formatMsgpack, p); err != nil {
+			t.Fatal(err)
+		}
+		var decoded map[string]interface{}
+		if err := msgpack.Unmarshal(buf.Bytes(), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded["price"] != "4500.00" || decoded["sku"] != "NB-1" {
+			t.Errorf("decodificado = %v", decoded)
+		}
+	})
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+// openAPISchema é o subconjunto do Schema Object do OpenAPI 3.0 usado pela API.
+// O mesmo schema descreve o contrato em /openapi.json, que o kin-openapi usa para validar os corpos das requisições.
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	ReadOnly             bool                      `json:"readOnly,omitempty"`
+	Enum                 []interface{}             `json:"enum,omitempty"`
+	Minimum              *float64                  `json:"minimum,omitempty"`
+	Maximum              *float64                  `json:"maximum,omitempty"`
+	MinLength            *int                      `json:"minLength,omitempty"`
+	MaxLength            *int                      `json:"maxLength,omitempty"`
+	Pattern              string                    `json:"pattern,omitempty"`
+	MinItems             *int                      `json:"minItems,omitempty"`
+	MaxItems             *int                      `json:"maxItems,omitempty"`
+	UniqueItems          bool                      `json:"uniqueItems,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	AdditionalProperties interface{}               `json:"additionalProperties,omitempty"` // false ou *openAPISchema
+	OneOf                []*openAPISchema          `json:"oneOf,omitempty"`
+	Example              interface{}               `json:"example,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"` // path, query ou header
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required,omitempty"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIHeader struct {
+	Description string         `json:"description,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Headers     map[string]openAPIHeader    `json:"headers,omitempty"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIOperation struct {
+	Tags        []string                   `json:"tags,omitempty"`
+	Summary     string                     `json:"summary"`
+	Description string                     `json:"description,omitempty"`
+	OperationID string                     `json:"operationId,omitempty"`
+	Deprecated  bool                       `json:"deprecated,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+// --- Construtores de schema, usados em openapi_operations.go ---
+
+func schemaRef(name string) *openAPISchema {
+	return &openAPISchema{Ref: "#/components/schemas/" + name}
+}
+
+func stringSchema(description string) *openAPISchema {
+	return &openAPISchema{Type: "string", Description: description}
+}
+
+func integerSchema(description string) *openAPISchema {
+	return &openAPISchema{Type: "integer", Description: description}
+}
+
+func booleanSchema(description string) *openAPISchema {
+	return &openAPISchema{Type: "boolean", Description: description}
+}
+
+func dateTimeSchema(description string) *openAPISchema {
+	return &openAPISchema{Type: "string", Format: "date-time", Description: description}
+}
+
+func arraySchema(items *openAPISchema) *openAPISchema {
+	return &openAPISchema{Type: "array", Items: items}
+}
+
+// objectSchema não aceita campos fora de properties, como o DisallowUnknownFields dos handlers
+func objectSchema(required []string, properties map[string]*openAPISchema) *openAPISchema {
+	return &openAPISchema{Type: "object", Required: required, Properties: properties, AdditionalProperties: false}
+}
+
+func (s *openAPISchema) min(v float64) *openAPISchema { s.Minimum = &v; return s }
+func (s *openAPISchema) max(v float64) *openAPISchema { s.Maximum = &v; return s }
+func (s *openAPISchema) minLen(n int) *openAPISchema  { s.MinLength = &n; return s }
+func (s *openAPISchema) maxLen(n int) *openAPISchema  { s.MaxLength = &n; return s }
+func (s *openAPISchema) minItems(n int) *openAPISchema {
+	s.MinItems = &n
+	return s
+}
+func (s *openAPISchema) maxItems(n int) *openAPISchema {
+	s.MaxItems = &n
+	return s
+}
+func (s *openAPISchema) readOnly() *openAPISchema { s.ReadOnly = true; return s }
+func (s *openAPISchema) nullable() *openAPISchema { s.Nullable = true; return s }
+func (s *openAPISchema) pattern(re *regexp.Regexp) *openAPISchema {
+	s.Pattern = re.String()
+	return s
+}
+func (s *openAPISchema) enum(values ...string) *openAPISchema {
+	for _, v := range values {
+		s.Enum = append(s.Enum, v)
+	}
+	return s
+}
+
+// sortedKeys devolve as chaves do mapa em ordem, para enums gerados a partir das regras da aplicação
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// --- Geração do documento ---
+
+// routeParamPattern encontra as variáveis do template de rota do mux: {id:[0-9]+} ou {sku}
+var routeParamPattern = regexp.MustCompile(`\{([^}:]+)(?::([^}]+))?\}`)
+
+// openAPIPath converte o template do mux (/product/{id:[0-9]+}) no path do OpenAPI (/product/{id})
+func openAPIPath(template string) string {
+	return routeParamPattern.ReplaceAllString(template, "{$1}")
+}
+
+// openAPIPathParameters gera os parâmetros de path a partir das expressões do template
+func openAPIPathParameters(template string) []openAPIParameter {
+	var params []openAPIParameter
+	for _, m := range routeParamPattern.FindAllStringSubmatch(template, -1) {
+		schema := stringSchema("")
+		switch m[2] {
+		case "[0-9]+":
+			schema = integerSchema("").min(1)
+		case "":
+		default:
+			schema.Pattern = "^" + m[2] + "$"
+		}
+		params = append(params, openAPIParameter{Name: m[1], In: "path", Required: true, Schema: schema})
+	}
+	return params
+}
+
+// handlerName devolve o nome do método do App que atende a rota (vira o operationId)
+func handlerName(h http.Handler) string {
+	name := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+	name = strings.TrimSuffix(name, "-fm")
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// buildOpenAPISpec gera o documento OpenAPI percorrendo as rotas registradas no router.
+// Cada rota precisa de uma entrada em openAPIOperations, e cada entrada precisa de uma rota:
+// qualquer diferença é devolvida como erro, e a aplicação não sobe com o contrato desatualizado.
+func buildOpenAPISpec(router *mux.Router) ([]byte, error) {
+	paths := map[string]map[string]openAPIOperation{}
+	documented := map[string]bool{}
+	var missing []string
+
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		if route.GetHandler() == nil {
+			return nil // PathPrefix dos subrouters /v1 e /v2
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return fmt.Errorf("rota %s sem método HTTP: %w", template, err)
+		}
+		path := openAPIPath(template)
+		for _, method := range methods {
+			key, version, legacy := routeContract(method, template)
+			op, ok := openAPIOperations[key]
+			if !ok {
+				missing = append(missing, method+" "+path)
+				continue
+			}
+			documented[key] = true
+			op.OperationID = handlerName(route.GetHandler())
+			switch {
+			case legacy:
+				op.OperationID += "Legacy"
+				op.Deprecated = true
+				op.Description = "Legado: use /" + apiV1 + path + ". Responde com os headers Deprecation e Sunset."
+			case version == apiV2:
+				op.OperationID += "V2"
+				op = v2Operation(op)
+			}
+			if version != "" {
+				op = negotiatedOperation(op)
+			}
+			op.Parameters = append(openAPIPathParameters(template), op.Parameters...)
+			if paths[path] == nil {
+				paths[path] = map[string]openAPIOperation{}
+			}
+			paths[path][strings.ToLower(method)] = op
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range sortedKeys(openAPIOperations) {
+		if !documented[key] {
+			missing = append(missing, key+" (documentada, mas sem rota)")
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("OpenAPI fora de sincronia com as rotas: %s", strings.Join(missing, ", "))
+	}
+
+	spec := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   "my-inventory API",
+			"version": "2.0.0",
+			"description": "API de inventário de produtos, categorias, depósitos, reservas e webhooks. " +
+				"/v1 é o contrato atual; /v2 usa recursos no plural e envelope {data, meta} nas respostas de sucesso; " +
+				"as rotas sem prefixo são o legado da v1.",
+		},
+		"tags":       openAPITags,
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": openAPISchemas},
+	}
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+// v2Operation adapta a operação da v1 para a v2: as respostas JSON de sucesso vão no envelope
+func v2Operation(op openAPIOperation) openAPIOperation {
+	responses := make(map[string]openAPIResponse, len(op.Responses))
+	for status, res := range op.Responses {
+		if media, ok := res.Content["application/json"]; ok && strings.HasPrefix(status, "2") {
+			envelope := map[string]*openAPISchema{"data": media.Schema}
+			if field, ok := v2PageItems[strings.TrimPrefix(media.Schema.Ref, "#/components/schemas/")]; ok {
+				page := openAPISchemas[strings.TrimPrefix(media.Schema.Ref, "#/components/schemas/")]
+				envelope = map[string]*openAPISchema{"data": page.Properties[field], "meta": schemaRef("PageMeta")}
+			}
+			res.Content = map[string]openAPIMediaType{"application/json": {Schema: objectSchema([]string{"data"}, envelope)}}
+		}
+		responses[status] = res
+	}
+	op.Responses = responses
+	return op
+}
+
+// serveOpenAPI devolve o documento gerado na inicialização
+func (app *App) serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(app.OpenAPI)
+}
+
+// docsPage é a página de documentação (docs/index.html): o Swagger UI apontado para /openapi.json.
+// Os assets do swagger-ui-dist vêm do swaggo/files e vão embutidos no binário, sem depender de CDN.
+//
+//go:embed docs/index.html
+var docsPage []byte
+
+// serveDocs devolve a página de documentação
+func (app *App) serveDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(docsPage)
+}
+
+// serveDocsAsset devolve o CSS, o JS e os ícones do Swagger UI
+func (app *App) serveDocsAsset(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["asset"]
+	data, err := fs.ReadFile(swaggerFiles.FS, name)
+	if err != nil {
+		sendError(w, r, http.StatusNotFound, fmt.Errorf("documentation asset %q not found", name))
+		return
+	}
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(name)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// --- Validação das requisições ---
+
+// maxValidatedBodySize acompanha o maior MaxBytesReader dos handlers (lote de produtos)
+const maxValidatedBodySize = 10_485_760
+
+// fieldError é a falha de validação de um campo do corpo ("operations[2].product.sku")
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// loadOpenAPIDoc carrega o documento gerado por buildOpenAPISpec no kin-openapi, que valida os
+// corpos das requisições. O documento também é validado, então um schema inválido impede a subida.
+func loadOpenAPIDoc(spec []byte) (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(spec)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func init() {
+	// format: uri não tem validador padrão no kin-openapi (date-time já tem)
+	openapi3.DefineStringFormatCallback("uri", func(value string) error {
+		u, err := url.ParseRequestURI(value)
+		if err != nil || u.Host == "" {
+			return errors.New("must be an absolute URI")
+		}
+		return nil
+	})
+}
+
+// openAPIValidationMiddleware valida o corpo JSON das requisições contra o schema da operação no
+// documento OpenAPI (openapi3filter), antes de chegar ao handler. Rotas sem corpo JSON no contrato passam direto.
+func (app *App) openAPIValidationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil || app.openAPIDoc == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		path := app.openAPIDoc.Paths.Value(openAPIPath(template))
+		if path == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		op := path.GetOperation(r.Method)
+		if op == nil || op.RequestBody == nil || op.RequestBody.Value.Content.Get("application/json") == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxValidatedBodySize+1))
+		// O handler recebe o corpo inteiro de novo, inclusive o que passou do limite
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		if err != nil || len(body) > maxValidatedBodySize {
+			// O MaxBytesReader do handler responde
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Sem Content-Type o corpo é lido como JSON, como nos handlers
+		req := r.Clone(r.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		err = openapi3filter.ValidateRequestBody(r.Context(), &openapi3filter.RequestValidationInput{
+			Request: req,
+			Options: &openapi3filter.Options{
+				MultiError:                 true,
+				ExcludeReadOnlyValidations: true, // o PUT aceita o produto como veio do GET
+				SkipSettingDefaults:        true,
+			},
+		}, op.RequestBody.Value)
+		if err != nil {
+			errs := openAPIFieldErrors(err)
+			logWithTrace(r.Context()).WithFields(logrus.Fields{
+				"component": "openapi_validation",
+				"route":     r.Method + " " + template,
+				"errors":    len(errs),
+			}).Warn("Requisição rejeitada pela validação do OpenAPI")
+			err := withProblemType(problemValidation, "Validation failed", errors.New("request body does not match the API spec"))
+			p := newProblem(r, http.StatusBadRequest, err)
+			p.Errors = errs
+			sendProblem(w, r, p, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// openAPIFieldErrors converte o erro do openapi3filter em uma entrada {field, message} por violação
+func openAPIFieldErrors(err error) []fieldError {
+	var errs []fieldError
+	var visit func(err error)
+	visit = func(err error) {
+		switch e := err.(type) {
+		case openapi3.MultiError:
+			for _, inner := range e {
+				visit(inner)
+			}
+		case *openapi3filter.RequestError:
+			switch {
+			case errors.Is(e.Err, openapi3filter.ErrInvalidRequired):
+				errs = append(errs, fieldError{Field: "", Message: "request body is required"})
+			case e.Reason == "failed to decode request body":
+				errs = append(errs, fieldError{Field: "", Message: "request body must be valid JSON"})
+			case e.Err != nil:
+				visit(e.Err)
+			default:
+				errs = append(errs, fieldError{Field: "", Message: e.Reason})
+			}
+		case *openapi3.SchemaError:
+			field := jsonPointerField(e.JSONPointer())
+			if e.SchemaField == "properties" && strings.HasSuffix(e.Reason, " is unsupported") {
+				// O erro aponta o objeto; o campo desconhecido só aparece no Reason (property "color" is unsupported)
+				name, err := strconv.Unquote(strings.TrimSuffix(strings.TrimPrefix(e.Reason, "property "), " is unsupported"))
+				if err == nil {
+					field = jsonPointerField(append(e.JSONPointer(), name))
+				}
+			}
+			errs = append(errs, fieldError{Field: field, Message: e.Reason})
+		default:
+			errs = append(errs, fieldError{Field: "", Message: err.Error()})
+		}
+	}
+	visit(err)
+	return errs
+}
+
+// jsonPointerField monta o nome do campo para as mensagens: ["operations", "2", "sku"] vira "operations[2].sku"
+func jsonPointerField(pointer []string) string {
+	var field strings.Builder
+	for _, part := range pointer {
+		if _, err := strconv.Atoi(part); err == nil {
+			field.WriteString("[" + part + "]")
+			continue
+		}
+		if field.Len() > 0 {
+			field.WriteByte('.')
+		}
+		field.WriteString(part)
+	}
+	return field.String()
+}
@@ -0,0 +1,836 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Contrato da API REST. Cada rota de App.HandleRequests tem uma entrada em openAPIOperations,
+// com a chave "MÉTODO /path" no formato do OpenAPI; os parâmetros de path e o operationId
+// são gerados a partir da rota em buildOpenAPISpec.
+
+var openAPITags = []map[string]string{
+	{"name": "products", "description": "Produtos, lixeira, histórico, lote, CSV e stream de alterações"},
+	{"name": "stock", "description": "Movimentações, depósitos e transferências"},
+	{"name": "reservations", "description": "Reservas de estoque com prazo"},
+	{"name": "images", "description": "Imagens de produto e miniaturas"},
+	{"name": "categories", "description": "Categorias de produto"},
+	{"name": "audit", "description": "Trilha de auditoria das escritas"},
+	{"name": "webhooks", "description": "Inscrições de webhook e dead-letter list"},
+	{"name": "system", "description": "Health check e documentação"},
+}
+
+// productProperties são os campos do produto, compartilhados por Product e ProductRevision
+func productProperties() map[string]*openAPISchema {
+	return map[string]*openAPISchema{
+		"id":        integerSchema("").readOnly(),
+		"sku":       stringSchema("Código único do produto, inclusive entre os produtos na lixeira").pattern(skuPattern),
+		"name":      stringSchema("Letras, números, espaço e . , : ; / ( ) & + ' \" # % * _ -, sem espaços nas pontas").minLen(1).maxLen(maxProductNameLength),
+		"quantity":  integerSchema("Soma do estoque dos depósitos").min(0).max(maxProductQuantity),
+		"reserved":  integerSchema("Unidades reservadas").readOnly(),
+		"available": integerSchema("quantity - reserved").readOnly(),
+		"price": {
+			Description: `a decimal string ("3500.00") or number`,
+			OneOf: []*openAPISchema{
+				stringSchema("").pattern(moneyPattern),
+				{Type: "number", Minimum: floatPtr(0)},
+			},
+			Example: "3500.00",
+		},
+		"currency":          stringSchema("ISO 4217; padrão " + defaultCurrency).enum(sortedKeys(currencyMinorUnits)...),
+		"category_id":       integerSchema("").min(1).nullable(),
+		"reorder_threshold": integerSchema("Abaixo deste quantity o produto entra em GET /products/low-stock (0 desliga)").min(0).max(maxProductQuantity),
+		"version":           integerSchema("Versão do produto, também devolvida no ETag").readOnly(),
+		"deleted_at":        dateTimeSchema("Preenchido só para produtos na lixeira").readOnly().nullable(),
+	}
+}
+
+func floatPtr(v float64) *float64 { return &v }
+
+// openAPISchemas são os components/schemas do documento
+var openAPISchemas = map[string]*openAPISchema{
+	"Problem": objectSchema([]string{"type", "title", "status"}, map[string]*openAPISchema{
+		"type":     stringSchema("URI do tipo do erro, ex: /problems/not-found"),
+		"title":    stringSchema(""),
+		"status":   integerSchema(""),
+		"detail":   stringSchema(""),
+		"instance": stringSchema("Path da requisição"),
+		"trace_id": stringSchema("Trace da requisição no Tempo"),
+		"errors": {
+			Type:        "array",
+			Description: "Violações do validation-error, uma por mensagem; um campo pode aparecer mais de uma vez",
+			Items:       schemaRef("FieldError"),
+		},
+	}),
+	"FieldError": objectSchema([]string{"field", "message"}, map[string]*openAPISchema{
+		"field":   stringSchema("Campo do corpo, ex: operations[2].product.sku"),
+		"message": stringSchema(""),
+	}),
+	"PageMeta": objectSchema(nil, map[string]*openAPISchema{
+		"total":       integerSchema("Só nas listas de produtos"),
+		"limit":       integerSchema(""),
+		"offset":      integerSchema(""),
+		"next_cursor": stringSchema(""),
+	}),
+	"Result": objectSchema([]string{"result"}, map[string]*openAPISchema{
+		"result":  stringSchema(""),
+		"message": stringSchema(""),
+	}),
+	"Health": objectSchema(nil, map[string]*openAPISchema{
+		"status":   stringSchema(""),
+		"database": stringSchema(""),
+	}),
+
+	"Product": objectSchema([]string{"sku", "name"}, productProperties()),
+	"ProductPatch": objectSchema(nil, map[string]*openAPISchema{
+		"sku":               stringSchema("").pattern(skuPattern),
+		"name":              stringSchema("").minLen(1),
+		"quantity":          integerSchema("").min(0),
+		"price":             productProperties()["price"],
+		"currency":          stringSchema("").enum(sortedKeys(currencyMinorUnits)...),
+		"category_id":       integerSchema("null remove a categoria").min(1).nullable(),
+		"reorder_threshold": integerSchema("").min(0),
+	}),
+	"ProductPage": objectSchema(nil, map[string]*openAPISchema{
+		"products":    arraySchema(schemaRef("Product")),
+		"total":       integerSchema(""),
+		"limit":       integerSchema(""),
+		"offset":      integerSchema(""),
+		"next_cursor": stringSchema("Presente quando há mais páginas; envie em ?cursor="),
+	}),
+	"ProductRevision": func() *openAPISchema {
+		props := productProperties()
+		props["operation"] = stringSchema("").enum("create", "update", "delete", "restore")
+		props["changed_at"] = dateTimeSchema("")
+		return objectSchema(nil, props)
+	}(),
+	"RevisionPage": objectSchema(nil, map[string]*openAPISchema{
+		"revisions": arraySchema(schemaRef("ProductRevision")),
+		"limit":     integerSchema(""),
+		"offset":    integerSchema(""),
+	}),
+	"LowStockPage": objectSchema(nil, map[string]*openAPISchema{
+		"products": arraySchema(schemaRef("Product")),
+		"limit":    integerSchema(""),
+		"offset":   integerSchema(""),
+	}),
+
+	"ProductImage": objectSchema(nil, map[string]*openAPISchema{
+		"id":                     integerSchema("").readOnly(),
+		"product_id":             integerSchema("").readOnly(),
+		"content_type":           stringSchema("").enum(sortedKeys(imageFormats)...),
+		"size_bytes":             integerSchema("").max(maxImageSize),
+		"width":                  integerSchema(""),
+		"height":                 integerSchema(""),
+		"url":                    stringSchema("Arquivo original"),
+		"thumbnail_url":          stringSchema(fmt.Sprintf("Miniatura com no máximo %dpx no maior lado", thumbnailMaxSide)),
+		"thumbnail_content_type": stringSchema("").enum("image/jpeg", "image/png"),
+		"created_at":             dateTimeSchema(""),
+	}),
+
+	"BulkOperation": objectSchema([]string{"op"}, map[string]*openAPISchema{
+		"op":      stringSchema("").enum("create", "update", "delete"),
+		"id":      integerSchema("Obrigatório em update e delete").min(1),
+		"version": integerSchema("Versão esperada (opcional), como o If-Match").min(0),
+		"product": schemaRef("Product"),
+	}),
+	"BulkRequest": objectSchema([]string{"operations"}, map[string]*openAPISchema{
+		"mode":       stringSchema("Padrão "+bulkModeAtomic).enum(bulkModeAtomic, bulkModeBestEffort),
+		"operations": arraySchema(schemaRef("BulkOperation")).minItems(1).maxItems(maxBulkOperations),
+	}),
+	"BulkResult": objectSchema(nil, map[string]*openAPISchema{
+		"index":   integerSchema(""),
+		"op":      stringSchema(""),
+		"id":      integerSchema(""),
+		"status":  integerSchema("Status HTTP equivalente da operação"),
+		"error":   stringSchema(""),
+		"product": schemaRef("Product"),
+	}),
+	"BulkResponse": objectSchema(nil, map[string]*openAPISchema{
+		"mode":      stringSchema(""),
+		"committed": booleanSchema(""),
+		"succeeded": integerSchema(""),
+		"failed":    integerSchema(""),
+		"results":   arraySchema(schemaRef("BulkResult")),
+	}),
+
+	"ImportRowError": objectSchema(nil, map[string]*openAPISchema{
+		"row":   integerSchema(""),
+		"sku":   stringSchema(""),
+		"error": stringSchema(""),
+	}),
+	"ImportReport": objectSchema(nil, map[string]*openAPISchema{
+		"dry_run":         booleanSchema(""),
+		"committed":       booleanSchema(""),
+		"rows":            integerSchema(""),
+		"created":         integerSchema(""),
+		"updated":         integerSchema(""),
+		"failed":          integerSchema(""),
+		"ignored_columns": arraySchema(stringSchema("")),
+		"errors":          arraySchema(schemaRef("ImportRowError")),
+	}),
+
+	"StockMovement": objectSchema([]string{"type", "quantity", "reason"}, map[string]*openAPISchema{
+		"id":         integerSchema("").readOnly(),
+		"product_id": integerSchema("").readOnly(),
+		"type": stringSchema("adjust aceita quantity negativa; os demais exigem quantity positiva").
+			enum(stockAdjust, stockReceive, stockShip, stockReserve, stockRelease),
+		"location":       stringSchema("Depósito (padrão MAIN); não vale para reserve e release").pattern(locationCodePattern),
+		"quantity":       integerSchema(""),
+		"reason":         stringSchema("Código snake_case").pattern(reasonPattern),
+		"quantity_after": integerSchema("").readOnly(),
+		"reserved_after": integerSchema("").readOnly(),
+		"created_at":     dateTimeSchema("").readOnly(),
+	}),
+	"MovementPage": objectSchema(nil, map[string]*openAPISchema{
+		"movements": arraySchema(schemaRef("StockMovement")),
+		"limit":     integerSchema(""),
+		"offset":    integerSchema(""),
+	}),
+	"Location": objectSchema([]string{"code", "name"}, map[string]*openAPISchema{
+		"id":   integerSchema("").readOnly(),
+		"code": stringSchema("").pattern(locationCodePattern),
+		"name": stringSchema("").minLen(1).maxLen(100),
+	}),
+	"LocationStock": objectSchema(nil, map[string]*openAPISchema{
+		"location": stringSchema(""),
+		"quantity": integerSchema(""),
+	}),
+	"ProductStock": objectSchema(nil, map[string]*openAPISchema{
+		"product_id": integerSchema(""),
+		"quantity":   integerSchema(""),
+		"locations":  arraySchema(schemaRef("LocationStock")),
+	}),
+	"Transfer": objectSchema([]string{"from", "to", "quantity", "reason"}, map[string]*openAPISchema{
+		"product_id": integerSchema("").readOnly(),
+		"from":       stringSchema("").minLen(1),
+		"to":         stringSchema("").minLen(1),
+		"quantity":   integerSchema("").min(1),
+		"reason":     stringSchema("Código snake_case").pattern(reasonPattern),
+	}),
+
+	"Reservation": objectSchema([]string{"quantity"}, map[string]*openAPISchema{
+		"id":          integerSchema("").readOnly(),
+		"product_id":  integerSchema("").readOnly(),
+		"quantity":    integerSchema("").min(1),
+		"status":      stringSchema("").readOnly(),
+		"ttl_seconds": integerSchema("Prazo da reserva; 0 usa o padrão de " + strconv.Itoa(int(defaultReservationTTL.Seconds())) + "s").min(0).max(maxReservationTTL.Seconds()),
+		"expires_at":  dateTimeSchema("").readOnly(),
+		"created_at":  dateTimeSchema("").readOnly(),
+	}),
+
+	"Category": objectSchema([]string{"name"}, map[string]*openAPISchema{
+		"id":          integerSchema("").readOnly(),
+		"name":        stringSchema("").minLen(1).maxLen(100),
+		"description": stringSchema(""),
+	}),
+
+	"FieldChange": {Type: "object", Properties: map[string]*openAPISchema{
+		"before": {Description: "Valor antes da escrita"},
+		"after":  {Description: "Valor depois da escrita"},
+	}},
+	"AuditEvent": objectSchema(nil, map[string]*openAPISchema{
+		"id":         integerSchema(""),
+		"product_id": integerSchema(""),
+		"actor":      stringSchema(""),
+		"action":     stringSchema(""),
+		"diff":       {Type: "object", AdditionalProperties: schemaRef("FieldChange")},
+		"trace_id":   stringSchema(""),
+		"created_at": dateTimeSchema(""),
+	}),
+	"AuditPage": objectSchema(nil, map[string]*openAPISchema{
+		"events": arraySchema(schemaRef("AuditEvent")),
+		"limit":  integerSchema(""),
+		"offset": integerSchema(""),
+	}),
+
+	"WebhookSubscription": objectSchema([]string{"url", "events"}, map[string]*openAPISchema{
+		"id":  integerSchema("").readOnly(),
+		"url": {Type: "string", Format: "uri", Description: "URL http ou https", MaxLength: intPtr(2048)},
+		"events": func() *openAPISchema {
+			s := arraySchema(stringSchema("").enum(sortedKeys(webhookEvents)...)).minItems(1)
+			s.UniqueItems = true
+			return s
+		}(),
+		"secret": stringSchema("Gerado se ausente; só aparece na resposta da criação").
+			minLen(webhookMinSecretLength).maxLen(128),
+		"active":     booleanSchema("Padrão true"),
+		"created_at": dateTimeSchema("").readOnly(),
+	}),
+	"WebhookDelivery": objectSchema(nil, map[string]*openAPISchema{
+		"id":               integerSchema(""),
+		"subscription_id":  integerSchema(""),
+		"event":            stringSchema(""),
+		"product_id":       integerSchema(""),
+		"status":           stringSchema("").enum(deliveryPending, deliveryDelivered, deliveryDead),
+		"attempts":         integerSchema(""),
+		"last_status_code": integerSchema(""),
+		"last_error":       stringSchema(""),
+		"created_at":       dateTimeSchema(""),
+		"updated_at":       dateTimeSchema(""),
+	}),
+	"DeliveryPage": objectSchema(nil, map[string]*openAPISchema{
+		"deliveries": arraySchema(schemaRef("WebhookDelivery")),
+		"limit":      integerSchema(""),
+		"offset":     integerSchema(""),
+	}),
+}
+
+func intPtr(v int) *int { return &v }
+
+// moneyPattern é o decimal aceito por parseMoney, sem sinal (preços não podem ser negativos)
+var moneyPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// --- Parâmetros e respostas comuns ---
+
+func queryParam(name, description string, schema *openAPISchema) openAPIParameter {
+	return openAPIParameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+var limitOffsetParams = []openAPIParameter{
+	queryParam("limit", "", integerSchema("").min(1).max(maxProductsLimit)),
+	queryParam("offset", "", integerSchema("").min(0)),
+}
+
+// productQueryParams são os filtros, a ordenação e a paginação de parseProductQuery
+var productQueryParams = append([]openAPIParameter{
+	queryParam("name", "Busca parcial no nome", stringSchema("")),
+	queryParam("category", "Nome da categoria", stringSchema("")),
+	queryParam("currency", "Código ISO 4217", stringSchema("")),
+	queryParam("min_price", "Na moeda de currency (padrão "+defaultCurrency+")", stringSchema("").pattern(moneyPattern)),
+	queryParam("max_price", "Na moeda de currency (padrão "+defaultCurrency+")", stringSchema("").pattern(moneyPattern)),
+	queryParam("min_quantity", "", integerSchema("").min(0)),
+	queryParam("sort", fmt.Sprintf("Campos separados por vírgula, com - para ordem decrescente (%v)", sortedKeys(productSortColumns)),
+		&openAPISchema{Type: "string", Example: "-price,name"}),
+	queryParam("cursor", "next_cursor da página anterior; não pode ser usado com offset", stringSchema("")),
+}, limitOffsetParams...)
+
+var ifMatchParam = openAPIParameter{
+	Name: "If-Match", In: "header", Required: true,
+	Description: `ETag do GET /product/{id} ("<version>") ou *`,
+	Schema:      stringSchema(""),
+}
+
+func jsonBody(schema *openAPISchema) *openAPIRequestBody {
+	return &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{"application/json": {Schema: schema}}}
+}
+
+// productBody é o corpo de criação e substituição do produto, aceito em JSON, XML ou MessagePack
+func productBody() *openAPIRequestBody {
+	body := jsonBody(schemaRef("Product"))
+	body.Content[xmlContentType] = openAPIMediaType{Schema: schemaRef("Product")}
+	body.Content[msgpackContentType] = openAPIMediaType{Schema: schemaRef("Product")}
+	return body
+}
+
+func jsonResponse(description string, schema *openAPISchema) openAPIResponse {
+	return openAPIResponse{Description: description, Content: map[string]openAPIMediaType{"application/json": {Schema: schema}}}
+}
+
+func errorResponse(description string) openAPIResponse {
+	return openAPIResponse{Description: description, Content: map[string]openAPIMediaType{problemContentType: {Schema: schemaRef("Problem")}}}
+}
+
+// productResponse é a resposta com o produto e o ETag com a versão
+func productResponse(description string) openAPIResponse {
+	res := jsonResponse(description, schemaRef("Product"))
+	res.Headers = map[string]openAPIHeader{"ETag": {Description: "Versão do produto", Schema: stringSchema("")}}
+	return res
+}
+
+// binaryResponse é o arquivo de uma imagem, em um dos tipos aceitos no upload
+func binaryResponse(description string, contentTypes ...string) openAPIResponse {
+	content := map[string]openAPIMediaType{}
+	for _, contentType := range contentTypes {
+		content[contentType] = openAPIMediaType{Schema: &openAPISchema{Type: "string", Format: "binary"}}
+	}
+	return openAPIResponse{Description: description, Content: content}
+}
+
+var validationFailed = errorResponse("Corpo inválido: validation-error (com errors por campo) ou regras do handler")
+
+var internalError = errorResponse("Erro interno")
+
+// openAPIOperations descreve cada rota registrada em App.HandleRequests
+var openAPIOperations = map[string]openAPIOperation{
+	// Produtos
+	"GET /products": {
+		Tags: []string{"products"}, Summary: "Lista produtos com filtros, ordenação e paginação",
+		Parameters: productQueryParams,
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Página de produtos", schemaRef("ProductPage")),
+			"400": errorResponse("Parâmetro inválido"),
+			"500": internalError,
+		},
+	},
+	"GET /product/{id}": {
+		Tags: []string{"products"}, Summary: "Busca um produto",
+		Parameters: []openAPIParameter{
+			queryParam("as_of", "Devolve a revisão do produto neste instante (RFC3339)", dateTimeSchema("")),
+		},
+		Responses: map[string]openAPIResponse{
+			"200": productResponse("Produto (ou ProductRevision com as_of)"),
+			"400": errorResponse("as_of inválido"),
+			"404": errorResponse("Produto não encontrado"),
+			"500": internalError,
+		},
+	},
+	"GET /product/sku/{sku}": {
+		Tags: []string{"products"}, Summary: "Busca um produto pelo SKU",
+		Responses: map[string]openAPIResponse{
+			"200": productResponse("Produto"),
+			"404": errorResponse("Produto não encontrado"),
+			"500": internalError,
+		},
+	},
+	"POST /product": {
+		Tags: []string{"products"}, Summary: "Cria um produto",
+		Parameters: []openAPIParameter{{
+			Name: idempotencyKeyHeader, In: "header",
+			Description: "Repetições com a mesma chave devolvem a resposta original",
+			Schema:      stringSchema("").maxLen(maxIdempotencyKeyLength),
+		}},
+		RequestBody: productBody(),
+		Responses: map[string]openAPIResponse{
+			"201": productResponse("Produto criado (ou repetição com Idempotent-Replayed: true)"),
+			"400": validationFailed,
+			"409": errorResponse("SKU já existe, ou a Idempotency-Key venceu durante a requisição"),
+			"422": errorResponse("Idempotency-Key usada com outro payload"),
+			"500": internalError,
+		},
+	},
+	"PUT /product/{id}": {
+		Tags: []string{"products"}, Summary: "Substitui um produto",
+		Parameters:  []openAPIParameter{ifMatchParam},
+		RequestBody: productBody(),
+		Responses: map[string]openAPIResponse{
+			"200": productResponse("Produto atualizado"),
+			"400": validationFailed,
+			"404": errorResponse("Produto não encontrado"),
+			"409": errorResponse("SKU já existe, ou quantity menor que o reserved ou que o estoque dos outros depósitos"),
+			"412": errorResponse("If-Match não confere com a versão atual"),
+			"428": errorResponse("If-Match ausente"),
+			"500": internalError,
+		},
+	},
+	"PATCH /product/{id}": {
+		Tags: []string{"products"}, Summary: "Atualiza campos de um produto (JSON Merge Patch)",
+		Parameters: []openAPIParameter{ifMatchParam},
+		RequestBody: &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{
+			mergePatchContentType: {Schema: schemaRef("ProductPatch")},
+		}},
+		Responses: map[string]openAPIResponse{
+			"200": productResponse("Produto atualizado"),
+			"400": errorResponse("Patch inválido"),
+			"404": errorResponse("Produto não encontrado"),
+			"409": errorResponse("SKU já existe, ou quantity menor que o reserved ou que o estoque dos outros depósitos"),
+			"412": errorResponse("If-Match não confere com a versão atual"),
+			"415": errorResponse("Content-Type diferente de " + mergePatchContentType),
+			"428": errorResponse("If-Match ausente"),
+			"500": internalError,
+		},
+	},
+	"DELETE /product/{id}": {
+		Tags: []string{"products"}, Summary: "Move um produto para a lixeira",
+		Parameters: []openAPIParameter{ifMatchParam},
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Produto na lixeira", schemaRef("Result")),
+			"404": errorResponse("Produto não encontrado"),
+			"412": errorResponse("If-Match não confere com a versão atual"),
+			"428": errorResponse("If-Match ausente"),
+			"500": internalError,
+		},
+	},
+	"POST /product/{id}/restore": {
+		Tags: []string{"products"}, Summary: "Restaura um produto da lixeira",
+		Description: "Dispara o evento product.restored para webhooks e para o stream de alterações",
+		Responses: map[string]openAPIResponse{
+			"200": productResponse("Produto restaurado"),
+			"404": errorResponse("Produto não está na lixeira"),
+			"500": internalError,
+		},
+	},
+	"GET /product/{id}/history": {
+		Tags: []string{"products"}, Summary: "Lista as revisões de um produto",
+		Parameters: limitOffsetParams,
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Revisões, da mais recente para a mais antiga", schemaRef("RevisionPage")),
+			"400": errorResponse("Parâmetro inválido"),
+			"404": errorResponse("Produto não encontrado"),
+			"500": internalError,
+		},
+	},
+	"POST /products/bulk": {
+		Tags: []string{"products"}, Summary: "Cria, atualiza e exclui produtos em lote",
+		RequestBody: jsonBody(schemaRef("BulkRequest")),
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Todas as operações foram aplicadas", schemaRef("BulkResponse")),
+			"400": validationFailed,
+			"422": jsonResponse("Alguma operação falhou (em atomic, nada foi gravado)", schemaRef("BulkResponse")),
+			"500": internalError,
+		},
+	},
+	"GET /products/trash": {
+		Tags: []string{"products"}, Summary: "Lista os produtos na lixeira",
+		Parameters: productQueryParams,
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Página de produtos excluídos", schemaRef("ProductPage")),
+			"400": errorResponse("Parâmetro inválido"),
+			"500": internalError,
+		},
+	},
+	"GET /products/low-stock": {
+		Tags: []string{"products"}, Summary: "Lista os produtos abaixo do ponto de reposição",
+		Parameters: limitOffsetParams,
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Produtos com estoque baixo", schemaRef("LowStockPage")),
+			"400": errorResponse("Parâmetro inválido"),
+			"500": internalError,
+		},
+	},
+	"GET " + productStreamPath: {
+		Tags: []string{"products"}, Summary: "Stream (SSE) de produtos criados, atualizados e excluídos",
+		Parameters: []openAPIParameter{
+			{Name: "Last-Event-ID", In: "header", Description: "Retoma a partir deste evento", Schema: stringSchema("")},
+			queryParam("last_event_id", "O mesmo que o header Last-Event-ID", stringSchema("")),
+		},
+		Responses: map[string]openAPIResponse{
+			"200": {Description: "Eventos product.created, product.updated, product.deleted, product.restored e stream.reset", Content: map[string]openAPIMediaType{
+				"text/event-stream": {Schema: stringSchema("")},
+			}},
+			"500": internalError,
+		},
+	},
+	"GET /products/export.csv": {
+		Tags: []string{"products"}, Summary: "Exporta os produtos em CSV",
+		Parameters: productQueryParams,
+		Responses: map[string]openAPIResponse{
+			"200": {Description: "CSV com cabeçalho", Content: map[string]openAPIMediaType{"text/csv": {Schema: stringSchema("")}}},
+			"400": errorResponse("Parâmetro inválido"),
+			"500": internalError,
+		},
+	},
+	"POST /products/import": {
+		Tags: []string{"products"}, Summary: "Importa produtos de um CSV (upsert por SKU, tudo ou nada)",
+		Parameters: []openAPIParameter{
+			queryParam("dry_run", "Valida sem gravar", booleanSchema("")),
+			queryParam("map", "Renomeia colunas: coluna_do_arquivo:campo,...", stringSchema("")),
+			queryParam("delimiter", "", stringSchema("Padrão ,").enum(",", ";")),
+		},
+		RequestBody: &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{
+			"text/csv": {Schema: stringSchema("")},
+		}},
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Import gravado (ou dry-run sem erros)", schemaRef("ImportReport")),
+			"400": errorResponse("Arquivo ou parâmetro inválido"),
+			"422": jsonResponse("Alguma linha falhou; nada foi gravado", schemaRef("ImportReport")),
+			"500": internalError,
+		},
+	},
+
+	// Estoque e depósitos
+	"POST /product/{id}/stock": {
+		Tags: []string{"stock"}, Summary: "Registra uma movimentação de estoque",
+		RequestBody: jsonBody(schemaRef("StockMovement")),
+		Responses: map[string]openAPIResponse{
+			"201": jsonResponse("Movimentação registrada", schemaRef("StockMovement")),
+			"400": validationFailed,
+			"404": errorResponse("Produto não encontrado"),
+			"409": errorResponse("Estoque insuficiente, ou release de unidades seguradas por reservas ativas"),
+			"500": internalError,
+		},
+	},
+	"GET /product/{id}/movements": {
+		Tags: []string{"stock"}, Summary: "Lista as movimentações de estoque de um produto",
+		Parameters: limitOffsetParams,
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Movimentações", schemaRef("MovementPage")),
+			"400": errorResponse("Parâmetro inválido"),
+			"404": errorResponse("Produto não encontrado"),
+			"500": internalError,
+		},
+	},
+	"GET /product/{id}/stock": {
+		Tags: []string{"stock"}, Summary: "Mostra o estoque do produto por depósito",
+		Parameters: []openAPIParameter{queryParam("location", "Filtra um depósito", stringSchema("").pattern(locationCodePattern))},
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Estoque por depósito", schemaRef("ProductStock")),
+			"404": errorResponse("Produto ou depósito não encontrado"),
+			"500": internalError,
+		},
+	},
+	"POST /product/{id}/transfers": {
+		Tags: []string{"stock"}, Summary: "Transfere estoque entre depósitos",
+		RequestBody: jsonBody(schemaRef("Transfer")),
+		Responses: map[string]openAPIResponse{
+			"201": jsonResponse("Transferência registrada", schemaRef("Transfer")),
+			"400": validationFailed,
+			"404": errorResponse("Produto não encontrado"),
+			"409": errorResponse("Estoque insuficiente no depósito de origem"),
+			"500": internalError,
+		},
+	},
+	"GET /locations": {
+		Tags: []string{"stock"}, Summary: "Lista os depósitos",
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Depósitos", arraySchema(schemaRef("Location"))),
+			"500": internalError,
+		},
+	},
+	"POST /location": {
+		Tags: []string{"stock"}, Summary: "Cria um depósito",
+		RequestBody: jsonBody(schemaRef("Location")),
+		Responses: map[string]openAPIResponse{
+			"201": jsonResponse("Depósito criado", schemaRef("Location")),
+			"400": validationFailed,
+			"409": errorResponse("Código já existe"),
+			"500": internalError,
+		},
+	},
+
+	// Reservas
+	"POST /product/{id}/reservations": {
+		Tags: []string{"reservations"}, Summary: "Reserva estoque com prazo",
+		RequestBody: jsonBody(schemaRef("Reservation")),
+		Responses: map[string]openAPIResponse{
+			"201": jsonResponse("Reserva criada", schemaRef("Reservation")),
+			"400": validationFailed,
+			"404": errorResponse("Produto não encontrado"),
+			"409": errorResponse("Estoque disponível insuficiente"),
+			"500": internalError,
+		},
+	},
+	"GET /reservation/{id}": {
+		Tags: []string{"reservations"}, Summary: "Busca uma reserva",
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Reserva", schemaRef("Reservation")),
+			"404": errorResponse("Reserva não encontrada"),
+			"500": internalError,
+		},
+	},
+	"POST /reservation/{id}/confirm": {
+		Tags: []string{"reservations"}, Summary: "Confirma a reserva (baixa o estoque)",
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Reserva confirmada", schemaRef("Reservation")),
+			"404": errorResponse("Reserva não encontrada"),
+			"409": errorResponse("Reserva expirada (reservation-expired) ou não está ativa, ou estoque insuficiente"),
+			"500": internalError,
+		},
+	},
+	"POST /reservation/{id}/cancel": {
+		Tags: []string{"reservations"}, Summary: "Cancela a reserva (devolve ao estoque disponível)",
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Reserva cancelada", schemaRef("Reservation")),
+			"404": errorResponse("Reserva não encontrada"),
+			"409": errorResponse("Reserva expirada (reservation-expired) ou não está ativa"),
+			"500": internalError,
+		},
+	},
+
+	// Imagens de produto
+	"POST /product/{id}/images": {
+		Tags: []string{"images"}, Summary: "Envia uma imagem do produto (JPEG, PNG ou GIF) e gera a miniatura",
+		RequestBody: &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{
+			"multipart/form-data": {Schema: objectSchema([]string{"image"}, map[string]*openAPISchema{
+				"image": {Type: "string", Format: "binary", Description: fmt.Sprintf("Até %d bytes e %d pixels", maxImageSize, maxImagePixels)},
+			})},
+		}},
+		Responses: map[string]openAPIResponse{
+			"201": jsonResponse("Imagem gravada; Location aponta para o arquivo", schemaRef("ProductImage")),
+			"400": errorResponse("Corpo multipart inválido, campo image ausente ou imagem corrompida"),
+			"404": errorResponse("Produto não encontrado"),
+			"409": errorResponse(fmt.Sprintf("O produto já tem %d imagens", maxImagesPerProduct)),
+			"413": errorResponse("Arquivo maior que o limite"),
+			"415": errorResponse("Corpo não é multipart/form-data, ou o arquivo não é JPEG, PNG ou GIF"),
+			"500": internalError,
+		},
+	},
+	"GET /product/{id}/images": {
+		Tags: []string{"images"}, Summary: "Lista as imagens do produto",
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Imagens", arraySchema(schemaRef("ProductImage"))),
+			"404": errorResponse("Produto não encontrado"),
+			"500": internalError,
+		},
+	},
+	"GET /product/{id}/images/{image_id}": {
+		Tags: []string{"images"}, Summary: "Baixa o arquivo original da imagem",
+		Responses: map[string]openAPIResponse{
+			"200": binaryResponse("Arquivo original", sortedKeys(imageFormats)...),
+			"404": errorResponse("Imagem não encontrada"),
+			"500": internalError,
+		},
+	},
+	"GET /product/{id}/images/{image_id}/thumbnail": {
+		Tags: []string{"images"}, Summary: "Baixa a miniatura da imagem",
+		Responses: map[string]openAPIResponse{
+			"200": binaryResponse("Miniatura", "image/jpeg", "image/png"),
+			"404": errorResponse("Imagem não encontrada"),
+			"500": internalError,
+		},
+	},
+	"DELETE /product/{id}/images/{image_id}": {
+		Tags: []string{"images"}, Summary: "Exclui a imagem e seus arquivos",
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Imagem excluída", schemaRef("Result")),
+			"404": errorResponse("Imagem não encontrada"),
+			"500": internalError,
+		},
+	},
+
+	// Categorias
+	"GET /categories": {
+		Tags: []string{"categories"}, Summary: "Lista as categorias",
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Categorias", arraySchema(schemaRef("Category"))),
+			"500": internalError,
+		},
+	},
+	"GET /category/{id}": {
+		Tags: []string{"categories"}, Summary: "Busca uma categoria",
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Categoria", schemaRef("Category")),
+			"404": errorResponse("Categoria não encontrada"),
+			"500": internalError,
+		},
+	},
+	"POST /category": {
+		Tags: []string{"categories"}, Summary: "Cria uma categoria",
+		RequestBody: jsonBody(schemaRef("Category")),
+		Responses: map[string]openAPIResponse{
+			"201": jsonResponse("Categoria criada", schemaRef("Category")),
+			"400": validationFailed,
+			"409": errorResponse("Nome já existe"),
+			"500": internalError,
+		},
+	},
+	"PUT /category/{id}": {
+		Tags: []string{"categories"}, Summary: "Atualiza uma categoria",
+		RequestBody: jsonBody(schemaRef("Category")),
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Categoria atualizada", schemaRef("Category")),
+			"400": validationFailed,
+			"404": errorResponse("Categoria não encontrada"),
+			"409": errorResponse("Nome já existe"),
+			"500": internalError,
+		},
+	},
+	"DELETE /category/{id}": {
+		Tags: []string{"categories"}, Summary: "Exclui uma categoria sem produtos",
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Categoria excluída", schemaRef("Result")),
+			"404": errorResponse("Categoria não encontrada"),
+			"409": errorResponse("Categoria ainda tem produtos"),
+			"500": internalError,
+		},
+	},
+
+	// Auditoria
+	"GET /audit": {
+		Tags: []string{"audit"}, Summary: "Lista os eventos de auditoria",
+		Parameters: append([]openAPIParameter{
+			queryParam("product_id", "", integerSchema("").min(1)),
+			queryParam("actor", "", stringSchema("")),
+			queryParam("since", "RFC3339", dateTimeSchema("")),
+		}, limitOffsetParams...),
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Eventos, do mais recente para o mais antigo", schemaRef("AuditPage")),
+			"400": errorResponse("Parâmetro inválido"),
+			"500": internalError,
+		},
+	},
+
+	// Webhooks
+	"GET /webhooks": {
+		Tags: []string{"webhooks"}, Summary: "Lista as inscrições",
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Inscrições (sem o secret)", arraySchema(schemaRef("WebhookSubscription"))),
+			"500": internalError,
+		},
+	},
+	"GET /webhook/{id}": {
+		Tags: []string{"webhooks"}, Summary: "Busca uma inscrição",
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Inscrição (sem o secret)", schemaRef("WebhookSubscription")),
+			"404": errorResponse("Inscrição não encontrada"),
+			"500": internalError,
+		},
+	},
+	"POST /webhook": {
+		Tags: []string{"webhooks"}, Summary: "Cria uma inscrição",
+		RequestBody: jsonBody(schemaRef("WebhookSubscription")),
+		Responses: map[string]openAPIResponse{
+			"201": jsonResponse("Inscrição criada, com o secret", schemaRef("WebhookSubscription")),
+			"400": validationFailed,
+			"500": internalError,
+		},
+	},
+	"PUT /webhook/{id}": {
+		Tags: []string{"webhooks"}, Summary: "Atualiza uma inscrição",
+		RequestBody: jsonBody(schemaRef("WebhookSubscription")),
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Inscrição atualizada", schemaRef("WebhookSubscription")),
+			"400": validationFailed,
+			"404": errorResponse("Inscrição não encontrada"),
+			"500": internalError,
+		},
+	},
+	"DELETE /webhook/{id}": {
+		Tags: []string{"webhooks"}, Summary: "Exclui uma inscrição",
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Inscrição excluída", schemaRef("Result")),
+			"404": errorResponse("Inscrição não encontrada"),
+			"500": internalError,
+		},
+	},
+	"GET /webhooks/dead-letters": {
+		Tags: []string{"webhooks"}, Summary: "Lista as entregas que esgotaram as tentativas",
+		Parameters: limitOffsetParams,
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("Entregas na dead-letter list", schemaRef("DeliveryPage")),
+			"400": errorResponse("Parâmetro inválido"),
+			"500": internalError,
+		},
+	},
+	"POST /webhook/delivery/{id}/retry": {
+		Tags: []string{"webhooks"}, Summary: "Reenfileira uma entrega da dead-letter list",
+		Responses: map[string]openAPIResponse{
+			"202": jsonResponse("Entrega reenfileirada", schemaRef("Result")),
+			"404": errorResponse("Entrega não encontrada na dead-letter list"),
+			"500": internalError,
+		},
+	},
+
+	// Sistema
+	"GET /health": {
+		Tags: []string{"system"}, Summary: "Health check (ping no MySQL)",
+		Responses: map[string]openAPIResponse{
+			"200": jsonResponse("API e banco disponíveis", schemaRef("Health")),
+			"503": errorResponse("Banco indisponível"),
+		},
+	},
+	"GET /openapi.json": {
+		Tags: []string{"system"}, Summary: "Este documento",
+		Responses: map[string]openAPIResponse{
+			"200": {Description: "OpenAPI 3", Content: map[string]openAPIMediaType{"application/json": {Schema: &openAPISchema{Type: "object"}}}},
+		},
+	},
+	"GET /docs": {
+		Tags: []string{"system"}, Summary: "Página de documentação da API (lê este documento)",
+		Responses: map[string]openAPIResponse{
+			"200": {Description: "Página HTML", Content: map[string]openAPIMediaType{"text/html": {Schema: stringSchema("")}}},
+		},
+	},
+	"GET /docs/{asset}": {
+		Tags: []string{"system"}, Summary: "CSS, JS e ícones do Swagger UI (swagger-ui-dist), embutidos no binário",
+		Responses: map[string]openAPIResponse{
+			"200": {Description: "Asset", Content: map[string]openAPIMediaType{
+				"text/css":        {Schema: stringSchema("")},
+				"text/javascript": {Schema: stringSchema("")},
+				"image/png":       {Schema: stringSchema("")},
+			}},
+			"404": errorResponse("Asset não encontrado"),
+		},
+	},
+}
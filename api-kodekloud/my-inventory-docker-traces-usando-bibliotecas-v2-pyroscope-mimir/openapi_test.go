@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// newTestRouter registra as rotas da API sem banco: os handlers não são chamados
+func newTestRouter(t *testing.T) *App {
+	t.Helper()
+	app := &App{Router: mux.NewRouter()}
+	if err := app.HandleRequests(); err != nil {
+		t.Fatalf("HandleRequests: %v", err)
+	}
+	return app
+}
+
+// O contrato precisa bater com as rotas registradas: toda rota documentada e toda operação com rota
+func TestOpenAPISpecMatchesRoutes(t *testing.T) {
+	app := newTestRouter(t)
+	data, err := buildOpenAPISpec(app.Router)
+	if err != nil {
+		t.Fatalf("contrato fora de sincronia: %v", err)
+	}
+
+	var spec struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+			Deprecated  bool   `json:"deprecated"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatalf("documento inválido: %v", err)
+	}
+
+	routes := 0
+	operationIDs := map[string]string{}
+	err = app.Router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil
+		}
+		template, _ := route.GetPathTemplate()
+		methods, _ := route.GetMethods()
+		path := openAPIPath(template)
+		for _, method := range methods {
+			routes++
+			op, ok := spec.Paths[path][strings.ToLower(method)]
+			if !ok {
+				t.Errorf("%s %s sem operação no documento", method, path)
+				continue
+			}
+			if op.OperationID == "" {
+				t.Errorf("%s %s sem operationId", method, path)
+			}
+			if other, ok := operationIDs[op.OperationID]; ok {
+				t.Errorf("operationId %s repetido em %s e %s %s", op.OperationID, other, method, path)
+			}
+			operationIDs[op.OperationID] = method + " " + path
+			_, _, legacy := routeContract(method, template)
+			if op.Deprecated != legacy {
+				t.Errorf("%s %s: deprecated = %v, esperado %v", method, path, op.Deprecated, legacy)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operations := 0
+	for _, byMethod := range spec.Paths {
+		operations += len(byMethod)
+	}
+	if operations != routes {
+		t.Errorf("documento com %d operações para %d rotas", operations, routes)
+	}
+}
+
+// Uma rota nova sem entrada em openAPIOperations impede a aplicação de subir
+func TestOpenAPISpecDetectsUndocumentedRoute(t *testing.T) {
+	app := newTestRouter(t)
+	app.Router.HandleFunc("/v1/product/{id:[0-9]+}/color", app.getProduct).Methods("GET")
+
+	_, err := buildOpenAPISpec(app.Router)
+	if err == nil || !strings.Contains(err.Error(), "GET /v1/product/{id}/color") {
+		t.Fatalf("erro = %v, esperado a rota sem documentação", err)
+	}
+}
+
+func TestServeDocs(t *testing.T) {
+	app := newTestRouter(t)
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+	}{
+		{path: "/docs", status: http.StatusOK, contentType: "text/html"},
+		{path: "/docs/swagger-ui-bundle.js", status: http.StatusOK, contentType: "javascript"},
+		{path: "/docs/swagger-ui-standalone-preset.js", status: http.StatusOK, contentType: "javascript"},
+		{path: "/docs/swagger-ui.css", status: http.StatusOK, contentType: "text/css"},
+		{path: "/docs/favicon-32x32.png", status: http.StatusOK, contentType: "image/png"},
+		{path: "/docs/missing.js", status: http.StatusNotFound, contentType: problemContentType},
+		{path: "/docs/index.html", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			app.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, esperado %d", w.Code, tt.status)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.Contains(ct, tt.contentType) {
+				t.Errorf("Content-Type = %s, esperado %s", ct, tt.contentType)
+			}
+		})
+	}
+
+	// A página não depende de CDN: os assets vêm da própria API
+	w := httptest.NewRecorder()
+	app.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if body := w.Body.String(); strings.Contains(body, "https://") || !strings.Contains(body, `src="/docs/swagger-ui-bundle.js"`) ||
+		!strings.Contains(body, `url: "/openapi.json"`) {
+		t.Errorf("página com assets externos: %s", body)
+	}
+}
+
+// Os corpos JSON são conferidos pelo openapi3filter contra o documento gerado; os handlers não chegam a ser chamados
+func TestOpenAPIValidationMiddleware(t *testing.T) {
+	app := newTestRouter(t)
+	spec, err := buildOpenAPISpec(app.Router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.openAPIDoc, err = loadOpenAPIDoc(spec); err != nil {
+		t.Fatalf("documento OpenAPI inválido: %v", err)
+	}
+	app.Router.Use(app.openAPIValidationMiddleware)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   map[string]string
+	}{
+		{name: "sem corpo", method: http.MethodPost, path: "/v1/product", want: map[string]string{"": "request body is required"}},
+		{name: "JSON inválido", method: http.MethodPost, path: "/v1/product", body: `{"sku":`, want: map[string]string{"": "request body must be valid JSON"}},
+		{name: "campo obrigatório", method: http.MethodPost, path: "/v1/product", body: `{"name":"Notebook"}`, want: map[string]string{"sku": `property "sku" is missing`}},
+		{name: "pattern", method: http.MethodPost, path: "/v1/product", body: `{"sku":"-x","name":"Notebook"}`, want: map[string]string{"sku": "string doesn't match the regular expression"}},
+		{name: "campo desconhecido", method: http.MethodPost, path: "/v1/product", body: `{"sku":"NB-1","name":"Notebook","color":"red"}`, want: map[string]string{"color": `property "color" is unsupported`}},
+		{name: "tipo", method: http.MethodPut, path: "/v1/product/1", body: `{"sku":"NB-1","name":"Notebook","quantity":"5"}`, want: map[string]string{"quantity": "value must be an integer"}},
+		{name: "format uri", method: http.MethodPost, path: "/v1/webhook", body: `{"url":"não é url","events":["product.created"]}`, want: map[string]string{"url": `doesn't match the format "uri"`}},
+		{name: "item do lote", method: http.MethodPost, path: "/v1/products/bulk", body: `{"operations":[{"op":"create","product":{"name":"Notebook"}}]}`, want: map[string]string{"operations[0].product.sku": `property "sku" is missing`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			app.Router.ServeHTTP(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, esperado 400: %s", w.Code, w.Body)
+			}
+			var p problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+			for field, message := range tt.want {
+				found := false
+				for _, e := range p.Errors {
+					found = found || e.Field == field && strings.Contains(e.Message, message)
+				}
+				if !found {
+					t.Errorf("errors = %+v, esperado %q em %q", p.Errors, message, field)
+				}
+			}
+		})
+	}
+}
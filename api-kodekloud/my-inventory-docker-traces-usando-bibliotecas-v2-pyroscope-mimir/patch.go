@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// mergePatchContentType é o media type de JSON Merge Patch (RFC 7396)
+const mergePatchContentType = "application/merge-patch+json"
+
+// applyMergePatch aplica um JSON Merge Patch (RFC 7396) sobre o produto.
+// Só os campos presentes no documento são alterados. Como os campos do produto são
+// obrigatórios (exceto category_id), null (remoção do campo) é rejeitado, assim como
+// os campos somente leitura (id, version, reserved e available).
+func applyMergePatch(p *product, body []byte) error {
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(body, &patch); err != nil || patch == nil {
+		return errors.New("merge patch must be a JSON object")
+	}
+
+	for field, raw := range patch {
+		if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+			// category_id é o único campo opcional: null remove a categoria
+			if field == "category_id" {
+				p.CategoryID = nil
+				continue
+			}
+			return fmt.Errorf("field %q cannot be removed", field)
+		}
+
+		var err error
+		switch field {
+		case "sku":
+			err = json.Unmarshal(raw, &p.SKU)
+		case "name":
+			err = json.Unmarshal(raw, &p.Name)
+		case "quantity":
+			err = json.Unmarshal(raw, &p.Quantity)
+		case "price":
+			err = json.Unmarshal(raw, &p.Price)
+		case "currency":
+			err = json.Unmarshal(raw, &p.Currency)
+		case "category_id":
+			err = json.Unmarshal(raw, &p.CategoryID)
+		case "reorder_threshold":
+			err = json.Unmarshal(raw, &p.ReorderThreshold)
+		case "id", "version", "reserved", "available":
+			return fmt.Errorf("field %q is read-only", field)
+		default:
+			return fmt.Errorf("unknown field %q", field)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid value for field %q", field)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestApplyMergePatch(t *testing.T) {
+	category := 2
+	base := func() product {
+		price, _ := parseMoney("10.00")
+		return product{ID: 1, SKU: "NB-001", Name: "Notebook", Quantity: 5, Price: price, Currency: "BRL",
+			CategoryID: &category, ReorderThreshold: 1, Version: 3}
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+		check   func(t *testing.T, p product)
+	}{
+		{
+			name: "altera só os campos enviados",
+			body: `{"name": "Notebook Pro", "price": "12.50"}`,
+			check: func(t *testing.T, p product) {
+				if p.Name != "Notebook Pro" || p.Price.String() != "12.50" {
+					t.Errorf("name/price = %s/%s", p.Name, p.Price)
+				}
+				if p.SKU != "NB-001" || p.Quantity != 5 || p.Currency != "BRL" || *p.CategoryID != 2 || p.Version != 3 {
+					t.Errorf("campos não enviados foram alterados: %+v", p)
+				}
+			},
+		},
+		{
+			name: "documento vazio não altera nada",
+			body: `{}`,
+			check: func(t *testing.T, p product) {
+				want := base()
+				if p.Name != want.Name || p.Quantity != want.Quantity || p.Price.cmp(want.Price) != 0 {
+					t.Errorf("produto alterado: %+v", p)
+				}
+			},
+		},
+		{
+			name: "null remove a categoria",
+			body: `{"category_id": null}`,
+			check: func(t *testing.T, p product) {
+				if p.CategoryID != nil {
+					t.Errorf("category_id = %d", *p.CategoryID)
+				}
+			},
+		},
+		{
+			name: "troca a categoria e o ponto de reposição",
+			body: `{"category_id": 4, "reorder_threshold": 8, "quantity": 0}`,
+			check: func(t *testing.T, p product) {
+				if *p.CategoryID != 4 || p.ReorderThreshold != 8 || p.Quantity != 0 {
+					t.Errorf("produto = %+v", p)
+				}
+			},
+		},
+		{name: "null em campo obrigatório", body: `{"name": null}`, wantErr: `"name" cannot be removed`},
+		{name: "campo somente leitura", body: `{"version": 9}`, wantErr: `"version" is read-only`},
+		{name: "available é somente leitura", body: `{"available": 1}`, wantErr: `"available" is read-only`},
+		{name: "campo desconhecido", body: `{"color": "red"}`, wantErr: `unknown field "color"`},
+		{name: "tipo inválido", body: `{"quantity": "muitos"}`, wantErr: `invalid value for field "quantity"`},
+		{name: "preço inválido", body: `{"price": "1e3"}`, wantErr: `invalid value for field "price"`},
+		{name: "array no lugar do objeto", body: `[]`, wantErr: "must be a JSON object"},
+		{name: "null no lugar do objeto", body: `null`, wantErr: "must be a JSON object"},
+		{name: "JSON inválido", body: `{"name":`, wantErr: "must be a JSON object"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := base()
+			err := applyMergePatch(&p, []byte(tt.body))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("erro = %v, esperado %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("erro inesperado: %v", err)
+			}
+			tt.check(t, p)
+		})
+	}
+}
+
+// O span de cada requisição é "MÉTODO template", então PUT e PATCH na mesma rota aparecem separados
+func TestHTTPSpanName(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	app := &App{}
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	router := mux.NewRouter()
+	router.Use(otelmux.Middleware("inventory-app", otelmux.WithTracerProvider(provider), otelmux.WithSpanNameFormatter(httpSpanName)))
+	router.HandleFunc("/v1/product/{id:[0-9]+}", ok).Methods("PUT")
+	router.HandleFunc("/v1/product/{id:[0-9]+}", app.patchProduct).Methods("PATCH")
+	router.HandleFunc("/v2/products/{id:[0-9]+}", app.patchProduct).Methods("PATCH")
+	router.HandleFunc("/health", ok).Methods("GET")
+
+	tests := []struct {
+		method, path, want string
+	}{
+		{method: http.MethodPut, path: "/v1/product/1", want: "PUT /v1/product/{id:[0-9]+}"},
+		{method: http.MethodPatch, path: "/v1/product/1", want: "PATCH /v1/product/{id:[0-9]+}"},
+		{method: http.MethodPatch, path: "/v2/products/1", want: "PATCH /v2/products/{id:[0-9]+}"},
+		{method: http.MethodGet, path: "/health", want: "GET /health"},
+	}
+	for _, tt := range tests {
+		// Sem o Content-Type de merge patch o PATCH responde 415 antes de ir ao banco
+		r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{}`))
+		router.ServeHTTP(httptest.NewRecorder(), r)
+
+		spans := recorder.Ended()
+		if got := spans[len(spans)-1].Name(); got != tt.want {
+			t.Errorf("%s %s: span = %q, esperado %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
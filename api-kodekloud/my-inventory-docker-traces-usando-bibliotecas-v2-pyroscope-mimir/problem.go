@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// problemContentType é o media type das respostas de erro (RFC 7807)
+const problemContentType = "application/problem+json"
+
+// problemTypeBase prefixa o type dos problemas; a lista de types está no README
+const problemTypeBase = "/problems/"
+
+// problem é o corpo das respostas de erro da API (RFC 7807), com o trace_id do span da requisição
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	TraceID  string `json:"trace_id,omitempty"`
+	// Errors são as violações do validation-error, uma por mensagem (um campo pode repetir)
+	Errors []fieldError `json:"errors,omitempty"`
+}
+
+// problemStatusTypes é o type padrão de cada status, usado quando o handler não informa outro
+var problemStatusTypes = map[int]string{
+	http.StatusBadRequest:            "invalid-request",
+	http.StatusNotFound:              "not-found",
+	http.StatusMethodNotAllowed:      "method-not-allowed",
+	http.StatusNotAcceptable:         "not-acceptable",
+	http.StatusConflict:              "conflict",
+	http.StatusPreconditionFailed:    "precondition-failed",
+	http.StatusRequestEntityTooLarge: "payload-too-large",
+	http.StatusUnsupportedMediaType:  "unsupported-media-type",
+	http.StatusUnprocessableEntity:   "unprocessable-entity",
+	http.StatusPreconditionRequired:  "precondition-required",
+	http.StatusInternalServerError:   "internal-error",
+	http.StatusServiceUnavailable:    "service-unavailable",
+}
+
+// Types específicos, para erros que o cliente trata de forma própria
+const (
+	problemValidation         = "validation-error"
+	problemDuplicateSKU       = "duplicate-sku"
+	problemVersionConflict    = "version-conflict"
+	problemIdempotencyReuse   = "idempotency-key-reused"
+	problemReservationExpired = "reservation-expired"
+)
+
+// problemTypeError carrega o type e o título de um erro mais específico que o status
+type problemTypeError struct {
+	problemType string
+	title       string
+	err         error
+}
+
+func (e *problemTypeError) Error() string { return e.err.Error() }
+func (e *problemTypeError) Unwrap() error { return e.err }
+
+// withProblemType marca err com um type próprio para o sendError
+func withProblemType(problemType, title string, err error) error {
+	return &problemTypeError{problemType: problemType, title: title, err: err}
+}
+
+// newProblem monta o problema de uma resposta de erro com o status e o detail de err
+func newProblem(r *http.Request, status int, err error) problem {
+	p := problem{
+		Type:     problemTypeBase + problemStatusTypes[status],
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   err.Error(),
+		Instance: r.URL.RequestURI(),
+	}
+	if _, ok := problemStatusTypes[status]; !ok {
+		p.Type = "about:blank"
+	}
+	var typed *problemTypeError
+	if errors.As(err, &typed) {
+		p.Type = problemTypeBase + typed.problemType
+		p.Title = typed.title
+	}
+	var invalid *validationError
+	if errors.As(err, &invalid) {
+		p.Type = problemTypeBase + problemValidation
+		p.Title = "Validation failed"
+		p.Detail = "invalid " + invalid.resource + " data"
+		p.Errors = invalid.fieldErrors()
+	}
+	if spanContext := trace.SpanFromContext(r.Context()).SpanContext(); spanContext.IsValid() {
+		p.TraceID = spanContext.TraceID().String()
+	}
+	return p
+}
+
+// sendProblem registra o problema no span da requisição e o devolve como application/problem+json
+func sendProblem(w http.ResponseWriter, r *http.Request, p problem, err error) {
+	logrus.WithContext(r.Context()).WithFields(logrus.Fields{
+		"component": "http_handler",
+		"status":    p.Status,
+		"type":      p.Type,
+		"error":     p.Detail,
+	}).Error("Erro na requisição")
+
+	attrs := []attribute.KeyValue{
+		attribute.String("problem.type", p.Type),
+		attribute.String("problem.title", p.Title),
+		attribute.Int("problem.status", p.Status),
+		attribute.String("problem.instance", p.Instance),
+	}
+	if len(p.Errors) > 0 {
+		fields := make([]string, len(p.Errors))
+		for i, fe := range p.Errors {
+			fields[i] = fe.Field + ": " + fe.Message
+		}
+		attrs = append(attrs, attribute.StringSlice("problem.errors", fields))
+	}
+	var typed *problemTypeError
+	if errors.As(err, &typed) {
+		err = typed.err // exception.type fica com o erro original
+	}
+	span := trace.SpanFromContext(r.Context())
+	span.RecordError(err, trace.WithAttributes(attrs...))
+	span.SetStatus(codes.Error, p.Detail)
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		logrus.WithContext(r.Context()).WithError(err).Error("Erro ao codificar a resposta de erro")
+	}
+}
+
+// notFoundHandler e methodNotAllowedHandler respondem as rotas inexistentes do router também como problem+json
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	sendError(w, r, http.StatusNotFound, errors.New("no route matches "+r.URL.Path))
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	sendError(w, r, http.StatusMethodNotAllowed, errors.New("method "+r.Method+" is not allowed on "+r.URL.Path))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestNewProblem(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/v1/product/9?x=1", nil)
+
+	tests := []struct {
+		name   string
+		status int
+		err    error
+		want   problem
+	}{
+		{
+			name:   "type pelo status",
+			status: http.StatusNotFound,
+			err:    errors.New("product with ID 9 not found"),
+			want: problem{Type: "/problems/not-found", Title: "Not Found", Status: 404,
+				Detail: "product with ID 9 not found", Instance: "/v1/product/9?x=1"},
+		},
+		{
+			name:   "status sem type próprio",
+			status: http.StatusTeapot,
+			err:    errors.New("teapot"),
+			want:   problem{Type: "about:blank", Title: "I'm a teapot", Status: 418, Detail: "teapot", Instance: "/v1/product/9?x=1"},
+		},
+		{
+			name:   "type específico",
+			status: http.StatusConflict,
+			err:    withProblemType(problemDuplicateSKU, "Duplicate SKU", errors.New("sku taken")),
+			want: problem{Type: "/problems/duplicate-sku", Title: "Duplicate SKU", Status: 409,
+				Detail: "sku taken", Instance: "/v1/product/9?x=1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newProblem(r, tt.status, tt.err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newProblem = %+v, esperado %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// As violações das regras do recurso saem em errors, no mesmo formato da validação do OpenAPI
+func TestNewProblemValidationError(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/v1/product", nil)
+	err := &validationError{resource: "product", fields: map[string][]string{
+		"price": {"must not be negative"},
+		"name":  {"must not have leading or trailing whitespace", "must have at most 255 characters"},
+	}}
+
+	p := newProblem(r, http.StatusBadRequest, err)
+	if p.Type != "/problems/"+problemValidation || p.Detail != "invalid product data" {
+		t.Errorf("type/detail = %s/%s", p.Type, p.Detail)
+	}
+	want := []fieldError{
+		{Field: "name", Message: "must not have leading or trailing whitespace"},
+		{Field: "name", Message: "must have at most 255 characters"},
+		{Field: "price", Message: "must not be negative"},
+	}
+	if !reflect.DeepEqual(p.Errors, want) {
+		t.Errorf("errors = %+v", p.Errors)
+	}
+
+	w := httptest.NewRecorder()
+	sendProblem(w, r, p, err)
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := body["fields"]; ok {
+		t.Errorf("resposta com fields: %s", w.Body)
+	}
+	if errs, _ := body["errors"].([]interface{}); len(errs) != 3 {
+		t.Errorf("errors = %v", body["errors"])
+	}
+}
+
+// 404 e 405 passam pela mesma cadeia das rotas: têm span, trace_id e métricas
+func TestUnmatchedRoutesUseMiddlewares(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	app := &App{Router: mux.NewRouter()}
+	app.useMiddlewares(otelmux.Middleware("inventory-app", otelmux.WithTracerProvider(provider)), prometheusMiddleware)
+	app.Router.HandleFunc("/v1/products", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+
+	tests := []struct {
+		name, method, path string
+		status             int
+	}{
+		{name: "rota inexistente", method: http.MethodGet, path: "/v1/nada", status: http.StatusNotFound},
+		{name: "método não permitido", method: http.MethodDelete, path: "/v1/products", status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := httpRequestsTotal.WithLabelValues("unmatched", tt.method, strconv.Itoa(tt.status))
+			before := testutil.ToFloat64(counter)
+
+			w := httptest.NewRecorder()
+			app.Router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, esperado %d", w.Code, tt.status)
+			}
+
+			var p problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+			spans := recorder.Ended()
+			if len(spans) == 0 || p.TraceID != spans[len(spans)-1].SpanContext().TraceID().String() {
+				t.Errorf("trace_id = %q, sem o span da requisição", p.TraceID)
+			}
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("http_requests_total{path=\"unmatched\"} aumentou %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Limites de paginação do GET /products
+const (
+	defaultProductsLimit = 50
+	maxProductsLimit     = 500
+)
+
+// Colunas pelas quais o GET /products pode ser ordenado.
+// O valor é o nome da coluna no MySQL, nunca o texto vindo do cliente.
+var productSortColumns = map[string]string{
+	"id":       "id",
+	"sku":      "sku",
+	"name":     "name",
+	"quantity": "quantity",
+	"price":    "price",
+}
+
+// sortField representa um campo do parâmetro sort (ex: "-name" => name DESC)
+type sortField struct {
+	Field string
+	Desc  bool
+}
+
+// productCursor é o conteúdo do cursor opaco devolvido em next_cursor.
+// Guarda a ordenação usada e os valores da última linha da página (keyset pagination).
+type productCursor struct {
+	Sort   string            `json:"s"`
+	Values []json.RawMessage `json:"v"`
+}
+
+// productQuery reúne filtros, ordenação e paginação aceitos por GET /products
+type productQuery struct {
+	Name        string
+	MinPrice    *money
+	MaxPrice    *money
+	Currency    string // ISO 4217; min_price/max_price só fazem sentido combinados com ele
+	MinQuantity *int
+	Category    string // id numérico ou nome da categoria
+	Sort        []sortField
+	Limit       int
+	Offset      int
+	After       []interface{} // valores da última linha vista, vindos do cursor
+	Deleted     bool          // true lista a lixeira (soft delete) em vez dos produtos ativos
+}
+
+// productPage é o envelope de resposta do GET /products
+type productPage struct {
+	Products   []product `json:"products" xml:"products>item"`
+	Total      int       `json:"total" xml:"total"`
+	Limit      int       `json:"limit" xml:"limit"`
+	Offset     int       `json:"offset" xml:"offset"`
+	NextCursor string    `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
+}
+
+func (p productPage) pageItems() interface{} {
+	return p.Products
+}
+
+func (p productPage) pageMeta() v2PageMeta {
+	total := p.Total
+	return v2PageMeta{Total: &total, Limit: p.Limit, Offset: p.Offset, NextCursor: p.NextCursor}
+}
+
+// parseProductQuery valida a query string do GET /products e monta o productQuery
+func parseProductQuery(values url.Values) (productQuery, error) {
+	q := productQuery{Name: strings.TrimSpace(values.Get("name"))}
+
+	var err error
+	q.Limit, q.Offset, err = parseLimitOffset(values)
+	if err != nil {
+		return q, err
+	}
+
+	if v := values.Get("min_price"); v != "" {
+		price, err := parseMoney(v)
+		if err != nil || price.isNegative() {
+			return q, errors.New("min_price must be a non-negative decimal")
+		}
+		q.MinPrice = &price
+	}
+
+	if v := values.Get("max_price"); v != "" {
+		price, err := parseMoney(v)
+		if err != nil || price.isNegative() {
+			return q, errors.New("max_price must be a non-negative decimal")
+		}
+		q.MaxPrice = &price
+	}
+
+	if q.MinPrice != nil && q.MaxPrice != nil && q.MinPrice.cmp(*q.MaxPrice) > 0 {
+		return q, errors.New("min_price cannot be greater than max_price")
+	}
+
+	if v := values.Get("currency"); v != "" {
+		q.Currency = strings.ToUpper(v)
+		if _, ok := currencyMinorUnits[q.Currency]; !ok {
+			return q, fmt.Errorf("currency %q is not supported", v)
+		}
+	}
+
+	q.Category = strings.TrimSpace(values.Get("category"))
+
+	if v := values.Get("min_quantity"); v != "" {
+		quantity, err := strconv.Atoi(v)
+		if err != nil || quantity < 0 {
+			return q, errors.New("min_quantity must be a non-negative integer")
+		}
+		q.MinQuantity = &quantity
+	}
+
+	sort, err := parseSort(values.Get("sort"))
+	if err != nil {
+		return q, err
+	}
+	q.Sort = sort
+
+	// Preços em moedas diferentes não são comparáveis: sem currency, filtro e ordenação por preço
+	// ficam restritos à moeda padrão
+	if q.Currency == "" && (q.MinPrice != nil || q.MaxPrice != nil || q.sortsBy("price")) {
+		q.Currency = defaultCurrency
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		if values.Get("offset") != "" {
+			return q, errors.New("cursor and offset cannot be used together")
+		}
+		cursor, err := decodeProductCursor(v)
+		if err != nil {
+			return q, err
+		}
+		if cursor.Sort != q.sortKey() || len(cursor.Values) != len(q.Sort) {
+			return q, errors.New("cursor does not match the requested sort")
+		}
+		q.After = make([]interface{}, len(q.Sort))
+		for i, f := range q.Sort {
+			value, err := cursorValue(f.Field, cursor.Values[i])
+			if err != nil {
+				return q, errors.New("invalid cursor")
+			}
+			q.After[i] = value
+		}
+	}
+
+	return q, nil
+}
+
+// sortsBy indica se field faz parte da ordenação pedida
+func (q productQuery) sortsBy(field string) bool {
+	for _, f := range q.Sort {
+		if f.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLimitOffset lê limit e offset, usados por todas as listagens paginadas
+func parseLimitOffset(values url.Values) (int, int, error) {
+	limit, offset := defaultProductsLimit, 0
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxProductsLimit {
+			return 0, 0, fmt.Errorf("limit must be an integer between 1 and %d", maxProductsLimit)
+		}
+		limit = n
+	}
+
+	if v := values.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+// parseSort interpreta "price,-name". O id é sempre adicionado no final como
+// desempate, garantindo uma ordem total (necessária para o cursor).
+func parseSort(raw string) ([]sortField, error) {
+	fields := []sortField{}
+	seen := map[string]bool{}
+
+	if raw != "" {
+		for _, part := range strings.Split(raw, ",") {
+			part = strings.TrimSpace(part)
+			f := sortField{Field: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+			if _, ok := productSortColumns[f.Field]; !ok {
+				return nil, fmt.Errorf("invalid sort field %q", part)
+			}
+			if seen[f.Field] {
+				return nil, fmt.Errorf("duplicated sort field %q", f.Field)
+			}
+			seen[f.Field] = true
+			fields = append(fields, f)
+		}
+	}
+
+	if !seen["id"] {
+		fields = append(fields, sortField{Field: "id"})
+	}
+	return fields, nil
+}
+
+// sortKey devolve a ordenação normalizada, usada para amarrar o cursor à ordenação
+func (q productQuery) sortKey() string {
+	parts := make([]string, len(q.Sort))
+	for i, f := range q.Sort {
+		if f.Desc {
+			parts[i] = "-" + f.Field
+		} else {
+			parts[i] = f.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// filterConditions monta as condições dos filtros. Todos os valores vão como parâmetros (?),
+// nunca concatenados na query.
+func (q productQuery) filterConditions() ([]string, []interface{}) {
+	conditions := []string{"deleted_at IS NULL"}
+	if q.Deleted {
+		conditions[0] = "deleted_at IS NOT NULL"
+	}
+	args := []interface{}{}
+
+	if q.Name != "" {
+		conditions = append(conditions, "name LIKE ?")
+		args = append(args, "%"+escapeLike(q.Name)+"%")
+	}
+	if q.MinPrice != nil {
+		conditions = append(conditions, "price >= ?")
+		args = append(args, *q.MinPrice)
+	}
+	if q.MaxPrice != nil {
+		conditions = append(conditions, "price <= ?")
+		args = append(args, *q.MaxPrice)
+	}
+	if q.Currency != "" {
+		conditions = append(conditions, "currency = ?")
+		args = append(args, q.Currency)
+	}
+	if q.MinQuantity != nil {
+		conditions = append(conditions, "quantity >= ?")
+		args = append(args, *q.MinQuantity)
+	}
+	if q.Category != "" {
+		if id, err := strconv.Atoi(q.Category); err == nil {
+			conditions = append(conditions, "category_id = ?")
+			args = append(args, id)
+		} else {
+			conditions = append(conditions, "category_id = (SELECT id FROM categories WHERE name = ?)")
+			args = append(args, q.Category)
+		}
+	}
+
+	return conditions, args
+}
+
+// cursorCondition monta a condição keyset a partir do cursor:
+// (a > ?) OR (a = ? AND b > ?) OR ... respeitando a direção de cada campo.
+func (q productQuery) cursorCondition() (string, []interface{}) {
+	if q.After == nil {
+		return "", nil
+	}
+
+	alternatives := []string{}
+	args := []interface{}{}
+	for i, f := range q.Sort {
+		parts := []string{}
+		for j := 0; j < i; j++ {
+			parts = append(parts, productSortColumns[q.Sort[j].Field]+" = ?")
+			args = append(args, q.After[j])
+		}
+		op := ">"
+		if f.Desc {
+			op = "<"
+		}
+		parts = append(parts, productSortColumns[f.Field]+" "+op+" ?")
+		args = append(args, q.After[i])
+		alternatives = append(alternatives, "("+strings.Join(parts, " AND ")+")")
+	}
+
+	return "(" + strings.Join(alternatives, " OR ") + ")", args
+}
+
+// orderClause monta o ORDER BY a partir da lista branca de colunas
+func (q productQuery) orderClause() string {
+	parts := make([]string, len(q.Sort))
+	for i, f := range q.Sort {
+		dir := "ASC"
+		if f.Desc {
+			dir = "DESC"
+		}
+		parts[i] = productSortColumns[f.Field] + " " + dir
+	}
+	return " ORDER BY " + strings.Join(parts, ", ")
+}
+
+// nextCursor gera o cursor opaco a partir do último produto da página
+func (q productQuery) nextCursor(last product) string {
+	values := make([]json.RawMessage, len(q.Sort))
+	for i, f := range q.Sort {
+		var v interface{}
+		switch f.Field {
+		case "id":
+			v = last.ID
+		case "sku":
+			v = last.SKU
+		case "name":
+			v = last.Name
+		case "quantity":
+			v = last.Quantity
+		case "price":
+			v = last.Price
+		}
+		raw, _ := json.Marshal(v)
+		values[i] = raw
+	}
+
+	raw, _ := json.Marshal(productCursor{Sort: q.sortKey(), Values: values})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeProductCursor(raw string) (*productCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var cursor productCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &cursor, nil
+}
+
+// cursorValue converte o valor guardado no cursor para o tipo da coluna
+func cursorValue(field string, raw json.RawMessage) (interface{}, error) {
+	switch field {
+	case "name", "sku":
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	case "price":
+		var m money
+		err := json.Unmarshal(raw, &m)
+		return m, err
+	default:
+		var n int
+		err := json.Unmarshal(raw, &n)
+		return n, err
+	}
+}
+
+// whereClause junta as condições com AND, ou devolve vazio se não houver nenhuma
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// escapeLike escapa os curingas do LIKE para que o filtro name seja literal
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
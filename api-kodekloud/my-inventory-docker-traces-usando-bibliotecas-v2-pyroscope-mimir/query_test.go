@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProductQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr string
+		check   func(t *testing.T, q productQuery)
+	}{
+		{
+			name:  "padrões",
+			query: "",
+			check: func(t *testing.T, q productQuery) {
+				if q.Limit != defaultProductsLimit || q.Offset != 0 {
+					t.Errorf("limit/offset = %d/%d", q.Limit, q.Offset)
+				}
+				if !reflect.DeepEqual(q.Sort, []sortField{{Field: "id"}}) {
+					t.Errorf("sort = %+v", q.Sort)
+				}
+			},
+		},
+		{
+			name:  "filtros e ordenação",
+			query: "name=+note+&currency=brl&min_price=10&max_price=20.5&min_quantity=3&category=7&sort=-price,name&limit=10&offset=20",
+			check: func(t *testing.T, q productQuery) {
+				if q.Name != "note" || q.Currency != "BRL" || q.Category != "7" || *q.MinQuantity != 3 {
+					t.Errorf("filtros = %+v", q)
+				}
+				if q.MinPrice.String() != "10" || q.MaxPrice.String() != "20.5" {
+					t.Errorf("preços = %s/%s", q.MinPrice, q.MaxPrice)
+				}
+				want := []sortField{{Field: "price", Desc: true}, {Field: "name"}, {Field: "id"}}
+				if !reflect.DeepEqual(q.Sort, want) {
+					t.Errorf("sort = %+v", q.Sort)
+				}
+				if q.Limit != 10 || q.Offset != 20 {
+					t.Errorf("limit/offset = %d/%d", q.Limit, q.Offset)
+				}
+			},
+		},
+		{
+			name:  "id explícito não é duplicado",
+			query: "sort=-id",
+			check: func(t *testing.T, q productQuery) {
+				if !reflect.DeepEqual(q.Sort, []sortField{{Field: "id", Desc: true}}) {
+					t.Errorf("sort = %+v", q.Sort)
+				}
+			},
+		},
+		{
+			name:  "filtro e ordenação por preço sem moeda usam a moeda padrão",
+			query: "min_price=10&sort=name,-price",
+			check: func(t *testing.T, q productQuery) {
+				if q.Currency != defaultCurrency {
+					t.Errorf("currency = %q, esperado %q", q.Currency, defaultCurrency)
+				}
+			},
+		},
+		{
+			name:  "sem filtro de preço a moeda fica vazia",
+			query: "sort=name",
+			check: func(t *testing.T, q productQuery) {
+				if q.Currency != "" {
+					t.Errorf("currency = %q", q.Currency)
+				}
+			},
+		},
+		{name: "limit zero", query: "limit=0", wantErr: "limit must be"},
+		{name: "limit acima do máximo", query: "limit=501", wantErr: "limit must be"},
+		{name: "offset negativo", query: "offset=-1", wantErr: "offset must be"},
+		{name: "min_price negativo", query: "currency=BRL&min_price=-1", wantErr: "min_price must be"},
+		{name: "max_price inválido", query: "currency=BRL&max_price=abc", wantErr: "max_price must be"},
+		{name: "faixa de preço invertida", query: "currency=BRL&min_price=10&max_price=9.99", wantErr: "cannot be greater"},
+		{name: "moeda desconhecida", query: "currency=XYZ", wantErr: "not supported"},
+		{name: "min_quantity inválido", query: "min_quantity=x", wantErr: "min_quantity must be"},
+		{name: "campo de ordenação desconhecido", query: "sort=created_at", wantErr: "invalid sort field"},
+		{name: "campo de ordenação repetido", query: "sort=name,-name", wantErr: "duplicated sort field"},
+		{name: "cursor com offset", query: "cursor=abc&offset=1", wantErr: "cannot be used together"},
+		{name: "cursor inválido", query: "cursor=!!!", wantErr: "invalid cursor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err := parseProductQuery(values)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("erro = %v, esperado %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("erro inesperado: %v", err)
+			}
+			tt.check(t, q)
+		})
+	}
+}
+
+func TestProductCursorRoundTrip(t *testing.T) {
+	q, err := parseProductQuery(url.Values{"sort": {"-quantity,name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := q.nextCursor(product{ID: 42, Name: "Mouse", Quantity: 7})
+
+	next, err := parseProductQuery(url.Values{"sort": {"-quantity,name"}, "cursor": {cursor}})
+	if err != nil {
+		t.Fatalf("cursor recusado: %v", err)
+	}
+	if want := []interface{}{7, "Mouse", 42}; !reflect.DeepEqual(next.After, want) {
+		t.Errorf("After = %#v, esperado %#v", next.After, want)
+	}
+
+	condition, args := next.cursorCondition()
+	wantCondition := "((quantity < ?) OR (quantity = ? AND name > ?) OR (quantity = ? AND name = ? AND id > ?))"
+	if condition != wantCondition {
+		t.Errorf("condição = %s", condition)
+	}
+	if want := []interface{}{7, 7, "Mouse", 7, "Mouse", 42}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %#v", args)
+	}
+
+	// O cursor fica amarrado à ordenação em que foi gerado
+	if _, err := parseProductQuery(url.Values{"sort": {"name"}, "cursor": {cursor}}); err == nil ||
+		!strings.Contains(err.Error(), "does not match") {
+		t.Errorf("cursor de outra ordenação aceito: %v", err)
+	}
+}
+
+func TestProductCursorPrice(t *testing.T) {
+	price, _ := parseMoney("10.50")
+	q, _ := parseProductQuery(url.Values{"sort": {"price"}, "currency": {"BRL"}})
+	cursor := q.nextCursor(product{ID: 1, Price: price})
+
+	next, err := parseProductQuery(url.Values{"sort": {"price"}, "currency": {"BRL"}, "cursor": {cursor}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := next.After[0].(money); got.cmp(price) != 0 {
+		t.Errorf("preço no cursor = %s", got)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got := escapeLike(`50%_off\`); got != `50\%\_off\\` {
+		t.Errorf("escapeLike = %s", got)
+	}
+}
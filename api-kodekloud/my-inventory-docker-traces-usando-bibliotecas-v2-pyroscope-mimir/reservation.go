@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Estados de uma reserva. Só reservas active seguram estoque (products.reserved).
+const (
+	reservationActive    = "active"
+	reservationConfirmed = "confirmed"
+	reservationCancelled = "cancelled"
+	reservationExpired   = "expired"
+)
+
+// Limites do TTL de uma reserva
+const (
+	defaultReservationTTL = 15 * time.Minute
+	maxReservationTTL     = 24 * time.Hour
+)
+
+// errReservationNotActive indica uma tentativa de confirmar/cancelar uma reserva que já saiu do estado active
+var errReservationNotActive = errors.New("reserva não está ativa")
+
+// errReservationExpired indica uma confirmação/cancelamento de reserva que venceu: as unidades
+// já voltaram (ou voltam nesta transação) ao estoque disponível
+var errReservationExpired = errors.New("reserva expirada")
+
+// reservation segura unidades de um produto por um tempo limitado (checkout).
+// Enquanto active, a quantidade fica em products.reserved e sai do available do produto.
+type reservation struct {
+	ID         int       `json:"id" xml:"id"`
+	ProductID  int       `json:"product_id" xml:"product_id"`
+	Quantity   int       `json:"quantity" xml:"quantity"`
+	Status     string    `json:"status" xml:"status"`
+	TTLSeconds int       `json:"ttl_seconds,omitempty" xml:"ttl_seconds,omitempty"` // só na criação
+	ExpiresAt  time.Time `json:"expires_at" xml:"expires_at"`
+	CreatedAt  time.Time `json:"created_at" xml:"created_at"`
+}
+
+// validate aplica as regras da criação da reserva e preenche o TTL padrão
+func (res *reservation) validate() error {
+	if res.Quantity <= 0 {
+		return errors.New("invalid reservation: quantity must be positive")
+	}
+	if res.TTLSeconds == 0 {
+		res.TTLSeconds = int(defaultReservationTTL.Seconds())
+	}
+	if res.TTLSeconds < 0 || time.Duration(res.TTLSeconds)*time.Second > maxReservationTTL {
+		return fmt.Errorf("invalid reservation: ttl_seconds must be between 1 and %d", int(maxReservationTTL.Seconds()))
+	}
+	return nil
+}
+
+// createReservation reserva o estoque (movimentação reserve) e grava a reserva, na transação tx.
+// Devolve sql.ErrNoRows se o produto não existir e errInsufficientStock se não houver estoque disponível.
+func (res *reservation) createReservation(ctx context.Context, tx dbExecutor) error {
+	m := stockMovement{ProductID: res.ProductID, Type: stockReserve, Quantity: res.Quantity, Reason: "reservation"}
+	if err := m.apply(ctx, tx); err != nil {
+		return err
+	}
+
+	res.Status = reservationActive
+	res.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	res.ExpiresAt = res.CreatedAt.Add(time.Duration(res.TTLSeconds) * time.Second)
+	query := "INSERT INTO reservations(product_id, quantity, status, expires_at, created_at) VALUES(?,?,?,?,?)"
+	result, err := tx.ExecContext(ctx, query, res.ProductID, res.Quantity, res.Status, res.ExpiresAt, res.CreatedAt)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("product_id", res.ProductID).Error("Erro ao gravar reserva")
+		return fmt.Errorf("erro ao gravar reserva do produto %d: %w", res.ProductID, err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("erro ao obter ID da reserva: %w", err)
+	}
+	res.ID = int(id)
+	if err := recordStockEvent(ctx, tx, res.ProductID); err != nil {
+		return err
+	}
+	stockMovementsTotal.With(prometheus.Labels{"type": m.Type}).Inc()
+	return nil
+}
+
+// getReservation busca uma reserva pelo ID. forUpdate trava a linha até o fim da transação.
+func (res *reservation) getReservation(ctx context.Context, db dbExecutor, forUpdate bool) error {
+	query := "SELECT product_id, quantity, status, expires_at, created_at FROM reservations WHERE id = ?"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	err := db.QueryRowContext(ctx, query, res.ID).Scan(&res.ProductID, &res.Quantity, &res.Status, &res.ExpiresAt, &res.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return sql.ErrNoRows
+		}
+		return fmt.Errorf("erro ao buscar reserva %d: %w", res.ID, err)
+	}
+	return nil
+}
+
+// finishReservation tira uma reserva do estado active, na transação tx:
+//   - confirmed: libera a reserva e dá baixa no estoque (release + ship)
+//   - cancelled/expired: só libera a reserva (release)
+//
+// Confirmar ou cancelar uma reserva vencida devolve errReservationExpired; se o sweeper ainda
+// não a expirou, ela é expirada na mesma transação (o chamador deve fazer o commit).
+func (res *reservation) finishReservation(ctx context.Context, tx dbExecutor, status string) error {
+	if err := res.getReservation(ctx, tx, true); err != nil {
+		return err
+	}
+	if res.Status == reservationExpired && status != reservationExpired {
+		return errReservationExpired
+	}
+	if res.Status != reservationActive {
+		return errReservationNotActive
+	}
+
+	requested := status
+	if status != reservationExpired && !time.Now().Before(res.ExpiresAt) {
+		status = reservationExpired
+	}
+
+	movements := []stockMovement{{ProductID: res.ProductID, Type: stockRelease, Quantity: res.Quantity, Reason: "reservation_" + status, reservationID: res.ID}}
+	if status == reservationConfirmed {
+		movements = append(movements, stockMovement{ProductID: res.ProductID, Type: stockShip, Quantity: res.Quantity, Reason: "reservation_confirmed"})
+	}
+	productDeleted := false
+	for i := range movements {
+		err := movements[i].apply(ctx, tx)
+		// Produto excluído: não há estoque para devolver, mas a reserva precisa sair de active
+		if errors.Is(err, sql.ErrNoRows) && status != reservationConfirmed {
+			productDeleted = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+		stockMovementsTotal.With(prometheus.Labels{"type": movements[i].Type}).Inc()
+	}
+
+	query := "UPDATE reservations SET status = ? WHERE id = ?"
+	if _, err := tx.ExecContext(ctx, query, status, res.ID); err != nil {
+		logrus.WithContext(ctx).WithError(err).WithField("reservation_id", res.ID).Error("Erro ao atualizar status da reserva")
+		return fmt.Errorf("erro ao atualizar reserva %d: %w", res.ID, err)
+	}
+	res.Status = status
+	if !productDeleted {
+		if err := recordStockEvent(ctx, tx, res.ProductID); err != nil {
+			return err
+		}
+	}
+
+	if status == reservationExpired {
+		reservationsExpiredTotal.Inc()
+		if requested != reservationExpired {
+			return errReservationExpired
+		}
+	}
+	return nil
+}
+
+// getExpiredReservationIDs busca reservas active cujo prazo já passou
+func getExpiredReservationIDs(ctx context.Context, db dbExecutor, limit int) ([]int, error) {
+	query := "SELECT id FROM reservations WHERE status = ? AND expires_at <= ? ORDER BY expires_at LIMIT ?"
+	rows, err := db.QueryContext(ctx, query, reservationActive, time.Now().UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar reservas vencidas: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("erro ao ler reserva vencida: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// countActiveReservations conta as reservas active (usada no gauge reservations_active)
+func countActiveReservations(ctx context.Context, db dbExecutor) (int, error) {
+	var count int
+	query := "SELECT COUNT(*) FROM reservations WHERE status = ?"
+	if err := db.QueryRowContext(ctx, query, reservationActive).Scan(&count); err != nil {
+		return 0, fmt.Errorf("erro ao contar reservas ativas: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Tipos de movimentação de estoque aceitos em POST /product/{id}/stock
+const (
+	stockAdjust  = "adjust"  // correção manual (inventário), quantity pode ser positiva ou negativa
+	stockReceive = "receive" // entrada de mercadoria
+	stockShip    = "ship"    // saída de mercadoria; não pode consumir estoque reservado
+	stockReserve = "reserve" // separa unidades do estoque disponível
+	stockRelease = "release" // devolve unidades reservadas manualmente ao estoque disponível
+	// transfer só é gerado por POST /product/{id}/transfers (uma linha negativa na origem e uma positiva no destino)
+	stockTransfer = "transfer"
+)
+
+// reasonPattern: código do motivo em snake_case (ex: purchase_order, inventory_count)
+var reasonPattern = regexp.MustCompile(`^[a-z0-9_]{1,50}$`)
+
+// errInsufficientStock indica que a movimentação deixaria quantity ou reserved negativos,
+// ou reservaria mais do que o estoque disponível
+var errInsufficientStock = errors.New("estoque insuficiente para a movimentação")
+
+// errHeldByReservations indica um release manual que liberaria unidades seguradas por reservas
+// active; essas só voltam ao available pela confirmação, cancelamento ou expiração da reserva
+var errHeldByReservations = errors.New("unidades seguradas por reservas ativas")
+
+// stockMovement é uma linha do livro-razão de estoque (tabela stock_movements).
+// QuantityAfter e ReservedAfter guardam o saldo do produto logo após a movimentação.
+type stockMovement struct {
+	ID            int       `json:"id" xml:"id"`
+	ProductID     int       `json:"product_id" xml:"product_id"`
+	Type          string    `json:"type" xml:"type"`
+	Location      string    `json:"location,omitempty" xml:"location,omitempty"` // depósito (adjust/receive/ship/transfer); padrão MAIN
+	Quantity      int       `json:"quantity" xml:"quantity"`
+	Reason        string    `json:"reason" xml:"reason"`
+	QuantityAfter int       `json:"quantity_after" xml:"quantity_after"`
+	ReservedAfter int       `json:"reserved_after" xml:"reserved_after"`
+	CreatedAt     time.Time `json:"created_at" xml:"created_at"`
+
+	// reservationID é a reserva que gerou a movimentação; 0 nas movimentações manuais
+	reservationID int
+}
+
+// movementPage é a resposta do GET /product/{id}/movements
+type movementPage struct {
+	Movements []stockMovement `json:"movements" xml:"movements>item"`
+	pageLimits
+}
+
+func (p movementPage) pageItems() interface{} {
+	return p.Movements
+}
+
+// validate aplica as regras da movimentação: só adjust aceita quantity negativa
+func (m stockMovement) validate() error {
+	switch m.Type {
+	case stockAdjust:
+		if m.Quantity == 0 {
+			return errors.New("invalid stock movement: quantity cannot be zero")
+		}
+	case stockReceive, stockShip, stockReserve, stockRelease:
+		if m.Quantity <= 0 {
+			return fmt.Errorf("invalid stock movement: quantity must be positive for %q", m.Type)
+		}
+	default:
+		return fmt.Errorf("invalid stock movement: type must be one of %s, %s, %s, %s or %s",
+			stockAdjust, stockReceive, stockShip, stockReserve, stockRelease)
+	}
+	if !reasonPattern.MatchString(m.Reason) {
+		return errors.New("invalid stock movement: reason is required and must be a snake_case code with up to 50 characters")
+	}
+	if m.Location != "" && (m.Type == stockReserve || m.Type == stockRelease) {
+		return errors.New("invalid stock movement: location is not allowed for reserve and release")
+	}
+	return nil
+}
+
+// apply aplica a movimentação no produto e grava a linha no livro-razão.
+// Deve rodar dentro de uma transação: o SELECT ... FOR UPDATE trava a linha do produto
+// até o commit, então movimentações concorrentes no mesmo produto são serializadas.
+// Devolve sql.ErrNoRows se o produto não existir, errInsufficientStock se o saldo ficaria inválido
+// e errHeldByReservations se um release manual tocaria unidades de reservas active.
+func (m *stockMovement) apply(ctx context.Context, tx dbExecutor) error {
+	logger := logrus.WithContext(ctx).WithFields(logrus.Fields{
+		"component":  "database",
+		"operation":  "stock_movement",
+		"product_id": m.ProductID,
+		"type":       m.Type,
+	})
+	logger.Debug("Iniciando movimentação de estoque")
+
+	var quantity, reserved int
+	var before stockLevel
+	var sku string
+	query := "SELECT sku, quantity, reserved, reorder_threshold FROM products WHERE id = ? AND deleted_at IS NULL FOR UPDATE"
+	if err := tx.QueryRowContext(ctx, query, m.ProductID).Scan(&sku, &quantity, &reserved, &before.ReorderThreshold); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return sql.ErrNoRows
+		}
+		logger.WithError(err).Error("Erro ao travar produto para movimentação de estoque")
+		return fmt.Errorf("erro ao buscar saldo do produto %d: %w", m.ProductID, err)
+	}
+
+	before.Quantity = quantity
+
+	// Entradas e saídas físicas também mudam o saldo do depósito; reservas só mexem no total
+	delta := 0
+	switch m.Type {
+	case stockAdjust, stockReceive:
+		delta = m.Quantity
+	case stockShip:
+		delta = -m.Quantity
+	case stockReserve:
+		reserved += m.Quantity
+	case stockRelease:
+		reserved -= m.Quantity
+	}
+
+	var locationID interface{} // NULL no livro-razão para reserve/release
+	if delta != 0 {
+		if m.Location == "" {
+			m.Location = defaultLocationCode
+		}
+		id, err := locationIDByCode(ctx, tx, m.Location)
+		if err != nil {
+			return err
+		}
+		if _, err := adjustLocationStock(ctx, tx, m.ProductID, id, delta); err != nil {
+			return err
+		}
+		quantity += delta
+		locationID = id
+	}
+	// Um release manual só devolve unidades reservadas manualmente: as das reservas active
+	// continuam em reserved até a reserva sair de active (senão o release dela falharia depois)
+	if m.Type == stockRelease && m.reservationID == 0 {
+		held, err := heldByReservations(ctx, tx, m.ProductID)
+		if err != nil {
+			return err
+		}
+		if reserved < held {
+			logger.WithFields(logrus.Fields{"reserved_after": reserved, "held_by_reservations": held}).Warn("Release manual recusado: unidades seguradas por reservas")
+			return errHeldByReservations
+		}
+	}
+	// O estoque reservado precisa continuar coberto pelo estoque físico
+	if quantity < 0 || reserved < 0 || reserved > quantity {
+		logger.WithFields(logrus.Fields{"quantity_after": quantity, "reserved_after": reserved}).Warn("Movimentação de estoque recusada por saldo insuficiente")
+		return errInsufficientStock
+	}
+
+	query = "UPDATE products SET quantity = ?, reserved = ?, version = version + 1 WHERE id = ?"
+	if _, err := tx.ExecContext(ctx, query, quantity, reserved, m.ProductID); err != nil {
+		logger.WithError(err).Error("Erro ao atualizar saldo do produto")
+		return fmt.Errorf("erro ao atualizar saldo do produto %d: %w", m.ProductID, err)
+	}
+
+	m.QuantityAfter, m.ReservedAfter = quantity, reserved
+	m.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	query = "INSERT INTO stock_movements(product_id, location_id, type, quantity, reason, quantity_after, reserved_after, created_at) VALUES(?,?,?,?,?,?,?,?)"
+	result, err := tx.ExecContext(ctx, query, m.ProductID, locationID, m.Type, m.Quantity, m.Reason, m.QuantityAfter, m.ReservedAfter, m.CreatedAt)
+	if err != nil {
+		logger.WithError(err).Error("Erro ao gravar movimentação de estoque")
+		return fmt.Errorf("erro ao gravar movimentação do produto %d: %w", m.ProductID, err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("erro ao obter ID da movimentação: %w", err)
+	}
+	m.ID = int(id)
+	if err := recordProductHistory(ctx, tx, m.ProductID, historyStock); err != nil {
+		return err
+	}
+	logThresholdCrossing(ctx, product{ID: m.ProductID, SKU: sku, Quantity: quantity, ReorderThreshold: before.ReorderThreshold}, before)
+
+	logger.WithFields(logrus.Fields{"quantity_after": quantity, "reserved_after": reserved}).Debug("Movimentação de estoque aplicada")
+	return nil
+}
+
+// recordStockEvent registra o product.updated de uma escrita de estoque (movimentação ou reserva),
+// com o produto como ficou na transação tx, para o stream e os webhooks
+func recordStockEvent(ctx context.Context, tx dbExecutor, productID int) error {
+	p := product{ID: productID}
+	if err := p.getProduct(ctx, tx); err != nil {
+		return err
+	}
+	return recordProductEvent(ctx, tx, webhookProductUpdated, p)
+}
+
+// heldByReservations soma as unidades seguradas pelas reservas active do produto
+func heldByReservations(ctx context.Context, tx dbExecutor, productID int) (int, error) {
+	var held int
+	query := "SELECT COALESCE(SUM(quantity), 0) FROM reservations WHERE product_id = ? AND status = ?"
+	if err := tx.QueryRowContext(ctx, query, productID, reservationActive).Scan(&held); err != nil {
+		return 0, fmt.Errorf("erro ao somar reservas ativas do produto %d: %w", productID, err)
+	}
+	return held, nil
+}
+
+// getStockMovements busca o histórico de movimentações de um produto, da mais recente para a mais antiga
+func getStockMovements(ctx context.Context, db dbExecutor, productID, limit, offset int) ([]stockMovement, error) {
+	query := `SELECT m.id, m.product_id, m.type, COALESCE(l.code, ''), m.quantity, m.reason, m.quantity_after, m.reserved_after, m.created_at
+		FROM stock_movements m LEFT JOIN locations l ON l.id = m.location_id
+		WHERE m.product_id = ? ORDER BY m.id DESC LIMIT ? OFFSET ?`
+	rows, err := db.QueryContext(ctx, query, productID, limit, offset)
+	if err != nil {
+		logrus.WithContext(ctx).WithFields(logrus.Fields{
+			"component":  "database",
+			"operation":  "get_stock_movements",
+			"product_id": productID,
+			"error":      err.Error(),
+		}).Error("Erro ao executar QueryContext em getStockMovements")
+		return nil, fmt.Errorf("erro ao buscar movimentações do produto %d: %w", productID, err)
+	}
+	defer rows.Close()
+
+	movements := []stockMovement{}
+	for rows.Next() {
+		var m stockMovement
+		if err := rows.Scan(&m.ID, &m.ProductID, &m.Type, &m.Location, &m.Quantity, &m.Reason, &m.QuantityAfter, &m.ReservedAfter, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("erro ao ler movimentação: %w", err)
+		}
+		movements = append(movements, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao iterar sobre movimentações: %w", err)
+	}
+	return movements, nil
+}
+
+// productExists verifica se o produto existe, inclusive na lixeira (o histórico continua consultável)
+func productExists(ctx context.Context, db dbExecutor, id int) (bool, error) {
+	var exists bool
+	query := "SELECT EXISTS(SELECT 1 FROM products WHERE id = ?)"
+	if err := db.QueryRowContext(ctx, query, id).Scan(&exists); err != nil {
+		return false, fmt.Errorf("erro ao verificar existência do produto %d: %w", id, err)
+	}
+	return exists, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// productStreamPath é o GET /products/stream. Fica fora do histograma de duração do
+// prometheusMiddleware: a conexão dura enquanto o cliente estiver conectado.
+const productStreamPath = "/products/stream"
+
+const (
+	// streamBacklogSize é quantos eventos ficam em memória para o Last-Event-ID
+	streamBacklogSize = 1000
+	// streamSubscriberBuffer é quantos eventos um cliente lento pode acumular antes de ser desconectado
+	streamSubscriberBuffer  = 64
+	streamHeartbeatInterval = 15 * time.Second
+)
+
+// streamResetEvent avisa o cliente que o Last-Event-ID não está mais no backlog
+// (ou é de outra execução da API): ele deve recarregar GET /products
+const streamResetEvent = "stream.reset"
+
+// streamEvent é um evento já serializado do stream
+type streamEvent struct {
+	ID    uint64
+	Event string
+	Data  []byte
+}
+
+// productStream distribui os eventos de produto para os clientes do GET /products/stream.
+// É em memória: cada instância da API só vê as escritas que ela mesma processou.
+type productStream struct {
+	mu          sync.Mutex
+	epoch       string // identifica esta execução; vai no id do evento
+	nextID      uint64
+	backlog     []streamEvent
+	subscribers map[chan streamEvent]struct{}
+}
+
+func newProductStream() *productStream {
+	return &productStream{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		subscribers: map[chan streamEvent]struct{}{},
+	}
+}
+
+// eventID formata o id enviado no campo id: do SSE
+func (s *productStream) eventID(id uint64) string {
+	return s.epoch + "-" + strconv.FormatUint(id, 10)
+}
+
+// publish envia o evento a todos os clientes. Um cliente com o buffer cheio é desconectado
+// (o canal é fechado) para não atrasar os demais; ele retoma com o Last-Event-ID.
+func (s *productStream) publish(event string, p product) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		logrus.WithError(err).WithField("event", event).Error("Erro ao serializar evento do stream de produtos")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	ev := streamEvent{ID: s.nextID, Event: event, Data: data}
+	s.backlog = append(s.backlog, ev)
+	if len(s.backlog) > streamBacklogSize {
+		s.backlog = s.backlog[len(s.backlog)-streamBacklogSize:]
+	}
+	for ch := range s.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe registra um cliente. lastEventID vazio começa do próximo evento; um id conhecido
+// devolve os eventos perdidos em replay. reset indica que não foi possível retomar; nesse caso
+// current é o último evento publicado, a partir do qual o cliente passa a acompanhar.
+func (s *productStream) subscribe(lastEventID string) (ch chan streamEvent, replay []streamEvent, reset bool, current uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if lastEventID != "" {
+		epoch, seq, _ := strings.Cut(lastEventID, "-")
+		last, err := strconv.ParseUint(seq, 10, 64)
+		switch {
+		case err != nil || epoch != s.epoch || last > s.nextID:
+			reset = true
+		case len(s.backlog) > 0 && last+1 < s.backlog[0].ID:
+			// Eventos entre last e o início do backlog já foram descartados
+			reset = true
+		default:
+			for _, ev := range s.backlog {
+				if ev.ID > last {
+					replay = append(replay, ev)
+				}
+			}
+		}
+	}
+
+	ch = make(chan streamEvent, streamSubscriberBuffer)
+	s.subscribers[ch] = struct{}{}
+	productStreamSubscribers.Inc()
+	return ch, replay, reset, s.nextID
+}
+
+// unsubscribe remove o cliente (o canal pode já ter sido fechado por publish)
+func (s *productStream) unsubscribe(ch chan streamEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscribers[ch]; ok {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+	productStreamSubscribers.Dec()
+}
+
+// writeStreamEvent escreve um evento no formato text/event-stream
+func writeStreamEvent(w http.ResponseWriter, id, event string, data []byte) error {
+	_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, event, data)
+	return err
+}
+
+// --- Eventos de produto pendentes da transação ---
+
+// pendingProductEvents guarda os eventos de produto de uma requisição até o commit:
+// o stream e o log de low_stock só mostram escritas que realmente foram gravadas
+type pendingProductEvents struct {
+	mu     sync.Mutex
+	events []pendingProductEvent
+}
+
+type pendingProductEvent struct {
+	event   string
+	product product
+	before  stockLevel // só no low_stock
+}
+
+type pendingProductEventsKey struct{}
+
+// productEventsMiddleware coloca na requisição a lista de eventos pendentes
+func productEventsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(contextWithPendingProductEvents(r.Context())))
+	})
+}
+
+// contextWithPendingProductEvents cria a lista de eventos pendentes de uma requisição (HTTP ou gRPC)
+func contextWithPendingProductEvents(ctx context.Context) context.Context {
+	return context.WithValue(ctx, pendingProductEventsKey{}, &pendingProductEvents{})
+}
+
+// takePendingProductEvents devolve e limpa os eventos pendentes da requisição
+func takePendingProductEvents(ctx context.Context) []pendingProductEvent {
+	pending, ok := ctx.Value(pendingProductEventsKey{}).(*pendingProductEvents)
+	if !ok {
+		return nil
+	}
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+	events := pending.events
+	pending.events = nil
+	return events
+}
+
+// recordProductEvent registra um evento de produto na transação da escrita: enfileira os
+// webhooks (outbox) e guarda o evento para o stream, publicado pelo App após o commit
+func recordProductEvent(ctx context.Context, db dbExecutor, event string, p product) error {
+	if err := enqueueWebhookEvent(ctx, db, event, p); err != nil {
+		return err
+	}
+	holdProductEvent(ctx, pendingProductEvent{event: event, product: p})
+	return nil
+}
+
+// holdProductEvent guarda um evento que só vale se a transação da requisição fizer commit.
+// Devolve false fora de uma requisição (sem lista de eventos pendentes no contexto).
+func holdProductEvent(ctx context.Context, ev pendingProductEvent) bool {
+	pending, ok := ctx.Value(pendingProductEventsKey{}).(*pendingProductEvents)
+	if !ok {
+		return false
+	}
+	pending.mu.Lock()
+	pending.events = append(pending.events, ev)
+	pending.mu.Unlock()
+	return true
+}
+
+// publishCommitted publica no stream os eventos pendentes de uma transação (e emite o log
+// dos low_stock). Chamado depois do commit com committed=true, ou após o rollback para descartá-los.
+func (s *productStream) publishCommitted(ctx context.Context, committed bool) {
+	for _, ev := range takePendingProductEvents(ctx) {
+		if !committed {
+			continue
+		}
+		if ev.event == lowStockEvent {
+			logLowStock(ctx, ev.product, ev.before)
+			continue
+		}
+		s.publish(ev.event, ev.product)
+	}
+}
+
+// commitTx faz o commit de tx e publica os eventos de produto pendentes
+func (app *App) commitTx(ctx context.Context, tx *sql.Tx) error {
+	err := tx.Commit()
+	app.Stream.publishCommitted(ctx, err == nil)
+	return err
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Validação declarativa: cada recurso lista seus campos com as regras de cada um. Todas as regras
+// rodam e as violações voltam juntas, por campo do JSON.
+
+// rule confere um valor e devolve a violação, ou "" quando o valor passa.
+// As regras de formato aceitam o valor vazio: a presença é conferida pelo required.
+type rule[V any] func(V) string
+
+// fieldValidator confere um campo de T e devolve as violações
+type fieldValidator[T any] interface {
+	check(T) (field string, messages []string)
+}
+
+type fieldRules[T, V any] struct {
+	name  string
+	value func(T) V
+	rules []rule[V]
+}
+
+func (f fieldRules[T, V]) check(v T) (string, []string) {
+	value := f.value(v)
+	var messages []string
+	for _, r := range f.rules {
+		if message := r(value); message != "" {
+			messages = append(messages, message)
+		}
+	}
+	return f.name, messages
+}
+
+// field declara as regras de um campo; value extrai o valor do campo de T
+func field[T, V any](name string, value func(T) V, rules ...rule[V]) fieldValidator[T] {
+	return fieldRules[T, V]{name: name, value: value, rules: rules}
+}
+
+// validationError reúne as violações de um recurso, por campo. O sendError a devolve como
+// validation-error, com uma entrada em errors para cada mensagem.
+type validationError struct {
+	resource string
+	fields   map[string][]string
+}
+
+func (e *validationError) Error() string {
+	parts := make([]string, 0, len(e.fields))
+	for _, name := range sortedKeys(e.fields) {
+		parts = append(parts, name+": "+strings.Join(e.fields[name], ", "))
+	}
+	return "invalid " + e.resource + " data: " + strings.Join(parts, "; ")
+}
+
+// fieldErrors devolve as violações no formato do errors do problem+json, ordenadas por campo
+func (e *validationError) fieldErrors() []fieldError {
+	var errs []fieldError
+	for _, name := range sortedKeys(e.fields) {
+		for _, message := range e.fields[name] {
+			errs = append(errs, fieldError{Field: name, Message: message})
+		}
+	}
+	return errs
+}
+
+// validateFields roda as regras de todos os campos e conta as falhas por campo
+func validateFields[T any](resource string, v T, validators []fieldValidator[T]) error {
+	fields := map[string][]string{}
+	for _, validator := range validators {
+		name, messages := validator.check(v)
+		if len(messages) > 0 {
+			fields[name] = append(fields[name], messages...)
+			validationFailuresTotal.With(prometheus.Labels{"resource": resource, "field": name}).Inc()
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return &validationError{resource: resource, fields: fields}
+}
+
+// --- Regras ---
+
+func required(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "is required"
+	}
+	return ""
+}
+
+func trimmed(s string) string {
+	if strings.TrimSpace(s) != "" && strings.TrimSpace(s) != s {
+		return "must not have leading or trailing whitespace"
+	}
+	return ""
+}
+
+func maxRunes(n int) rule[string] {
+	return func(s string) string {
+		if utf8.RuneCountInString(s) > n {
+			return fmt.Sprintf("must have at most %d characters", n)
+		}
+		return ""
+	}
+}
+
+// matches confere o valor com pattern; message é a violação
+func matches(pattern *regexp.Regexp, message string) rule[string] {
+	return func(s string) string {
+		if s != "" && !pattern.MatchString(s) {
+			return message
+		}
+		return ""
+	}
+}
+
+// oneOf aceita as chaves de allowed
+func oneOf[V any](allowed map[string]V) rule[string] {
+	return func(s string) string {
+		if _, ok := allowed[s]; !ok {
+			return "must be one of " + strings.Join(sortedKeys(allowed), ", ")
+		}
+		return ""
+	}
+}
+
+func between(lo, hi int) rule[int] {
+	return func(n int) string {
+		if n < lo || n > hi {
+			return fmt.Sprintf("must be between %d and %d", lo, hi)
+		}
+		return ""
+	}
+}
+
+// --- Produto ---
+
+// Limites do produto. O nome acompanha a coluna VARCHAR(255).
+const (
+	maxProductNameLength = 255
+	maxProductQuantity   = 1_000_000
+)
+
+// productNamePattern: letras (inclusive acentuadas), números, espaço e a pontuação comum em nomes de produto
+var productNamePattern = regexp.MustCompile(`^[\p{L}\p{M}\p{N} .,:;/()&+'"#%*_-]*$`)
+
+// productValidators são as regras do produto, usadas no POST, PUT e PATCH, no lote, no import e no gRPC
+var productValidators = []fieldValidator[product]{
+	field("sku", func(p product) string { return p.SKU },
+		required,
+		matches(skuPattern, "must have up to 64 letters, digits, '.', '-' or '_', starting with a letter or digit"),
+	),
+	field("name", func(p product) string { return p.Name },
+		required,
+		trimmed,
+		maxRunes(maxProductNameLength),
+		matches(productNamePattern, `may only contain letters, digits, spaces and . , : ; / ( ) & + ' " # % * _ -`),
+	),
+	field("quantity", func(p product) int { return p.Quantity }, between(0, maxProductQuantity)),
+	field("price", func(p product) product { return p }, priceNotNegative, pricePrecision, priceMagnitude),
+	field("currency", product.currencyCode, oneOf(currencyMinorUnits)),
+	field("reorder_threshold", func(p product) int { return p.ReorderThreshold }, between(0, maxProductQuantity)),
+}
+
+func priceNotNegative(p product) string {
+	if p.Price.isNegative() {
+		return "must not be negative"
+	}
+	return ""
+}
+
+// pricePrecision confere as casas decimais pela moeda; moeda inválida é apontada no próprio campo
+func pricePrecision(p product) string {
+	digits, ok := currencyMinorUnits[p.currencyCode()]
+	if ok && p.Price.scale > digits {
+		return fmt.Sprintf("must have at most %d decimal places for %s", digits, p.currencyCode())
+	}
+	return ""
+}
+
+func priceMagnitude(p product) string {
+	if p.Price.integerDigits() > maxPriceIntegerDigits {
+		return fmt.Sprintf("must have at most %d integer digits", maxPriceIntegerDigits)
+	}
+	return ""
+}
+
+// validate aplica as regras de negócio do produto; o erro é um *validationError com todas as violações
+func (p product) validate() error {
+	return validateFields("product", p, productValidators)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProductValidate(t *testing.T) {
+	valid := func() product {
+		price, _ := parseMoney("4500.00")
+		return product{SKU: "NB-001", Name: "Notebook 15\" (i7)", Quantity: 10, Price: price, Currency: "BRL", ReorderThreshold: 2}
+	}
+	price := func(s string) money {
+		m, _ := parseMoney(s)
+		return m
+	}
+
+	tests := []struct {
+		name   string
+		change func(p *product)
+		want   map[string][]string
+	}{
+		{name: "produto válido", change: func(p *product) {}},
+		{name: "moeda padrão", change: func(p *product) { p.Currency = "" }},
+		{name: "nome com acento", change: func(p *product) { p.Name = "Câmera ação & Cia. 50%" }},
+		{name: "moeda sem casas decimais", change: func(p *product) { p.Currency = "JPY"; p.Price = price("4500") }},
+		{name: "moeda com 3 casas", change: func(p *product) { p.Currency = "KWD"; p.Price = price("12.345") }},
+		{name: "limites", change: func(p *product) { p.Quantity = maxProductQuantity; p.ReorderThreshold = 0 }},
+		{
+			name:   "obrigatórios",
+			change: func(p *product) { p.SKU = ""; p.Name = "  " },
+			want:   map[string][]string{"sku": {"is required"}, "name": {"is required"}},
+		},
+		{
+			name:   "sku inválido",
+			change: func(p *product) { p.SKU = "-NB 001" },
+			want:   map[string][]string{"sku": {"must have up to 64 letters, digits, '.', '-' or '_', starting with a letter or digit"}},
+		},
+		{
+			name:   "várias violações no mesmo campo",
+			change: func(p *product) { p.Name = " Notebook <pro>" },
+			want: map[string][]string{"name": {
+				"must not have leading or trailing whitespace",
+				`may only contain letters, digits, spaces and . , : ; / ( ) & + ' " # % * _ -`,
+			}},
+		},
+		{
+			name:   "nome longo",
+			change: func(p *product) { p.Name = strings.Repeat("á", maxProductNameLength+1) },
+			want:   map[string][]string{"name": {"must have at most 255 characters"}},
+		},
+		{
+			name:   "quantidades fora da faixa",
+			change: func(p *product) { p.Quantity = -1; p.ReorderThreshold = maxProductQuantity + 1 },
+			want: map[string][]string{
+				"quantity":          {"must be between 0 and 1000000"},
+				"reorder_threshold": {"must be between 0 and 1000000"},
+			},
+		},
+		{
+			name:   "preço negativo com casas demais",
+			change: func(p *product) { p.Price = price("-1.999") },
+			want:   map[string][]string{"price": {"must not be negative", "must have at most 2 decimal places for BRL"}},
+		},
+		{
+			name:   "casas decimais pela moeda",
+			change: func(p *product) { p.Currency = "JPY"; p.Price = price("10.5") },
+			want:   map[string][]string{"price": {"must have at most 0 decimal places for JPY"}},
+		},
+		{
+			name:   "preço grande demais",
+			change: func(p *product) { p.Price = price("1234567890123.00") },
+			want:   map[string][]string{"price": {"must have at most 12 integer digits"}},
+		},
+		{
+			name:   "moeda desconhecida só no campo currency",
+			change: func(p *product) { p.Currency = "XYZ"; p.Price = price("10.12345") },
+			want:   map[string][]string{"currency": {"must be one of " + strings.Join(sortedKeys(currencyMinorUnits), ", ")}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid()
+			tt.change(&p)
+			err := p.validate()
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("erro inesperado: %v", err)
+				}
+				return
+			}
+			var invalid *validationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("erro = %v, esperado *validationError", err)
+			}
+			if invalid.resource != "product" || !reflect.DeepEqual(invalid.fields, tt.want) {
+				t.Errorf("violações = %v, esperado %v", invalid.fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationErrorMessage(t *testing.T) {
+	err := &validationError{resource: "product", fields: map[string][]string{
+		"price": {"must not be negative"},
+		"name":  {"is required", "must have at most 255 characters"},
+	}}
+	want := "invalid product data: name: is required, must have at most 255 characters; price: must not be negative"
+	if err.Error() != want {
+		t.Errorf("Error() = %s", err)
+	}
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		check string
+		want  string
+	}{
+		{name: "required vazio", check: required(""), want: "is required"},
+		{name: "required preenchido", check: required("a")},
+		{name: "trimmed vazio", check: trimmed("")},
+		{name: "trimmed com espaço", check: trimmed("a "), want: "must not have leading or trailing whitespace"},
+		{name: "maxRunes conta runas", check: maxRunes(3)("ção")},
+		{name: "maxRunes excedido", check: maxRunes(2)("ção"), want: "must have at most 2 characters"},
+		{name: "matches aceita vazio", check: matches(skuPattern, "inválido")("")},
+		{name: "matches recusa", check: matches(skuPattern, "inválido")("a b"), want: "inválido"},
+		{name: "oneOf", check: oneOf(map[string]int{"a": 1, "b": 2})("c"), want: "must be one of a, b"},
+		{name: "between", check: between(1, 3)(4), want: "must be between 1 and 3"},
+		{name: "between no limite", check: between(1, 3)(3)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.check != tt.want {
+				t.Errorf("violação = %q, esperado %q", tt.check, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,650 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Eventos de produto enviados aos webhooks
+const (
+	webhookProductCreated  = "product.created"
+	webhookProductUpdated  = "product.updated"
+	webhookProductDeleted  = "product.deleted"
+	webhookProductRestored = "product.restored"
+)
+
+var webhookEvents = map[string]bool{
+	webhookProductCreated:  true,
+	webhookProductUpdated:  true,
+	webhookProductDeleted:  true,
+	webhookProductRestored: true,
+}
+
+// Status de uma entrega em webhook_deliveries. dead é a dead-letter list: esgotou as tentativas.
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryDead      = "dead"
+)
+
+// Headers das requisições enviadas aos webhooks
+const (
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookIDHeader        = "X-Webhook-Id"
+	webhookSignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	webhookRetryBaseDelay = 10 * time.Second
+	webhookRetryMaxDelay  = 1 * time.Hour
+	// webhookDeliveryLease é o tempo que uma entrega fica reservada para quem a está enviando
+	webhookDeliveryLease   = 1 * time.Minute
+	webhookMinSecretLength = 16
+)
+
+var errWebhookNotFound = errors.New("webhook subscription not found")
+var errDeliveryNotDead = errors.New("delivery not found in the dead-letter list")
+var errWebhookPrivateHost = errors.New("invalid webhook data: url must point to a public host")
+
+// webhookBlockedPrefixes são as faixas não públicas que net/netip não classifica:
+// "esta rede", CGNAT (RFC 6598) e benchmarking (RFC 2544)
+var webhookBlockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+}
+
+// webhookAddrAllowed diz se as entregas podem ir para ip. Bloqueia loopback, link-local
+// (inclusive o metadata da cloud em 169.254.169.254), redes privadas (RFC 1918 e fc00::/7),
+// endereço não especificado e multicast, para que um webhook não alcance a rede interna (SSRF).
+func webhookAddrAllowed(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range webhookBlockedPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// webhookDialControl confere o endereço já resolvido de cada conexão. A validação da URL
+// na inscrição não basta: o DNS pode passar a apontar para a rede interna depois dela.
+func webhookDialControl(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !webhookAddrAllowed(addrPort.Addr()) {
+		return fmt.Errorf("conexão com %s bloqueada: %w", address, errWebhookPrivateHost)
+	}
+	return nil
+}
+
+// webhookClient envia as entregas. O transporte do otelhttp cria o span do cliente e
+// injeta o traceparent no header, ligando o receptor ao trace de origem. Sem proxy, toda
+// conexão passa por webhookDialControl, e redirects não são seguidos: a resposta 3xx conta
+// como falha da entrega.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: otelhttp.NewTransport(&http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   webhookDialControl,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}),
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// webhookSubscription é uma inscrição em /webhooks. O secret só é devolvido na criação.
+type webhookSubscription struct {
+	ID        int       `json:"id" xml:"id"`
+	URL       string    `json:"url" xml:"url"`
+	Events    []string  `json:"events" xml:"events>item"`
+	Secret    string    `json:"secret,omitempty" xml:"secret,omitempty"`
+	Active    bool      `json:"active" xml:"active"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+}
+
+// webhookDelivery é uma entrega de evento para uma inscrição
+type webhookDelivery struct {
+	ID             int64     `json:"id" xml:"id"`
+	SubscriptionID int       `json:"subscription_id" xml:"subscription_id"`
+	Event          string    `json:"event" xml:"event"`
+	ProductID      int       `json:"product_id" xml:"product_id"`
+	Status         string    `json:"status" xml:"status"`
+	Attempts       int       `json:"attempts" xml:"attempts"`
+	LastStatusCode *int      `json:"last_status_code,omitempty" xml:"last_status_code,omitempty"`
+	LastError      string    `json:"last_error,omitempty" xml:"last_error,omitempty"`
+	CreatedAt      time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" xml:"updated_at"`
+
+	payload     []byte
+	traceparent string
+	url         string
+	secret      string
+}
+
+// deliveryPage é a resposta do GET /webhooks/dead-letters
+type deliveryPage struct {
+	Deliveries []webhookDelivery `json:"deliveries" xml:"deliveries>item"`
+	pageLimits
+}
+
+func (p deliveryPage) pageItems() interface{} {
+	return p.Deliveries
+}
+
+// webhookPayload é o corpo enviado ao webhook
+type webhookPayload struct {
+	ID         string    `json:"id"`
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Product    product   `json:"product"`
+}
+
+// webhookMaxAttempts lê WEBHOOK_MAX_ATTEMPTS (padrão 8). Depois disso a entrega vai para a dead-letter list.
+func webhookMaxAttempts() int {
+	attempts, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS"))
+	if err != nil || attempts < 1 {
+		attempts = 8
+	}
+	return attempts
+}
+
+// webhookRetryDelay é o backoff exponencial após a tentativa attempt (10s, 20s, 40s... até 1h)
+func webhookRetryDelay(attempt int) time.Duration {
+	delay := webhookRetryBaseDelay
+	for i := 1; i < attempt && delay < webhookRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > webhookRetryMaxDelay {
+		delay = webhookRetryMaxDelay
+	}
+	return delay
+}
+
+// signWebhookPayload assina "timestamp.corpo" com HMAC-SHA256. O timestamp entra na assinatura
+// para que o receptor possa recusar entregas antigas repetidas por terceiros.
+func signWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// randomHex gera n bytes aleatórios em hexadecimal (secrets e ids de evento)
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validate confere a URL e os eventos. Sem secret, um é gerado.
+func (s *webhookSubscription) validate(ctx context.Context) error {
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("invalid webhook data: url must be an absolute http or https URL")
+	}
+	if len(s.URL) > 2048 {
+		return errors.New("invalid webhook data: url is too long")
+	}
+	if err := validateWebhookHost(ctx, u.Hostname()); err != nil {
+		return err
+	}
+	if len(s.Events) == 0 {
+		return errors.New("invalid webhook data: events is required")
+	}
+	seen := map[string]bool{}
+	for _, event := range s.Events {
+		if !webhookEvents[event] {
+			return fmt.Errorf("invalid webhook data: unknown event %q (use product.created, product.updated, product.deleted or product.restored)", event)
+		}
+		if seen[event] {
+			return fmt.Errorf("invalid webhook data: event %q is repeated", event)
+		}
+		seen[event] = true
+	}
+	if s.Secret != "" && (len(s.Secret) < webhookMinSecretLength || len(s.Secret) > 128) {
+		return fmt.Errorf("invalid webhook data: secret must have between %d and 128 characters", webhookMinSecretLength)
+	}
+	return nil
+}
+
+// validateWebhookHost recusa hosts que são (ou resolvem para) endereços não públicos
+func validateWebhookHost(ctx context.Context, host string) error {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if !webhookAddrAllowed(ip) {
+			return errWebhookPrivateHost
+		}
+		return nil
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return errors.New("invalid webhook data: url host could not be resolved")
+	}
+	for _, ip := range ips {
+		if !webhookAddrAllowed(ip) {
+			return errWebhookPrivateHost
+		}
+	}
+	return nil
+}
+
+func (s *webhookSubscription) scanFields(events *string) []interface{} {
+	return []interface{}{&s.ID, &s.URL, events, &s.Active, &s.CreatedAt}
+}
+
+// getWebhookSubscriptions lista as inscrições (sem o secret)
+func getWebhookSubscriptions(ctx context.Context, db dbExecutor) ([]webhookSubscription, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, url, events, active, created_at FROM webhook_subscriptions ORDER BY id")
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao executar QueryContext em getWebhookSubscriptions")
+		return nil, fmt.Errorf("erro ao buscar webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	subscriptions := []webhookSubscription{}
+	for rows.Next() {
+		var s webhookSubscription
+		var events string
+		if err := rows.Scan(s.scanFields(&events)...); err != nil {
+			return nil, fmt.Errorf("erro ao ler webhook: %w", err)
+		}
+		s.Events = strings.Split(events, ",")
+		subscriptions = append(subscriptions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao iterar sobre webhooks: %w", err)
+	}
+	return subscriptions, nil
+}
+
+// getWebhookSubscription busca uma inscrição (sem o secret)
+func (s *webhookSubscription) getWebhookSubscription(ctx context.Context, db dbExecutor) error {
+	var events string
+	query := "SELECT id, url, events, active, created_at FROM webhook_subscriptions WHERE id = ?"
+	err := db.QueryRowContext(ctx, query, s.ID).Scan(s.scanFields(&events)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errWebhookNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao buscar webhook %d: %w", s.ID, err)
+	}
+	s.Events = strings.Split(events, ",")
+	return nil
+}
+
+// createWebhookSubscription grava a inscrição, gerando o secret se ele não foi informado
+func (s *webhookSubscription) createWebhookSubscription(ctx context.Context, db dbExecutor) error {
+	if s.Secret == "" {
+		secret, err := randomHex(32)
+		if err != nil {
+			return fmt.Errorf("erro ao gerar secret do webhook: %w", err)
+		}
+		s.Secret = secret
+	}
+	s.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	query := "INSERT INTO webhook_subscriptions(url, secret, events, active, created_at) VALUES(?,?,?,?,?)"
+	result, err := db.ExecContext(ctx, query, s.URL, s.Secret, strings.Join(s.Events, ","), s.Active, s.CreatedAt)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao executar ExecContext em createWebhookSubscription")
+		return fmt.Errorf("erro ao criar webhook: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("erro ao obter ID do webhook: %w", err)
+	}
+	s.ID = int(id)
+	return nil
+}
+
+// updateWebhookSubscription substitui url, events e active. Um secret informado substitui o atual.
+func (s *webhookSubscription) updateWebhookSubscription(ctx context.Context, db dbExecutor) error {
+	query := "UPDATE webhook_subscriptions SET url = ?, events = ?, active = ?, secret = IF(? = '', secret, ?) WHERE id = ?"
+	_, err := db.ExecContext(ctx, query, s.URL, strings.Join(s.Events, ","), s.Active, s.Secret, s.Secret, s.ID)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao executar ExecContext em updateWebhookSubscription")
+		return fmt.Errorf("erro ao atualizar webhook %d: %w", s.ID, err)
+	}
+	// Sem linhas afetadas, o webhook pode não existir ou nada ter mudado: a releitura diferencia os casos
+	return s.getWebhookSubscription(ctx, db)
+}
+
+// deleteWebhookSubscription remove a inscrição e, em cascata, as entregas dela
+func (s *webhookSubscription) deleteWebhookSubscription(ctx context.Context, db dbExecutor) error {
+	result, err := db.ExecContext(ctx, "DELETE FROM webhook_subscriptions WHERE id = ?", s.ID)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao executar ExecContext em deleteWebhookSubscription")
+		return fmt.Errorf("erro ao excluir webhook %d: %w", s.ID, err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return errWebhookNotFound
+	}
+	return nil
+}
+
+// enqueueWebhookEvent grava uma entrega pendente para cada inscrição ativa no evento.
+// Usa a mesma transação da escrita do produto (outbox): se a escrita for desfeita, nenhum
+// webhook é disparado. O traceparent da requisição é gravado para a entrega continuar o trace.
+func enqueueWebhookEvent(ctx context.Context, db dbExecutor, event string, p product) error {
+	rows, err := db.QueryContext(ctx, "SELECT id FROM webhook_subscriptions WHERE active AND FIND_IN_SET(?, events) > 0", event)
+	if err != nil {
+		return fmt.Errorf("erro ao buscar webhooks do evento %s: %w", event, err)
+	}
+	subscriptionIDs := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("erro ao ler webhook do evento %s: %w", event, err)
+		}
+		subscriptionIDs = append(subscriptionIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("erro ao iterar sobre webhooks do evento %s: %w", event, err)
+	}
+	if len(subscriptionIDs) == 0 {
+		return nil
+	}
+
+	eventID, err := randomHex(16)
+	if err != nil {
+		return fmt.Errorf("erro ao gerar id do evento: %w", err)
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	payload, err := json.Marshal(webhookPayload{ID: eventID, Event: event, OccurredAt: now, Product: p})
+	if err != nil {
+		return fmt.Errorf("erro ao serializar evento %s: %w", event, err)
+	}
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	var traceparent interface{} // NULL fora de uma requisição rastreada
+	if tp := carrier.Get("traceparent"); tp != "" {
+		traceparent = tp
+	}
+
+	query := `INSERT INTO webhook_deliveries(subscription_id, event, product_id, payload, traceparent, status, next_attempt_at, created_at, updated_at)
+		VALUES(?,?,?,?,?,?,?,?,?)`
+	for _, id := range subscriptionIDs {
+		if _, err := db.ExecContext(ctx, query, id, event, p.ID, string(payload), traceparent, deliveryPending, now, now, now); err != nil {
+			logrus.WithContext(ctx).WithError(err).WithFields(logrus.Fields{
+				"component":       "database",
+				"subscription_id": id,
+				"event":           event,
+			}).Error("Erro ao enfileirar entrega de webhook")
+			return fmt.Errorf("erro ao enfileirar webhook %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// getDueWebhookDeliveries busca as entregas pendentes cujo horário de tentativa já chegou
+func getDueWebhookDeliveries(ctx context.Context, db dbExecutor, limit int) ([]int64, error) {
+	query := `SELECT d.id FROM webhook_deliveries d JOIN webhook_subscriptions s ON s.id = d.subscription_id
+		WHERE d.status = ? AND d.next_attempt_at <= ? AND s.active ORDER BY d.next_attempt_at LIMIT ?`
+	rows, err := db.QueryContext(ctx, query, deliveryPending, time.Now().UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao buscar entregas de webhook pendentes: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("erro ao ler entrega de webhook: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// claimWebhookDelivery reserva a entrega por webhookDeliveryLease e a carrega com a URL e o secret.
+// Devolve sql.ErrNoRows se outra instância já a reservou. Se a instância cair no meio do envio,
+// a entrega volta a ficar disponível quando a reserva vencer.
+func claimWebhookDelivery(ctx context.Context, db dbExecutor, id int64) (webhookDelivery, error) {
+	d := webhookDelivery{ID: id}
+	now := time.Now().UTC()
+	query := "UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = ? AND status = ? AND next_attempt_at <= ?"
+	result, err := db.ExecContext(ctx, query, now.Add(webhookDeliveryLease), id, deliveryPending, now)
+	if err != nil {
+		return d, fmt.Errorf("erro ao reservar entrega de webhook %d: %w", id, err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return d, sql.ErrNoRows
+	}
+
+	query = `SELECT d.subscription_id, d.event, d.product_id, d.payload, COALESCE(d.traceparent, ''), d.attempts, s.url, s.secret
+		FROM webhook_deliveries d JOIN webhook_subscriptions s ON s.id = d.subscription_id WHERE d.id = ?`
+	err = db.QueryRowContext(ctx, query, id).Scan(&d.SubscriptionID, &d.Event, &d.ProductID, &d.payload, &d.traceparent, &d.Attempts, &d.url, &d.secret)
+	if err != nil {
+		return d, fmt.Errorf("erro ao carregar entrega de webhook %d: %w", id, err)
+	}
+	return d, nil
+}
+
+// finishWebhookAttempt grava o resultado da tentativa: entregue, nova tentativa com backoff,
+// ou dead-letter quando as tentativas se esgotam. Devolve o novo status.
+func finishWebhookAttempt(ctx context.Context, db dbExecutor, d webhookDelivery, statusCode int, deliveryErr error) (string, error) {
+	now := time.Now().UTC()
+	attempts := d.Attempts + 1
+	var code interface{}
+	if statusCode > 0 {
+		code = statusCode
+	}
+
+	status, nextAttempt, lastError := deliveryDelivered, now, ""
+	if deliveryErr != nil {
+		lastError = deliveryErr.Error()
+		if len(lastError) > 1024 {
+			lastError = lastError[:1024]
+		}
+		status, nextAttempt = deliveryPending, now.Add(webhookRetryDelay(attempts))
+		if attempts >= webhookMaxAttempts() {
+			status = deliveryDead
+		}
+	}
+
+	query := `UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, last_status_code = ?,
+		last_error = NULLIF(?, ''), updated_at = ? WHERE id = ?`
+	if _, err := db.ExecContext(ctx, query, status, attempts, nextAttempt, code, lastError, now, d.ID); err != nil {
+		return status, fmt.Errorf("erro ao gravar tentativa da entrega de webhook %d: %w", d.ID, err)
+	}
+	return status, nil
+}
+
+// sendWebhook faz o POST assinado. O contexto traz o trace de origem, recuperado do traceparent gravado.
+func sendWebhook(ctx context.Context, d webhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(d.payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "inventory-app-webhooks")
+	req.Header.Set(webhookEventHeader, d.Event)
+	req.Header.Set(webhookIDHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(webhookSignatureHeader, signWebhookPayload(d.secret, time.Now().Unix(), d.payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook respondeu %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// deliverWebhook envia uma entrega reservada, em um span filho da requisição que gerou o evento
+func deliverWebhook(db dbExecutor, d webhookDelivery) {
+	ctx := context.Background()
+	if d.traceparent != "" {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier{"traceparent": d.traceparent})
+	}
+	ctx, span := otel.Tracer("inventory-app").Start(ctx, "webhook.deliver",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.Int64("webhook.delivery_id", d.ID),
+			attribute.Int("webhook.subscription_id", d.SubscriptionID),
+			attribute.String("webhook.event", d.Event),
+			attribute.Int("webhook.attempt", d.Attempts+1),
+		))
+	defer span.End()
+
+	sendCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	start := time.Now()
+	statusCode, deliveryErr := sendWebhook(sendCtx, d)
+	cancel()
+	webhookDeliveryDuration.WithLabelValues(d.Event).Observe(time.Since(start).Seconds())
+	if deliveryErr != nil {
+		span.RecordError(deliveryErr)
+		span.SetStatus(codes.Error, "webhook delivery failed")
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	status, err := finishWebhookAttempt(dbCtx, db, d, statusCode, deliveryErr)
+	if err != nil {
+		sqlErrorsTotal.Inc()
+		logWithTrace(ctx).WithError(err).Warn("Falha ao gravar resultado da entrega de webhook")
+		return
+	}
+
+	result := status
+	if status == deliveryPending {
+		result = "retry"
+	}
+	webhookDeliveriesTotal.WithLabelValues(d.Event, result).Inc()
+	span.SetAttributes(attribute.String("webhook.result", result))
+
+	entry := logWithTrace(ctx).WithFields(logrus.Fields{
+		"component":       "webhook",
+		"delivery_id":     d.ID,
+		"subscription_id": d.SubscriptionID,
+		"event":           d.Event,
+		"attempt":         d.Attempts + 1,
+		"status_code":     statusCode,
+	})
+	switch status {
+	case deliveryDead:
+		entry.WithError(deliveryErr).Error("Entrega de webhook movida para a dead-letter list")
+	case deliveryPending:
+		entry.WithError(deliveryErr).Warn("Entrega de webhook falhou, nova tentativa agendada")
+	default:
+		entry.Debug("Webhook entregue")
+	}
+}
+
+// getDeadWebhookDeliveries lista a dead-letter list, das entregas mais recentes para as mais antigas
+func getDeadWebhookDeliveries(ctx context.Context, db dbExecutor, limit, offset int) ([]webhookDelivery, error) {
+	query := `SELECT id, subscription_id, event, product_id, status, attempts, last_status_code, COALESCE(last_error, ''), created_at, updated_at
+		FROM webhook_deliveries WHERE status = ? ORDER BY updated_at DESC, id DESC LIMIT ? OFFSET ?`
+	rows, err := db.QueryContext(ctx, query, deliveryDead, limit, offset)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao executar QueryContext em getDeadWebhookDeliveries")
+		return nil, fmt.Errorf("erro ao buscar dead-letters de webhook: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []webhookDelivery{}
+	for rows.Next() {
+		var d webhookDelivery
+		if err := rows.Scan(&d.ID, &d.SubscriptionID, &d.Event, &d.ProductID, &d.Status, &d.Attempts,
+			&d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("erro ao ler entrega de webhook: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("erro ao iterar sobre dead-letters de webhook: %w", err)
+	}
+	return deliveries, nil
+}
+
+// retryDeadWebhookDelivery devolve uma entrega da dead-letter list para a fila, com as tentativas zeradas
+func retryDeadWebhookDelivery(ctx context.Context, db dbExecutor, id int64) error {
+	now := time.Now().UTC()
+	query := "UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ?, updated_at = ? WHERE id = ? AND status = ?"
+	result, err := db.ExecContext(ctx, query, deliveryPending, now, now, id, deliveryDead)
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao executar ExecContext em retryDeadWebhookDelivery")
+		return fmt.Errorf("erro ao reenfileirar entrega de webhook %d: %w", id, err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return errDeliveryNotDead
+	}
+	return nil
+}
+
+// countWebhookDeliveries conta as entregas por status (gauge webhook_deliveries)
+func countWebhookDeliveries(ctx context.Context, db dbExecutor) (map[string]int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT status, COUNT(*) FROM webhook_deliveries WHERE status <> ? GROUP BY status", deliveryDelivered)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao contar entregas de webhook: %w", err)
+	}
+	defer rows.Close()
+
+	counts := map[string]int{deliveryPending: 0, deliveryDead: 0}
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("erro ao ler contagem de entregas de webhook: %w", err)
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
+
+// purgeDeliveredWebhooks remove as entregas concluídas há mais que retention
+func purgeDeliveredWebhooks(ctx context.Context, db dbExecutor, retention time.Duration) (int64, error) {
+	query := "DELETE FROM webhook_deliveries WHERE status = ? AND updated_at < ?"
+	result, err := db.ExecContext(ctx, query, deliveryDelivered, time.Now().UTC().Add(-retention))
+	if err != nil {
+		logrus.WithContext(ctx).WithError(err).Error("Erro ao executar ExecContext em purgeDeliveredWebhooks")
+		return 0, fmt.Errorf("erro ao remover entregas de webhook concluídas: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookSubscriptionValidateHost(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		wantErr error
+	}{
+		{name: "ip público", url: "https://93.184.216.34/hooks"},
+		{name: "ipv6 público", url: "https://[2606:2800:220:1:248:1893:25c8:1946]/hooks"},
+		{name: "loopback", url: "http://127.0.0.1:8080/hooks", wantErr: errWebhookPrivateHost},
+		{name: "localhost", url: "http://localhost/hooks", wantErr: errWebhookPrivateHost},
+		{name: "loopback ipv6", url: "http://[::1]/hooks", wantErr: errWebhookPrivateHost},
+		{name: "metadata da cloud", url: "http://169.254.169.254/latest/meta-data", wantErr: errWebhookPrivateHost},
+		{name: "rfc 1918 10/8", url: "http://10.0.0.5/hooks", wantErr: errWebhookPrivateHost},
+		{name: "rfc 1918 172.16/12", url: "http://172.20.1.1/hooks", wantErr: errWebhookPrivateHost},
+		{name: "rfc 1918 192.168/16", url: "http://192.168.1.10/hooks", wantErr: errWebhookPrivateHost},
+		{name: "ipv6 privado", url: "http://[fd00::1]/hooks", wantErr: errWebhookPrivateHost},
+		{name: "ipv4 mapeado em ipv6", url: "http://[::ffff:127.0.0.1]/hooks", wantErr: errWebhookPrivateHost},
+		{name: "não especificado", url: "http://0.0.0.0/hooks", wantErr: errWebhookPrivateHost},
+		{name: "cgnat", url: "http://100.64.0.1/hooks", wantErr: errWebhookPrivateHost},
+		{name: "multicast", url: "http://224.0.0.1/hooks", wantErr: errWebhookPrivateHost},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := webhookSubscription{URL: tt.url, Events: []string{webhookProductCreated}}
+			err := s.validate(context.Background())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("erro = %v, esperado %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhookClientBlocksPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a entrega não deveria chegar ao servidor local")
+	}))
+	defer server.Close()
+
+	_, err := webhookClient.Get(server.URL)
+	if !errors.Is(err, errWebhookPrivateHost) {
+		t.Fatalf("erro = %v, esperado %v", err, errWebhookPrivateHost)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "https://93.184.216.34/hooks", nil)
+	if err := webhookClient.CheckRedirect(req, []*http.Request{req}); !errors.Is(err, http.ErrUseLastResponse) {
+		t.Errorf("redirect = %v, esperado %v", err, http.ErrUseLastResponse)
+	}
+}